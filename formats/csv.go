@@ -0,0 +1,178 @@
+package formats
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSV and TSV files are read with encoding/csv, which also tells where
+// each field starts in the input. The first record is the header naming
+// the columns. Only the cells of the selected columns are processed, each
+// on its own, and everything else is copied byte for byte: delimiters,
+// line endings, and the quoting of every cell the processor leaves alone.
+// A quoted cell stays quoted; an unquoted one is quoted only when the
+// processed text needs it.
+
+// delimited runs the processor over the selected columns of a CSV or
+// TSV file
+func (d *document) delimited(input string, comma rune, opts Options) error {
+	r := csv.NewReader(strings.NewReader(input))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		d.verbatim(input)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV: %w", err)
+	}
+	columns, err := selectColumns(header, opts.Columns)
+	if err != nil {
+		return err
+	}
+
+	lines := lineStarts(input)
+	pos := 0
+	for row := 0; ; row++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CSV: %w", err)
+		}
+		for i := range record {
+			if i >= len(columns) || !columns[i] {
+				continue
+			}
+			line, column := r.FieldPos(i)
+			start := lines[line-1] + column - 1
+			end := csvFieldEnd(input, start, comma)
+			d.verbatim(input[pos:start])
+			d.cell(input[start:end], start, comma, len(record) == 1, formatPath([]pathElem{{index: row, isIndex: true}, {key: header[i]}}))
+			pos = end
+		}
+	}
+	d.verbatim(input[pos:])
+	return nil
+}
+
+// selectColumns maps the column names to process onto the header. No
+// names selects every column.
+func selectColumns(header, names []string) ([]bool, error) {
+	columns := make([]bool, len(header))
+	if len(names) == 0 {
+		for i := range columns {
+			columns[i] = true
+		}
+		return columns, nil
+	}
+	for _, name := range names {
+		found := false
+		for i, h := range header {
+			if strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")) == strings.TrimSpace(name) {
+				columns[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("column %q is not in the header", name)
+		}
+	}
+	return columns, nil
+}
+
+// cell processes the field raw found at offset. alone tells that the
+// field is the whole record, which an empty field can't be unquoted.
+func (d *document) cell(raw string, offset int, comma rune, alone bool, path string) {
+	quoted := strings.HasPrefix(raw, `"`)
+	b := newSegment(offset)
+	content := raw
+	if quoted {
+		b = newSegment(offset + 1)
+		b.escape = escapeCSVQuoted
+		content = strings.TrimSuffix(raw[1:], `"`)
+		for {
+			i := strings.Index(content, `""`)
+			if i < 0 {
+				break
+			}
+			b.prose(content[:i])
+			b.decoded(`"`, `""`)
+			content = content[i+2:]
+		}
+	}
+	b.prose(content)
+
+	t := d.transform(d.singleLine(), b.segment())
+	if !t.changed {
+		d.verbatim(raw)
+		return
+	}
+	d.edits = append(d.edits, Edit{Path: path, Before: t.before, After: t.after})
+	switch {
+	case quoted:
+		d.verbatim(`"` + t.text + `"`)
+	case t.after == "" && alone:
+		d.verbatim(`""`)
+	case strings.ContainsAny(t.after, "\"\r\n"+string(comma)):
+		d.verbatim(encodeCSVField(t.after, comma))
+	default:
+		d.verbatim(t.after)
+	}
+}
+
+// csvFieldEnd returns the end of the field starting at start
+func csvFieldEnd(input string, start int, comma rune) int {
+	if strings.HasPrefix(input[start:], `"`) {
+		for i := start + 1; i < len(input); i++ {
+			if input[i] != '"' {
+				continue
+			}
+			if i+1 < len(input) && input[i+1] == '"' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+		return len(input)
+	}
+	end := strings.IndexFunc(input[start:], func(r rune) bool {
+		return r == comma || r == '\n' || r == '\r'
+	})
+	if end < 0 {
+		return len(input)
+	}
+	return start + end
+}
+
+// lineStarts returns the offset of every line of input, as encoding/csv
+// counts them: only \n ends a line
+func lineStarts(input string) []int {
+	starts := []int{0}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// encodeCSVField writes value as a quoted field, as encoding/csv does
+func encodeCSVField(value string, comma rune) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Comma = comma
+	w.Write([]string{value})
+	w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// escapeCSVQuoted escapes processed text for the inside of a quoted field
+func escapeCSVQuoted(s string) string {
+	return strings.ReplaceAll(s, `"`, `""`)
+}
@@ -0,0 +1,380 @@
+// Package formats runs the processor over the prose inside structured
+// documents. Each format finds the text a reader sees, hands only that
+// text to fsm.Processor, and writes everything else back untouched.
+package formats
+
+import (
+	"fmt"
+	"go-reloaded/fsm"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format names an input document format
+type Format int
+
+const (
+	// Text is plain prose: the whole input goes through the processor
+	Text Format = iota
+	// Markdown skips code, URLs and HTML and keeps the block structure
+	Markdown
+	// HTML processes text nodes only and writes every tag back as it was
+	HTML
+	// JSON processes string values only, the ones Options.Paths selects
+	JSON
+	// YAML processes string scalars only, the ones Options.Paths selects
+	YAML
+	// SRT processes the text of SubRip subtitle cues
+	SRT
+	// VTT processes the text of WebVTT subtitle cues
+	VTT
+	// CSV processes the cells of the columns Options.Columns selects
+	CSV
+	// TSV is CSV with tabs between the fields
+	TSV
+)
+
+var formatNames = map[Format]string{
+	Text:     "text",
+	Markdown: "markdown",
+	HTML:     "html",
+	JSON:     "json",
+	YAML:     "yaml",
+	SRT:      "srt",
+	VTT:      "vtt",
+	CSV:      "csv",
+	TSV:      "tsv",
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseFormat maps a name such as "markdown" to its Format
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(name, n) {
+			return f, nil
+		}
+	}
+	switch strings.ToLower(name) {
+	case "md":
+		return Markdown, nil
+	case "htm":
+		return HTML, nil
+	case "yml":
+		return YAML, nil
+	case "webvtt":
+		return VTT, nil
+	}
+	return Text, fmt.Errorf("unknown format %q (want text, markdown, html, json, yaml, srt, vtt, csv or tsv)", name)
+}
+
+// Options tunes how a document is read. The zero value processes every
+// piece of prose the format has.
+type Options struct {
+	// Paths selects the values of a JSON or YAML document to process, as
+	// JSONPath expressions such as $.messages.* or $..title. Selecting an
+	// object or array selects every string inside it. Empty selects every
+	// string value.
+	Paths []string
+
+	// SpanCues lets modifiers, a/an and quotes of an SRT or WebVTT file
+	// reach across the cues of one caption block: cues that follow each
+	// other with at most a second between them. Without it every cue is
+	// processed on its own.
+	SpanCues bool
+
+	// Columns names the columns of a CSV or TSV file to process, as
+	// written in its header row. Empty selects every column. The header
+	// itself is never processed.
+	Columns []string
+}
+
+// Result is a processed document with everything the processor reported,
+// positioned in the original document
+type Result struct {
+	Text         string
+	Diagnostics  []fsm.Diagnostic
+	Explanations []fsm.Explanation
+	// Stats adds up what the processor changed over the whole document
+	Stats fsm.Stats
+	// Edits lists the values of a JSON or YAML document, or the cells of
+	// a CSV or TSV file, the processor changed, in document order
+	Edits []Edit
+}
+
+// Edit is a change the processor made to one value of a structured
+// document
+type Edit struct {
+	Path   string // JSONPath of the value, such as $.messages.greeting, or $[2].notes for a cell
+	Before string
+	After  string
+}
+
+func (e Edit) String() string {
+	return fmt.Sprintf("%s: %q -> %q", e.Path, e.Before, e.After)
+}
+
+// Process runs p over the prose of input, read as format
+func Process(p *fsm.Processor, format Format, input string, opts Options) (Result, error) {
+	d := document{p: p}
+	var err error
+	switch format {
+	case Text:
+		text := p.Process(input)
+		return Result{Text: text, Diagnostics: p.Diagnostics(), Explanations: p.Explain(), Stats: p.Stats()}, nil
+	case Markdown:
+		d.markdown(input)
+	case HTML:
+		d.html(input)
+	case JSON:
+		err = d.json(input, opts)
+	case YAML:
+		err = d.yaml(input, opts)
+	case SRT:
+		d.subtitles(input, false, opts)
+	case VTT:
+		d.subtitles(input, true, opts)
+	case CSV:
+		err = d.delimited(input, ',', opts)
+	case TSV:
+		err = d.delimited(input, '\t', opts)
+	default:
+		err = fmt.Errorf("unsupported format %v", format)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return d.result(input), nil
+}
+
+// document collects the output of one Process call
+type document struct {
+	p            *fsm.Processor
+	single       *fsm.Processor // p without wrapping, for text that must stay on one line
+	caption      *fsm.Processor // p with soft line breaks, for subtitle cues
+	out          strings.Builder
+	diagnostics  []fsm.Diagnostic
+	explanations []fsm.Explanation
+	stats        fsm.Stats
+	edits        []Edit
+}
+
+// verbatim copies s to the output unchanged
+func (d *document) verbatim(s string) {
+	d.out.WriteString(s)
+}
+
+// process runs the processor over a segment of running text, such as a
+// paragraph, and writes the result
+func (d *document) process(s segment) {
+	d.verbatim(d.transform(d.p, s).text)
+}
+
+// processLine is process for text that must stay on one line, such as a
+// heading or a table cell: it is never wrapped
+func (d *document) processLine(s segment) {
+	d.verbatim(d.transform(d.singleLine(), s).text)
+}
+
+func (d *document) singleLine() *fsm.Processor {
+	if d.single == nil {
+		opts := d.p.Options()
+		opts.WrapWidth = 0
+		d.single = fsm.NewProcessorWithOptions(opts)
+	}
+	return d.single
+}
+
+// transformed is a segment after processing
+type transformed struct {
+	text    string // What to write in place of the segment
+	before  string // The segment as the processor read it, protected spans put back
+	after   string // The processed segment, protected spans put back
+	changed bool
+}
+
+// transform processes the segment without its surrounding whitespace,
+// which the processor would drop, and puts that whitespace back as it
+// was. A segment the processor leaves alone comes back as its source.
+func (d *document) transform(p *fsm.Processor, s segment) transformed {
+	t := transformed{text: s.source, before: s.restore(s.text)}
+	t.after = t.before
+	core := strings.TrimSpace(s.text)
+	if core == "" {
+		return t
+	}
+	lead := strings.Index(s.text, core)
+	text := p.Process(core)
+	if text != core {
+		before, trail := s.text[:lead], s.text[lead+len(core):]
+		t.after = s.restore(before + text + trail)
+		if s.escape != nil {
+			before, text, trail = s.escape(before), s.escape(text), s.escape(trail)
+		}
+		t.text = s.restore(before + text + trail)
+		t.changed = true
+	}
+	d.stats.Add(p.Stats())
+	for _, diag := range p.Diagnostics() {
+		diag.Offset = s.documentOffset(lead + diag.Offset)
+		d.diagnostics = append(d.diagnostics, diag)
+	}
+	for _, e := range p.Explain() {
+		e.Offset = s.documentOffset(lead + e.Offset)
+		d.explanations = append(d.explanations, e)
+	}
+	return t
+}
+
+func (d *document) result(input string) Result {
+	fsm.LocateDiagnostics(input, d.diagnostics)
+	fsm.LocateExplanations(input, d.explanations)
+	return Result{
+		Text:         d.out.String(),
+		Diagnostics:  d.diagnostics,
+		Explanations: d.explanations,
+		Stats:        d.stats,
+		Edits:        d.edits,
+	}
+}
+
+// placeholderBase is the first of the runes standing in for protected
+// spans. The processor reads the fsm placeholder range as markup: it
+// keeps them with the word they touch without letting modifiers or a/an
+// see them.
+const placeholderBase = int(fsm.FirstPlaceholder)
+
+// maxPlaceholders is how many spans one segment can protect, up to the
+// last rune of the placeholder range
+const maxPlaceholders = int(fsm.LastPlaceholder-fsm.FirstPlaceholder) + 1
+
+// segment is prose cut out of a document at offset. Spans the processor
+// must not touch, such as inline code, are replaced by one placeholder
+// rune each and put back after processing.
+type segment struct {
+	text      string // What the processor sees
+	source    string // The segment as written in the document
+	offset    int
+	protected []span
+	anchors   []anchor
+	escape    func(string) string // Escapes processed text for the format, nil writes it as is
+}
+
+// span is a protected piece of the document
+type span struct {
+	text string
+	at   int // Byte offset of the placeholder in segment.text
+}
+
+// anchor ties a byte offset of segment.text to the source, wherever the
+// two stop running in step: after a placeholder or a decoded entity
+type anchor struct {
+	at     int
+	source int
+}
+
+// segmentBuilder cuts a segment out of prose, protecting spans
+type segmentBuilder struct {
+	text       strings.Builder
+	source     strings.Builder
+	offset     int
+	protected  []span
+	anchors    []anchor
+	escape     func(string) string
+	references *htmlReferences // How characters decoded from HTML references are written back
+}
+
+func newSegment(offset int) *segmentBuilder {
+	return &segmentBuilder{offset: offset}
+}
+
+// prose adds text for the processor. Runes of the input that fall in
+// the placeholder range are protected, so they can't be mistaken for one.
+func (b *segmentBuilder) prose(text string) {
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, fsm.IsPlaceholder)
+		if i < 0 {
+			break
+		}
+		b.text.WriteString(text[:i])
+		b.source.WriteString(text[:i])
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.protect(text[i : i+size])
+		text = text[i+size:]
+	}
+	b.text.WriteString(text)
+	b.source.WriteString(text)
+}
+
+// decoded adds text for the processor that is written as source in the
+// document, such as & for &amp;
+func (b *segmentBuilder) decoded(text, source string) {
+	if strings.IndexFunc(text, fsm.IsPlaceholder) >= 0 {
+		b.protect(source)
+		return
+	}
+	b.text.WriteString(text)
+	b.source.WriteString(source)
+	b.anchors = append(b.anchors, anchor{at: b.text.Len(), source: b.source.Len()})
+}
+
+// protect adds text the processor must leave alone
+func (b *segmentBuilder) protect(text string) {
+	if text == "" {
+		return
+	}
+	if len(b.protected) == maxPlaceholders {
+		// Out of placeholders: the processor sees the rest as it is
+		b.text.WriteString(text)
+		b.source.WriteString(text)
+		return
+	}
+	b.protected = append(b.protected, span{text: text, at: b.text.Len()})
+	b.text.WriteRune(rune(placeholderBase + len(b.protected) - 1))
+	b.source.WriteString(text)
+	b.anchors = append(b.anchors, anchor{at: b.text.Len(), source: b.source.Len()})
+}
+
+func (b *segmentBuilder) segment() segment {
+	return segment{
+		text:      b.text.String(),
+		source:    b.source.String(),
+		offset:    b.offset,
+		protected: b.protected,
+		anchors:   b.anchors,
+		escape:    b.escape,
+	}
+}
+
+// restore replaces the placeholders in processed text by their spans
+func (s segment) restore(text string) string {
+	if len(s.protected) == 0 {
+		return text
+	}
+	var sb strings.Builder
+	for _, r := range text {
+		if i := int(r) - placeholderBase; i >= 0 && i < len(s.protected) {
+			sb.WriteString(s.protected[i].text)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// documentOffset maps a byte offset in the segment text to the document
+func (s segment) documentOffset(offset int) int {
+	source := offset
+	for _, a := range s.anchors {
+		if a.at > offset {
+			break
+		}
+		source = a.source + offset - a.at
+	}
+	return s.offset + source
+}
@@ -0,0 +1,430 @@
+package formats
+
+import (
+	"go-reloaded/formatters"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// HTML is read with a tolerant tokenizer: anything that doesn't look like
+// a tag is text, and a document is never rejected. Text between inline
+// elements such as <em> and <a> is processed as one run, with their tags
+// protected, while block elements end the run. Tags are written back
+// byte for byte, so attributes are never touched.
+
+// htmlKind classifies an htmlToken
+type htmlKind uint8
+
+const (
+	htmlText     htmlKind = iota
+	htmlStartTag          // <p class="x">, <br/>
+	htmlEndTag            // </p>
+	htmlComment           // <!-- -->, <!DOCTYPE html>, <?xml ?>, <![CDATA[ ]]>
+	htmlRawText           // The content of <script> and <style>
+)
+
+// htmlToken is a piece of an HTML document
+type htmlToken struct {
+	kind        htmlKind
+	raw         string
+	name        string // Lower-case tag name
+	selfClosing bool
+	offset      int
+}
+
+// htmlInline lists the elements that don't interrupt running text
+var htmlInline = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true,
+	"data": true, "del": true, "dfn": true, "em": true, "font": true, "i": true,
+	"img": true, "ins": true, "label": true, "mark": true, "q": true, "s": true,
+	"small": true, "span": true, "strike": true, "strong": true, "sub": true,
+	"sup": true, "time": true, "u": true, "var": true, "wbr": true,
+}
+
+// htmlVerbatim lists the elements whose content is never processed. The
+// value tells whether the element sits inside running text, as code does,
+// instead of being a block of its own.
+var htmlVerbatim = map[string]bool{
+	"code": true, "kbd": true, "samp": true,
+	"pre": false, "script": false, "style": false, "textarea": false,
+}
+
+// htmlTokenizer splits an HTML document into tokens
+type htmlTokenizer struct {
+	input string
+	pos   int
+	raw   string // Name of the element whose raw text comes next
+}
+
+func (t *htmlTokenizer) next() (htmlToken, bool) {
+	if t.pos >= len(t.input) {
+		return htmlToken{}, false
+	}
+	start := t.pos
+	if t.raw != "" {
+		end := indexFold(t.input[start:], "</"+t.raw)
+		if end < 0 {
+			end = len(t.input) - start
+		}
+		t.raw = ""
+		if end > 0 {
+			t.pos = start + end
+			return htmlToken{kind: htmlRawText, raw: t.input[start:t.pos], offset: start}, true
+		}
+	}
+	if tok, ok := markupAt(t.input, start); ok {
+		t.pos += len(tok.raw)
+		if tok.kind == htmlStartTag && !tok.selfClosing && (tok.name == "script" || tok.name == "style") {
+			t.raw = tok.name
+		}
+		return tok, true
+	}
+	// Text runs up to the next < that starts markup
+	end := start + 1
+	for end < len(t.input) {
+		i := strings.IndexByte(t.input[end:], '<')
+		if i < 0 {
+			end = len(t.input)
+			break
+		}
+		end += i
+		if _, ok := markupAt(t.input, end); ok {
+			break
+		}
+		end++
+	}
+	t.pos = end
+	return htmlToken{kind: htmlText, raw: t.input[start:end], offset: start}, true
+}
+
+// markupAt reads the tag, comment or declaration at pos, if there is one
+func markupAt(input string, pos int) (htmlToken, bool) {
+	s := input[pos:]
+	if len(s) < 2 || s[0] != '<' {
+		return htmlToken{}, false
+	}
+	var end int
+	switch {
+	case strings.HasPrefix(s, "<!--"):
+		end = closeAfter(s, 4, "-->")
+	case strings.HasPrefix(s, "<![CDATA["):
+		end = closeAfter(s, 9, "]]>")
+	case s[1] == '!' || s[1] == '?':
+		end = closeAfter(s, 2, ">")
+	case s[1] == '/' && len(s) > 2 && isASCIILetter(s[2]):
+		return tagAt(s, pos, htmlEndTag, 2)
+	case isASCIILetter(s[1]):
+		return tagAt(s, pos, htmlStartTag, 1)
+	default:
+		return htmlToken{}, false
+	}
+	return htmlToken{kind: htmlComment, raw: s[:end], offset: pos}, true
+}
+
+// tagAt reads a start or end tag at the start of s, whose name begins at
+// i. Attribute values in quotes may hold a >. A tag that never ends is
+// text.
+func tagAt(s string, pos int, kind htmlKind, i int) (htmlToken, bool) {
+	nameEnd := i
+	for nameEnd < len(s) && !isHTMLSpace(s[nameEnd]) && s[nameEnd] != '>' && s[nameEnd] != '/' {
+		nameEnd++
+	}
+	var quote byte
+	for j := nameEnd; j < len(s); j++ {
+		switch c := s[j]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return htmlToken{
+				kind:        kind,
+				raw:         s[:j+1],
+				name:        strings.ToLower(s[i:nameEnd]),
+				selfClosing: s[j-1] == '/',
+				offset:      pos,
+			}, true
+		}
+	}
+	return htmlToken{}, false
+}
+
+// closeAfter returns the end of the closing delimiter found at or after
+// i, or the end of s for markup that never closes
+func closeAfter(s string, i int, delimiter string) int {
+	if end := strings.Index(s[i:], delimiter); end >= 0 {
+		return i + end + len(delimiter)
+	}
+	return len(s)
+}
+
+// indexFold is strings.Index ignoring ASCII case
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// html runs the processor over the text of an HTML document
+func (d *document) html(input string) {
+	t := htmlTokenizer{input: input}
+	var run *segmentBuilder // Running text, nil between blocks
+	var skipped []string    // Verbatim elements open around the current token
+	var verbatim strings.Builder
+
+	flush := func() {
+		if run != nil {
+			d.process(run.segment())
+			run = nil
+		}
+	}
+	start := func(offset int) {
+		if run == nil {
+			run = newSegment(offset)
+			run.escape = escapeHTMLText
+		}
+	}
+	for {
+		tok, ok := t.next()
+		if !ok {
+			break
+		}
+		if len(skipped) > 0 {
+			verbatim.WriteString(tok.raw)
+			skipped = updateVerbatim(skipped, tok)
+			if len(skipped) == 0 {
+				if run != nil {
+					run.protect(verbatim.String())
+				} else {
+					d.verbatim(verbatim.String())
+				}
+				verbatim.Reset()
+			}
+			continue
+		}
+
+		inline, isVerbatim := htmlVerbatim[tok.name]
+		switch {
+		case tok.kind == htmlText:
+			start(tok.offset)
+			addHTMLText(run, tok.raw)
+		case tok.kind == htmlStartTag && isVerbatim && !tok.selfClosing:
+			if !inline {
+				flush()
+			}
+			verbatim.WriteString(tok.raw)
+			skipped = append(skipped, tok.name)
+		case tok.kind == htmlComment || htmlInline[tok.name] || inline:
+			start(tok.offset)
+			run.protect(tok.raw)
+		default:
+			flush()
+			d.verbatim(tok.raw)
+		}
+	}
+	// Verbatim elements still open at the end run to the end
+	if run != nil {
+		run.protect(verbatim.String())
+	} else {
+		d.verbatim(verbatim.String())
+	}
+	flush()
+}
+
+// updateVerbatim tracks the verbatim elements open inside one another,
+// as in <pre><code>...</code></pre>
+func updateVerbatim(open []string, tok htmlToken) []string {
+	switch {
+	case tok.kind == htmlStartTag && !tok.selfClosing:
+		if _, ok := htmlVerbatim[tok.name]; ok {
+			return append(open, tok.name)
+		}
+	case tok.kind == htmlEndTag:
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] == tok.name {
+				return open[:i]
+			}
+		}
+	}
+	return open
+}
+
+// addHTMLText adds a text node to run. Character references the
+// processor has to read, letters, quotes and punctuation, are decoded,
+// so it sees " for &quot; and ' for &#39;. A space reference such as
+// &nbsp; between two words is decoded too, so it keeps them apart.
+// Decoded references are written back as they were spelled. The others,
+// &amp; or &copy;, are protected.
+func addHTMLText(run *segmentBuilder, text string) {
+	for {
+		i := strings.IndexByte(text, '&')
+		if i < 0 {
+			break
+		}
+		run.prose(text[:i])
+		text = text[i:]
+		ref := entity.FindString(text)
+		decoded := html.UnescapeString(ref)
+		switch {
+		case ref == "":
+			run.prose("&")
+			text = text[1:]
+			continue
+		case isProseReference(decoded):
+			spellReference(run, decoded, ref)
+			run.decoded(decoded, ref)
+		case isSpaceReference(decoded) && decodesSpace(run, text[len(ref):], decoded, ref):
+			run.decoded(decoded, ref)
+		default:
+			run.protect(ref)
+		}
+		text = text[len(ref):]
+	}
+	run.prose(text)
+}
+
+// decodesSpace reports whether the space reference ref, followed by
+// rest, can reach the processor as the space it decodes to. Next to
+// other blanks the words are apart already and it stays protected, as
+// does a second spelling of the same space, which could not be written
+// back as itself.
+func decodesSpace(run *segmentBuilder, rest, space, ref string) bool {
+	before, _ := utf8.DecodeLastRuneInString(run.text.String())
+	after, _ := utf8.DecodeRuneInString(rest)
+	if isPlainBlank(before) || isPlainBlank(after) {
+		return false
+	}
+	return spellReference(run, space, ref)
+}
+
+// isPlainBlank reports whether r is whitespace other than a space
+// character a reference decodes to, such as a space, tab or line break
+func isPlainBlank(r rune) bool {
+	return unicode.IsSpace(r) && !isSpaceReference(string(r))
+}
+
+// htmlReferences records how the characters decoded from references in a
+// run are written back
+type htmlReferences struct {
+	spelling map[rune]string // Reference a character is written back as, "" for itself
+	scanned  int             // Bytes of the run's text already looked at for literal characters
+	literal  map[rune]bool   // Characters the run had as themselves in those bytes
+}
+
+// spellReference makes run write the character decoded from ref back as
+// ref, and the other case of a letter as the matching reference, as
+// &Eacute; for &eacute;. The first spelling of a character in a run is
+// the one every copy is written back as, the character itself if it came
+// first. It reports whether that spelling is ref.
+func spellReference(run *segmentBuilder, decoded, ref string) bool {
+	r, _ := utf8.DecodeRuneInString(decoded)
+	refs := run.references
+	if refs == nil {
+		refs = &htmlReferences{spelling: make(map[rune]string), literal: make(map[rune]bool)}
+		run.references = refs
+		run.escape = writeReferences(run.escape, refs.spelling)
+	}
+	if spelling, ok := refs.spelling[r]; ok {
+		return spelling == ref
+	}
+	// Characters are looked at once, as the run grows
+	text := run.text.String()
+	for _, c := range text[refs.scanned:] {
+		refs.literal[c] = true
+	}
+	refs.scanned = len(text)
+	if refs.literal[r] {
+		refs.spelling[r] = ""
+		return false
+	}
+	refs.spelling[r] = ref
+	for _, other := range []rune{unicode.ToUpper(r), unicode.ToLower(r)} {
+		if _, ok := refs.spelling[other]; !ok && other != r && !refs.literal[other] {
+			if otherRef, ok := caseReference(ref, other); ok {
+				refs.spelling[other] = otherRef
+			}
+		}
+	}
+	return true
+}
+
+// caseReference returns the reference for r spelled as ref is: by
+// number in the same base, or by the name of ref with its first letter
+// in the other case, when that name stands for r
+func caseReference(ref string, r rune) (string, bool) {
+	switch {
+	case strings.HasPrefix(ref, "&#x") || strings.HasPrefix(ref, "&#X"):
+		digits := strconv.FormatInt(int64(r), 16)
+		if strings.ToLower(ref) != ref {
+			digits = strings.ToUpper(digits)
+		}
+		return ref[:3] + digits + ";", true
+	case strings.HasPrefix(ref, "&#"):
+		return "&#" + strconv.Itoa(int(r)) + ";", true
+	}
+	first, size := utf8.DecodeRuneInString(ref[1:])
+	name := string(unicode.ToUpper(first)) + ref[1+size:]
+	if unicode.IsUpper(first) {
+		name = string(unicode.ToLower(first)) + ref[1+size:]
+	}
+	if html.UnescapeString("&"+name) != string(r) {
+		return "", false
+	}
+	return "&" + name, true
+}
+
+// writeReferences makes escape write the characters in spelling back as
+// their references. The references are written as they are, the rest of
+// the text goes through escape.
+func writeReferences(escape func(string) string, spelling map[rune]string) func(string) string {
+	return func(s string) string {
+		var sb strings.Builder
+		plain := 0
+		for i, r := range s {
+			if ref := spelling[r]; ref != "" {
+				sb.WriteString(escape(s[plain:i]))
+				sb.WriteString(ref)
+				plain = i + utf8.RuneLen(r)
+			}
+		}
+		sb.WriteString(escape(s[plain:]))
+		return sb.String()
+	}
+}
+
+// isProseReference reports whether a decoded character reference is a
+// single character the processor reads
+func isProseReference(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) {
+		return false
+	}
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.M, r) ||
+		formatters.IsQuoteMark(r) || formatters.IsPunctuation(r)
+}
+
+// isSpaceReference reports whether a decoded character reference is a
+// single space character other than the ASCII space, as for &nbsp;
+func isSpaceReference(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	return size == len(s) && r != ' ' && unicode.Is(unicode.Zs, r)
+}
+
+// escapeHTMLText escapes processed text for an HTML text node
+var escapeHTMLText = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
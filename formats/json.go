@@ -0,0 +1,233 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSON documents are validated with encoding/json, then walked by hand so
+// keys, numbers, whitespace and the order of members are written back
+// byte for byte. Only the selected string values are decoded, processed
+// and encoded again, and only when the processor changed them.
+
+// jsonWalker copies a JSON document to the output, replacing the
+// selected string values as it goes
+type jsonWalker struct {
+	d         *document
+	input     string
+	pos       int
+	path      []pathElem
+	selectors []selector
+}
+
+// json runs the processor over the selected string values of a JSON
+// document
+func (d *document) json(input string, opts Options) error {
+	var raw json.RawMessage
+	if err := json.Unmarshal([]byte(input), &raw); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	selectors, err := parseSelectors(opts.Paths)
+	if err != nil {
+		return err
+	}
+	w := jsonWalker{d: d, input: input, selectors: selectors}
+	w.value()
+	d.verbatim(input[w.pos:])
+	return nil
+}
+
+// value walks the value at pos. The input is known to be valid JSON.
+func (w *jsonWalker) value() {
+	w.skipSpace()
+	switch w.input[w.pos] {
+	case '{':
+		w.copy(1)
+		for w.skipSpace(); w.input[w.pos] != '}'; w.skipSpace() {
+			start := w.pos
+			w.pos = jsonStringEnd(w.input, start)
+			key := decodeJSONString(w.input[start:w.pos])
+			w.d.verbatim(w.input[start:w.pos])
+			w.skipSpace()
+			w.copy(1) // :
+			w.path = append(w.path, pathElem{key: key})
+			w.value()
+			w.path = w.path[:len(w.path)-1]
+			w.skipSpace()
+			if w.input[w.pos] == ',' {
+				w.copy(1)
+			}
+		}
+		w.copy(1)
+	case '[':
+		w.copy(1)
+		for i := 0; ; i++ {
+			w.skipSpace()
+			if w.input[w.pos] == ']' {
+				break
+			}
+			w.path = append(w.path, pathElem{index: i, isIndex: true})
+			w.value()
+			w.path = w.path[:len(w.path)-1]
+			w.skipSpace()
+			if w.input[w.pos] == ',' {
+				w.copy(1)
+			}
+		}
+		w.copy(1)
+	case '"':
+		w.string()
+	default:
+		// Numbers, true, false and null
+		end := w.pos
+		for end < len(w.input) && strings.IndexByte(",]} \t\r\n", w.input[end]) < 0 {
+			end++
+		}
+		w.copy(end - w.pos)
+	}
+}
+
+// string processes the string value at pos when it is selected
+func (w *jsonWalker) string() {
+	start := w.pos
+	end := jsonStringEnd(w.input, start)
+	w.pos = end
+	raw := w.input[start:end]
+	if !selected(w.selectors, w.path) {
+		w.d.verbatim(raw)
+		return
+	}
+	b := newSegment(start + 1)
+	b.escape = escapeJSONString
+	addJSONString(b, raw[1:len(raw)-1])
+	t := w.d.transform(w.d.p, b.segment())
+	w.d.verbatim(`"` + t.text + `"`)
+	if t.changed {
+		w.d.edits = append(w.d.edits, Edit{Path: formatPath(w.path), Before: t.before, After: t.after})
+	}
+}
+
+// copy writes the next n bytes as they are
+func (w *jsonWalker) copy(n int) {
+	w.d.verbatim(w.input[w.pos : w.pos+n])
+	w.pos += n
+}
+
+func (w *jsonWalker) skipSpace() {
+	start := w.pos
+	for w.pos < len(w.input) && strings.IndexByte(" \t\r\n", w.input[w.pos]) >= 0 {
+		w.pos++
+	}
+	w.d.verbatim(w.input[start:w.pos])
+}
+
+// jsonStringEnd returns the offset just past the string starting at i
+func jsonStringEnd(s string, i int) int {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// decodeJSONString decodes a quoted JSON string known to be valid
+func decodeJSONString(raw string) string {
+	var s string
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return raw
+	}
+	return s
+}
+
+// addJSONString adds the content of a JSON string to b, decoding its
+// escapes so the processor sees a quote for \" and a line break for \n
+func addJSONString(b *segmentBuilder, raw string) {
+	for {
+		i := strings.IndexByte(raw, '\\')
+		if i < 0 {
+			break
+		}
+		b.prose(raw[:i])
+		raw = raw[i:]
+		text, size := decodeJSONEscape(raw)
+		b.decoded(text, raw[:size])
+		raw = raw[size:]
+	}
+	b.prose(raw)
+}
+
+// decodeJSONEscape decodes the escape sequence at the start of s and
+// returns its text and length. A surrogate pair is read as one.
+func decodeJSONEscape(s string) (string, int) {
+	if len(s) < 2 {
+		return s, len(s)
+	}
+	switch s[1] {
+	case 'b':
+		return "\b", 2
+	case 'f':
+		return "\f", 2
+	case 'n':
+		return "\n", 2
+	case 'r':
+		return "\r", 2
+	case 't':
+		return "\t", 2
+	case 'u':
+		r1, ok := parseHex4(s[2:])
+		if !ok {
+			return s[:2], 2
+		}
+		if utf16.IsSurrogate(r1) && len(s) >= 12 && s[6] == '\\' && s[7] == 'u' {
+			if r2, ok := parseHex4(s[8:]); ok {
+				if r := utf16.DecodeRune(r1, r2); r != utf8.RuneError {
+					return string(r), 12
+				}
+			}
+		}
+		return string(r1), 6
+	}
+	return s[1:2], 2
+}
+
+func parseHex4(s string) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[:4], 16, 32)
+	return rune(n), err == nil
+}
+
+// escapeJSONString escapes processed text for the inside of a JSON string
+func escapeJSONString(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&sb, `\u%04x`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return sb.String()
+}
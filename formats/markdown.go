@@ -0,0 +1,690 @@
+package formats
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markdown is read in two passes, the way CommonMark describes it, but
+// only as far as telling prose from everything else. The block pass
+// writes code blocks, HTML blocks, link reference definitions, thematic
+// breaks and table delimiter rows back verbatim, and keeps the prefix of
+// headings, list items and blockquotes. The inline pass then protects
+// code spans, HTML tags, autolinks, bare URLs, link targets, entities,
+// escapes and emphasis delimiters inside the prose it is left with.
+
+var (
+	atxHeading         = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	atxClosing         = regexp.MustCompile(`(?:^|[ \t]+)#+[ \t]*$`)
+	fenceOpen          = regexp.MustCompile("^(?:`{3,}[^`]*|~{3,}.*)$")
+	delimiterRow       = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	linkDefinition     = regexp.MustCompile(`^\[[^\]^][^\]]*\]:`)
+	footnoteDefinition = regexp.MustCompile(`^\[\^[^\]\s]+\]:[ \t]*`)
+	taskBox            = regexp.MustCompile(`^\[[ xX]\](?:[ \t]+|$)`)
+
+	inlineHTML = regexp.MustCompile(`^(?:<[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][\w.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>` +
+		`|</[A-Za-z][A-Za-z0-9-]*\s*>|<!--(?s:.*?)-->|<\?(?s:.*?)\?>|<![A-Za-z][^>]*>|<!\[CDATA\[(?s:.*?)\]\]>)`)
+	autolink = regexp.MustCompile(`^<(?:[A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*|[A-Za-z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9.-]*[A-Za-z0-9])?)>`)
+	entity   = regexp.MustCompile(`^&(?:[A-Za-z][A-Za-z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9A-Fa-f]{1,6});`)
+	bareURL  = regexp.MustCompile(`^(?i:https?://|ftp://|www\.)[^\s<>]+`)
+)
+
+// htmlBlock is one of the kinds of HTML block CommonMark knows. A block
+// ends on the line matching end, or before a blank line when end is nil.
+type htmlBlock struct {
+	start, end *regexp.Regexp
+	interrupts bool // The block can start in the middle of a paragraph
+}
+
+var htmlBlocks = []htmlBlock{
+	{regexp.MustCompile(`(?i)^<(?:script|pre|style|textarea)(?:[\s>]|$)`), regexp.MustCompile(`(?i)</(?:script|pre|style|textarea)>`), true},
+	{regexp.MustCompile(`^<!--`), regexp.MustCompile(`-->`), true},
+	{regexp.MustCompile(`^<\?`), regexp.MustCompile(`\?>`), true},
+	{regexp.MustCompile(`^<![A-Za-z]`), regexp.MustCompile(`>`), true},
+	{regexp.MustCompile(`^<!\[CDATA\[`), regexp.MustCompile(`\]\]>`), true},
+	{regexp.MustCompile(`(?i)^</?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[1-6]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:[\s>]|/>|$)`), nil, true},
+	{regexp.MustCompile(`^(?:<[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][\w.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>|</[A-Za-z][A-Za-z0-9-]*\s*>)[ \t]*$`), nil, false},
+}
+
+// line is one line of a document
+type line struct {
+	text   string // The line without its line ending
+	eol    string
+	offset int // Byte offset of the line in the document
+}
+
+func (l line) full() string {
+	return l.text + l.eol
+}
+
+// splitLines cuts input into lines, keeping their \n, \r\n or \r
+func splitLines(input string) []line {
+	var lines []line
+	start := 0
+	for i := 0; i < len(input); i++ {
+		if input[i] != '\n' && input[i] != '\r' {
+			continue
+		}
+		eol := input[i : i+1]
+		if input[i] == '\r' && i+1 < len(input) && input[i+1] == '\n' {
+			eol = "\r\n"
+		}
+		lines = append(lines, line{text: input[start:i], eol: eol, offset: start})
+		i += len(eol) - 1
+		start = i + 1
+	}
+	if start < len(input) {
+		lines = append(lines, line{text: input[start:], offset: start})
+	}
+	return lines
+}
+
+// mdLine is a line split into its container prefix, the blockquote
+// markers, list marker and indentation in front of the content, and the
+// content itself
+type mdLine struct {
+	line
+	prefix string
+	rest   string
+	quotes int  // Number of > markers in the prefix
+	item   bool // The prefix opens a list item
+	indent int  // Columns of indentation the line starts with
+}
+
+func parseLine(l line) mdLine {
+	m := mdLine{line: l, indent: indentation(l.text)}
+	text := l.text
+	i := 0
+	for {
+		j := skipBlanks(text, i)
+		if j < len(text) && text[j] == '>' {
+			m.quotes++
+			i = j + 1
+			if i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+				i++
+			}
+			continue
+		}
+		if isThematicBreak(text[j:]) {
+			break
+		}
+		if end := listMarkerEnd(text, j); end > 0 {
+			m.item = true
+			i = end
+			continue
+		}
+		break
+	}
+	i = skipBlanks(text, i)
+	if m.item {
+		i += len(taskBox.FindString(text[i:]))
+	}
+	m.prefix, m.rest = text[:i], text[i:]
+	return m
+}
+
+func (m mdLine) blank() bool {
+	return strings.TrimSpace(m.rest) == ""
+}
+
+// listMarkerEnd returns the end of a list marker at i, such as "- " or
+// "1. ", or 0 when there is none
+func listMarkerEnd(text string, i int) int {
+	if i >= len(text) {
+		return 0
+	}
+	k := i
+	switch c := text[i]; {
+	case c == '-' || c == '*' || c == '+':
+		k++
+	case isDigit(c):
+		for k < len(text) && k-i < 9 && isDigit(text[k]) {
+			k++
+		}
+		if k >= len(text) || (text[k] != '.' && text[k] != ')') {
+			return 0
+		}
+		k++
+	default:
+		return 0
+	}
+	switch {
+	case k == len(text):
+		return k
+	case text[k] == ' ' || text[k] == '\t':
+		return k + 1
+	}
+	return 0
+}
+
+// isThematicBreak matches ---, *** or ___, spaces allowed in between
+func isThematicBreak(s string) bool {
+	var mark byte
+	count := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+		case (c == '-' || c == '*' || c == '_') && (mark == 0 || c == mark):
+			mark = c
+			count++
+		default:
+			return false
+		}
+	}
+	return count >= 3
+}
+
+// isSetextUnderline matches the === under a level 1 setext heading.
+// The --- of level 2 is already a thematic break.
+func isSetextUnderline(s string) bool {
+	s = strings.TrimRight(s, " \t")
+	return s != "" && strings.Trim(s, "=") == ""
+}
+
+// htmlBlockAt returns the kind of HTML block s starts, or nil
+func htmlBlockAt(s string) *htmlBlock {
+	if !strings.HasPrefix(s, "<") {
+		return nil
+	}
+	for i := range htmlBlocks {
+		if htmlBlocks[i].start.MatchString(s) {
+			return &htmlBlocks[i]
+		}
+	}
+	return nil
+}
+
+// markdown runs the processor over the prose of a Markdown document
+func (d *document) markdown(input string) {
+	lines := splitLines(input)
+	i := d.frontMatter(lines)
+	listContent := -1 // Column of the content of the open list item, -1 outside lists
+	blankBefore := true
+	for i < len(lines) {
+		m := parseLine(lines[i])
+		if m.item {
+			listContent = len(m.prefix)
+		} else if !m.blank() && m.indent == 0 && blankBefore {
+			listContent = -1
+		}
+		codeIndent := 4
+		if listContent >= 0 {
+			codeIndent = listContent + 4
+		}
+
+		switch {
+		case m.blank():
+			d.verbatim(m.full())
+			i++
+		case blankBefore && m.quotes == 0 && !m.item && m.indent >= codeIndent:
+			i = d.indentedCode(lines, i, codeIndent)
+		case fenceOpen.MatchString(m.rest):
+			i = d.fencedCode(lines, i, m.rest)
+		case htmlBlockAt(m.rest) != nil:
+			i = d.htmlBlock(lines, i, htmlBlockAt(m.rest))
+		case isThematicBreak(m.rest), isSetextUnderline(m.rest), linkDefinition.MatchString(m.rest):
+			d.verbatim(m.full())
+			i++
+		case atxHeading.MatchString(m.rest):
+			d.heading(m)
+			i++
+		case isTableStart(lines, i):
+			i = d.table(lines, i)
+		default:
+			i = d.paragraph(lines, i)
+		}
+		blankBefore = parseLine(lines[i-1]).blank()
+	}
+}
+
+// frontMatter writes a YAML front matter block, fenced by --- lines at
+// the very start of the document, and returns the first line after it
+func (d *document) frontMatter(lines []line) int {
+	if len(lines) == 0 || strings.TrimRight(lines[0].text, " \t") != "---" {
+		return 0
+	}
+	for i := 1; i < len(lines); i++ {
+		if text := strings.TrimRight(lines[i].text, " \t"); text == "---" || text == "..." {
+			for _, l := range lines[:i+1] {
+				d.verbatim(l.full())
+			}
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// indentedCode writes an indented code block and returns the line after
+// it. Blank lines after the block are left to the caller.
+func (d *document) indentedCode(lines []line, i, codeIndent int) int {
+	end := i + 1
+	for j := i + 1; j < len(lines); j++ {
+		if parseLine(lines[j]).blank() {
+			continue
+		}
+		if indentation(lines[j].text) < codeIndent {
+			break
+		}
+		end = j + 1
+	}
+	for _, l := range lines[i:end] {
+		d.verbatim(l.full())
+	}
+	return end
+}
+
+// fencedCode writes a ``` or ~~~ code block, up to its closing fence or
+// the end of the document, and returns the line after it
+func (d *document) fencedCode(lines []line, i int, open string) int {
+	fence := open[:len(open)-len(strings.TrimLeft(open, open[:1]))]
+	d.verbatim(lines[i].full())
+	for i++; i < len(lines); i++ {
+		d.verbatim(lines[i].full())
+		rest := strings.TrimRight(parseLine(lines[i]).rest, " \t")
+		if strings.HasPrefix(rest, fence) && strings.Trim(rest, fence[:1]) == "" {
+			return i + 1
+		}
+	}
+	return i
+}
+
+// htmlBlock writes an HTML block and returns the line after it
+func (d *document) htmlBlock(lines []line, i int, block *htmlBlock) int {
+	for ; i < len(lines); i++ {
+		m := parseLine(lines[i])
+		if block.end == nil && m.blank() {
+			return i
+		}
+		d.verbatim(m.full())
+		if block.end != nil && block.end.MatchString(m.text) {
+			return i + 1
+		}
+	}
+	return i
+}
+
+// heading processes the text of an ATX heading, keeping its # marks
+func (d *document) heading(m mdLine) {
+	marks := atxHeading.FindString(m.rest)
+	text := m.rest[len(marks):]
+	closing := atxClosing.FindString(text)
+	text = text[:len(text)-len(closing)]
+
+	d.verbatim(m.prefix + marks)
+	d.processLine(markdownInline(text, m.offset+len(m.prefix)+len(marks), nil))
+	d.verbatim(closing + m.eol)
+}
+
+// isTableStart reports whether a table starts at line i: a row with
+// pipes followed by a delimiter row such as | --- | :-: |
+func isTableStart(lines []line, i int) bool {
+	if i+1 >= len(lines) {
+		return false
+	}
+	header, delimiter := parseLine(lines[i]), parseLine(lines[i+1])
+	return strings.Contains(header.rest, "|") && strings.Contains(delimiter.rest, "|") &&
+		delimiterRow.MatchString(delimiter.rest)
+}
+
+// table processes every cell of a table on its own and returns the line
+// after it. Pipes, padding and the delimiter row stay as they are.
+func (d *document) table(lines []line, i int) int {
+	d.tableRow(parseLine(lines[i]))
+	d.verbatim(lines[i+1].full())
+	for i += 2; i < len(lines); i++ {
+		m := parseLine(lines[i])
+		if m.blank() || !strings.Contains(m.rest, "|") {
+			break
+		}
+		d.tableRow(m)
+	}
+	return i
+}
+
+func (d *document) tableRow(m mdLine) {
+	d.verbatim(m.prefix)
+	offset := m.offset + len(m.prefix)
+	row := m.rest
+	start := 0
+	for _, pipe := range cellSeparators(row) {
+		d.processLine(markdownInline(row[start:pipe], offset+start, nil))
+		d.verbatim("|")
+		start = pipe + 1
+	}
+	d.processLine(markdownInline(row[start:], offset+start, nil))
+	d.verbatim(m.eol)
+}
+
+// cellSeparators returns the offsets of the pipes that separate cells,
+// passing over escaped pipes and pipes inside code spans
+func cellSeparators(row string) []int {
+	var pipes []int
+	for i := 0; i < len(row); i++ {
+		switch row[i] {
+		case '\\':
+			i++
+		case '`':
+			i = codeSpanEnd(row, i) - 1
+		case '|':
+			pipes = append(pipes, i)
+		}
+	}
+	return pipes
+}
+
+// paragraph processes a paragraph as a whole, so quotes and, with
+// paragraph boundaries, modifiers reach across its lines, and returns
+// the line after it. The prefix of the first line is written as is; the
+// prefixes of the following lines, such as "> " in a blockquote, are
+// protected.
+func (d *document) paragraph(lines []line, i int) int {
+	first := parseLine(lines[i])
+	prefix := first.prefix + footnoteDefinition.FindString(first.rest)
+	d.verbatim(prefix)
+
+	var sb strings.Builder
+	var fixed []textRange
+	sb.WriteString(first.text[len(prefix):])
+	end := i + 1
+	for ; end < len(lines); end++ {
+		m := parseLine(lines[end])
+		if !continuesParagraph(first, m) || isTableStart(lines, end) {
+			break
+		}
+		sb.WriteString(lines[end-1].eol)
+		if m.prefix != "" {
+			fixed = append(fixed, textRange{sb.Len(), sb.Len() + len(m.prefix)})
+		}
+		sb.WriteString(m.text)
+	}
+	d.process(markdownInline(sb.String(), first.offset+len(prefix), fixed))
+	d.verbatim(lines[end-1].eol)
+	return end
+}
+
+// continuesParagraph reports whether m carries on the paragraph started
+// by first instead of starting a block of its own
+func continuesParagraph(first, m mdLine) bool {
+	if m.blank() || m.item || m.quotes > first.quotes {
+		return false
+	}
+	if block := htmlBlockAt(m.rest); block != nil && block.interrupts {
+		return false
+	}
+	return !isThematicBreak(m.rest) && !isSetextUnderline(m.rest) &&
+		!atxHeading.MatchString(m.rest) && !fenceOpen.MatchString(m.rest)
+}
+
+// textRange is a half-open range of byte offsets
+type textRange struct {
+	start, end int
+}
+
+// markdownInline cuts prose found at offset in the document into a
+// segment, protecting the ranges in fixed and the inline Markdown that
+// isn't prose
+func markdownInline(text string, offset int, fixed []textRange) segment {
+	s := inlineScanner{text: text, targets: make(map[int]int)}
+	for _, r := range fixed {
+		s.targets[r.start] = r.end
+	}
+	b := newSegment(offset)
+	start := 0
+	for i := 0; i < len(text); {
+		end := s.protectedAt(i)
+		if end <= i {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			continue
+		}
+		b.prose(text[start:i])
+		b.protect(text[i:end])
+		i, start = end, end
+	}
+	b.prose(text[start:])
+	return b.segment()
+}
+
+// inlineScanner finds the inline Markdown the processor must not touch
+type inlineScanner struct {
+	text string
+	// targets maps the offset of a protected range found ahead of the
+	// scan, such as the ](url) closing a link, to its end
+	targets map[int]int
+}
+
+// protectedAt returns the end of the protected span starting at i, or
+// i when the prose goes on
+func (s *inlineScanner) protectedAt(i int) int {
+	text := s.text
+	if end, ok := s.targets[i]; ok {
+		return end
+	}
+	switch text[i] {
+	case '\\':
+		// An escaped mark, or a backslash hard line break
+		if i+1 < len(text) && isASCIIPunct(text[i+1]) {
+			return i + 2
+		}
+		if i+1 < len(text) && (text[i+1] == '\n' || text[i+1] == '\r') {
+			return i + 1
+		}
+	case '`':
+		return codeSpanEnd(text, i)
+	case '<':
+		if loc := autolink.FindStringIndex(text[i:]); loc != nil {
+			return i + loc[1]
+		}
+		if loc := inlineHTML.FindStringIndex(text[i:]); loc != nil {
+			return i + loc[1]
+		}
+	case '&':
+		return i + len(entity.FindString(text[i:]))
+	case '*', '_':
+		// Emphasis delimiters wrap words the way <em> does
+		end := i
+		for end < len(text) && text[end] == text[i] {
+			end++
+		}
+		if isEmphasisDelimiter(text, i, end) {
+			return end
+		}
+	case '!':
+		// The ! of an image would be read as an exclamation mark
+		if i+1 < len(text) && text[i+1] == '[' {
+			return i + 1
+		}
+	case '[':
+		return s.link(i)
+	case ' ':
+		// Two spaces before a line break make a hard line break
+		j := i
+		for j < len(text) && text[j] == ' ' {
+			j++
+		}
+		if j-i >= 2 && j < len(text) && (text[j] == '\n' || text[j] == '\r') {
+			return j
+		}
+	case 'h', 'H', 'f', 'F', 'w', 'W':
+		if i == 0 || !isWordByte(text[i-1]) {
+			return i + urlLength(text[i:])
+		}
+	}
+	return i
+}
+
+// isEmphasisDelimiter reports whether the run of * or _ from start to end
+// can open or close emphasis: it touches a word on one side at least. An
+// underscore inside a word, as in snake_case, doesn't, and neither does a
+// * with blanks on both sides, as in 3 * 4.
+func isEmphasisDelimiter(text string, start, end int) bool {
+	before, after := ' ', ' '
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(text[:start])
+	}
+	if end < len(text) {
+		after, _ = utf8.DecodeRuneInString(text[end:])
+	}
+	opens, closes := !unicode.IsSpace(after), !unicode.IsSpace(before)
+	if text[start] == '_' {
+		return opens && !isWordRune(before) || closes && !isWordRune(after)
+	}
+	return opens || closes
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// link looks at the [ at i. A footnote reference [^1] is protected as a
+// whole. The link text of [text](url) and [text][ref] stays prose while
+// what follows its closing ] is recorded as a target to protect.
+func (s *inlineScanner) link(i int) int {
+	text := s.text
+	close := closingBracket(text, i)
+	if close < 0 {
+		return i
+	}
+	if text[i+1] == '^' {
+		return close + 1
+	}
+	if close+1 < len(text) {
+		switch text[close+1] {
+		case '(':
+			if end := closingParen(text, close+1); end > 0 {
+				s.targets[close] = end + 1
+			}
+		case '[':
+			if end := strings.IndexByte(text[close+2:], ']'); end >= 0 {
+				s.targets[close] = close + 2 + end + 1
+			}
+		}
+	}
+	return i
+}
+
+// codeSpanEnd returns the end of the code span opened by the backticks
+// at i. A run of backticks that is never closed is returned on its own.
+func codeSpanEnd(text string, i int) int {
+	run := i
+	for run < len(text) && text[run] == '`' {
+		run++
+	}
+	n := run - i
+	for j := run; j < len(text); {
+		k := strings.IndexByte(text[j:], '`')
+		if k < 0 {
+			break
+		}
+		start := j + k
+		end := start
+		for end < len(text) && text[end] == '`' {
+			end++
+		}
+		if end-start == n {
+			return end
+		}
+		j = end
+	}
+	return run
+}
+
+// closingBracket returns the offset of the ] matching the [ at i, or -1
+func closingBracket(text string, i int) int {
+	depth := 0
+	for j := i; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '`':
+			j = codeSpanEnd(text, j) - 1
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// closingParen returns the offset of the ) closing the link destination
+// and title opened by the ( at i, or -1
+func closingParen(text string, i int) int {
+	depth := 0
+	for j := i; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '<':
+			if k := strings.IndexByte(text[j:], '>'); k >= 0 {
+				j += k
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// urlLength returns the length of a bare URL such as https://go.dev at
+// the start of s, without the punctuation that ends the sentence around
+// it, or 0
+func urlLength(s string) int {
+	url := bareURL.FindString(s)
+	for url != "" {
+		last := url[len(url)-1]
+		switch {
+		case strings.IndexByte("?!.,:;*_~'\"", last) >= 0:
+			url = url[:len(url)-1]
+		case last == ')' && strings.Count(url, ")") > strings.Count(url, "("):
+			url = url[:len(url)-1]
+		default:
+			return len(url)
+		}
+	}
+	return 0
+}
+
+func indentation(s string) int {
+	columns := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ':
+			columns++
+		case '\t':
+			columns += 4 - columns%4
+		default:
+			return columns
+		}
+	}
+	return columns
+}
+
+func skipBlanks(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
@@ -0,0 +1,213 @@
+package formats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathElem is one step from a value to its child: an object key or an
+// array index
+type pathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// formatPath writes a path as JSONPath: $.messages[0]['two words']
+func formatPath(path []pathElem) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, e := range path {
+		switch {
+		case e.isIndex:
+			fmt.Fprintf(&sb, "[%d]", e.index)
+		case isPathName(e.key):
+			sb.WriteString("." + e.key)
+		default:
+			sb.WriteString("['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(e.key) + "']")
+		}
+	}
+	return sb.String()
+}
+
+// selector is a compiled JSONPath expression
+type selector []pathStep
+
+// pathStep matches one element of a path
+type pathStep struct {
+	key        string
+	index      int
+	isIndex    bool
+	any        bool // * or [*]: any key or index
+	descendant bool // Reached with ..: the step may skip any number of levels
+}
+
+func (st pathStep) matches(e pathElem) bool {
+	switch {
+	case st.any:
+		return true
+	case st.isIndex:
+		return e.isIndex && e.index == st.index
+	}
+	return !e.isIndex && e.key == st.key
+}
+
+// parseSelectors compiles JSONPath expressions. No expression selects
+// everything.
+func parseSelectors(exprs []string) ([]selector, error) {
+	if len(exprs) == 0 {
+		return []selector{{}}, nil
+	}
+	selectors := make([]selector, 0, len(exprs))
+	for _, expr := range exprs {
+		sel, err := parseSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+// parseSelector compiles the JSONPath subset made of $, .name, ['name'],
+// [0], * and ..
+func parseSelector(expr string) (selector, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("path %q must start with $", expr)
+	}
+	var sel selector
+	s := expr[1:]
+	for s != "" {
+		var st pathStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			st.descendant = true
+			s = s[2:]
+		case s[0] == '.':
+			s = s[1:]
+		case s[0] != '[':
+			return nil, fmt.Errorf("path %q: unexpected %q", expr, s)
+		}
+		if strings.HasPrefix(s, "[") {
+			end := closingSubscript(s)
+			if end < 0 {
+				return nil, fmt.Errorf("path %q: unclosed [", expr)
+			}
+			if err := st.parseSubscript(s[1:end]); err != nil {
+				return nil, fmt.Errorf("path %q: %v", expr, err)
+			}
+			s = s[end+1:]
+		} else {
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("path %q: empty name", expr)
+			}
+			st.key, st.any = s[:end], s[:end] == "*"
+			s = s[end:]
+		}
+		sel = append(sel, st)
+	}
+	return sel, nil
+}
+
+// closingSubscript returns the offset of the ] closing the [ that starts
+// s, passing over quoted names
+func closingSubscript(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func (st *pathStep) parseSubscript(sub string) error {
+	sub = strings.TrimSpace(sub)
+	switch {
+	case sub == "*":
+		st.any = true
+	case len(sub) >= 2 && (sub[0] == '\'' || sub[0] == '"') && sub[len(sub)-1] == sub[0]:
+		st.key = strings.NewReplacer(`\\`, `\`, `\'`, `'`, `\"`, `"`).Replace(sub[1 : len(sub)-1])
+	default:
+		index, err := strconv.Atoi(sub)
+		if err != nil || index < 0 {
+			return fmt.Errorf("bad subscript [%s]", sub)
+		}
+		st.index, st.isIndex = index, true
+	}
+	return nil
+}
+
+// match reports whether the value at path is selected: the selector
+// matches the path or one of its ancestors
+func (sel selector) match(path []pathElem) bool {
+	if len(sel) == 0 {
+		return true
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if sel[0].matches(path[0]) && sel[1:].match(path[1:]) {
+		return true
+	}
+	return sel[0].descendant && sel.match(path[1:])
+}
+
+// reaches reports whether the selector could select path, an ancestor of
+// it or a value inside it
+func (sel selector) reaches(path []pathElem) bool {
+	if len(sel) == 0 || len(path) == 0 {
+		return true
+	}
+	if sel[0].matches(path[0]) && sel[1:].reaches(path[1:]) {
+		return true
+	}
+	return sel[0].descendant && sel.reaches(path[1:])
+}
+
+// reached reports whether any selector reaches path
+func reached(selectors []selector, path []pathElem) bool {
+	for _, sel := range selectors {
+		if sel.reaches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected reports whether any selector matches path
+func selected(selectors []selector, path []pathElem) bool {
+	for _, sel := range selectors {
+		if sel.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathName reports whether key can be written as .key
+func isPathName(key string) bool {
+	if key == "" || key == "*" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; !isWordByte(c) && c != '-' && c < 0x80 {
+			return false
+		}
+	}
+	return !isDigit(key[0])
+}
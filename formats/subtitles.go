@@ -0,0 +1,216 @@
+package formats
+
+import (
+	"go-reloaded/fsm"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subtitle files are read as cues: a timing line such as
+// 00:00:01,000 --> 00:00:04,000 and the lines of text under it, up to the
+// next blank line. Only the text goes through the processor. Cue numbers
+// and identifiers, timing lines with their cue settings, the WEBVTT header
+// and NOTE, STYLE and REGION blocks are written back as they are, and so
+// are the styling tags inside the text: <i>, <font color="...">,
+// <c.yellow>, <v Speaker>, timestamp tags and the {\an8} overrides of SRT.
+// The lines of a cue are one caption: modifiers, a/an and quotes reach
+// across them, and they are never joined.
+
+// timingLine matches the line that opens a cue, SRT or WebVTT
+var timingLine = regexp.MustCompile(`^[ \t]*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})[ \t]+-->[ \t]+((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})`)
+
+// maxCueGap is the longest pause between two cues of one caption block
+const maxCueGap = time.Second
+
+// cue is one timed piece of a subtitle file
+type cue struct {
+	lead  []line // Everything before the text, ending with the timing line
+	text  []line
+	start time.Duration
+	end   time.Duration
+}
+
+// continuedBy reports whether next belongs to the caption block of c:
+// both have text, nothing but a cue identifier comes between them, and next
+// starts at most maxCueGap after c ends
+func (c cue) continuedBy(next cue) bool {
+	if len(c.text) == 0 || len(next.text) == 0 || next.start-c.end > maxCueGap {
+		return false
+	}
+	// Only blank lines and the identifier right above the timing line
+	for i, l := range next.lead[:len(next.lead)-1] {
+		if i < len(next.lead)-2 && strings.TrimSpace(l.text) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// subtitles runs the processor over the cue text of an SRT or WebVTT
+// file. With Options.SpanCues the cues of a caption block are processed
+// together.
+func (d *document) subtitles(input string, vtt bool, opts Options) {
+	cues, rest := parseCues(splitLines(input))
+	for i := 0; i < len(cues); {
+		j := i + 1
+		for opts.SpanCues && j < len(cues) && cues[j-1].continuedBy(cues[j]) {
+			j++
+		}
+		d.captionBlock(cues[i:j], vtt)
+		i = j
+	}
+	for _, l := range rest {
+		d.verbatim(l.full())
+	}
+}
+
+// parseCues splits the lines of a subtitle file into cues, and returns
+// the lines left after the last one
+func parseCues(lines []line) ([]cue, []line) {
+	var cues []cue
+	var lead []line
+	for i := 0; i < len(lines); {
+		m := timingLine.FindStringSubmatch(lines[i].text)
+		lead = append(lead, lines[i])
+		i++
+		if m == nil {
+			continue
+		}
+		c := cue{lead: lead, start: parseTimestamp(m[1]), end: parseTimestamp(m[2])}
+		for i < len(lines) && strings.TrimSpace(lines[i].text) != "" && !timingLine.MatchString(lines[i].text) {
+			c.text = append(c.text, lines[i])
+			i++
+		}
+		// A file missing the blank line between two cues runs straight into
+		// the next timing line, with the next cue number right above it
+		if i < len(lines) && timingLine.MatchString(lines[i].text) && len(c.text) > 0 && isCueNumber(c.text[len(c.text)-1].text) {
+			c.text = c.text[:len(c.text)-1]
+			i--
+		}
+		cues = append(cues, c)
+		lead = nil
+	}
+	return cues, lead
+}
+
+// captionBlock writes cues that are processed as one piece of text. The
+// boundary between two cues, the line break ending the text and the
+// lines down to the next timing line, is protected, and the line break
+// after the timing line stays a line break the processor reads as soft.
+func (d *document) captionBlock(cues []cue, vtt bool) {
+	for _, l := range cues[0].lead {
+		d.verbatim(l.full())
+	}
+	if len(cues[0].text) == 0 {
+		return
+	}
+	b := newSegment(cues[0].text[0].offset)
+	if vtt {
+		b.escape = escapeHTMLText
+	}
+	for k, c := range cues {
+		if k > 0 {
+			prev := cues[k-1].text
+			boundary := prev[len(prev)-1].eol
+			for _, l := range c.lead[:len(c.lead)-1] {
+				boundary += l.full()
+			}
+			timing := c.lead[len(c.lead)-1]
+			b.protect(boundary + timing.text)
+			b.prose(timing.eol)
+		}
+		for n, l := range c.text {
+			addCueText(b, l.text, vtt)
+			if n < len(c.text)-1 || k == len(cues)-1 {
+				b.prose(l.eol)
+			}
+		}
+	}
+	d.verbatim(d.transform(d.captions(), b.segment()).text)
+}
+
+// captions is p with line breaks read as soft and never joined, for the
+// lines of a cue and the cues of a caption block
+func (d *document) captions() *fsm.Processor {
+	if d.caption == nil {
+		opts := d.p.Options()
+		opts.ParagraphBoundaries = true
+		opts.UnwrapParagraphs = false
+		d.caption = fsm.NewProcessorWithOptions(opts)
+	}
+	return d.caption
+}
+
+// addCueText adds a line of cue text to b, protecting its styling tags.
+// WebVTT text escapes &, < and > as HTML does.
+func addCueText(b *segmentBuilder, text string, vtt bool) {
+	add := b.prose
+	if vtt {
+		add = func(s string) { addHTMLText(b, s) }
+	}
+	for {
+		i := strings.IndexAny(text, "<{")
+		if i < 0 {
+			break
+		}
+		n := cueTagAt(text[i:])
+		if n == 0 {
+			add(text[:i+1])
+			text = text[i+1:]
+			continue
+		}
+		add(text[:i])
+		b.protect(text[i : i+n])
+		text = text[i+n:]
+	}
+	add(text)
+}
+
+// cueTagAt returns the length of the styling tag at the start of s, or 0.
+// Tags are <i>, </i>, <c.class>, <v Name> or a timestamp <00:01.000>;
+// overrides are {\an8} or {\i1}.
+func cueTagAt(s string) int {
+	if len(s) < 2 {
+		return 0
+	}
+	switch {
+	case s[0] == '<' && (isASCIILetter(s[1]) || isDigit(s[1]) || s[1] == '/'):
+		if end := strings.IndexByte(s, '>'); end > 0 {
+			return end + 1
+		}
+	case s[0] == '{' && s[1] == '\\':
+		if end := strings.IndexByte(s, '}'); end > 0 {
+			return end + 1
+		}
+	}
+	return 0
+}
+
+// parseTimestamp reads 01:02:03,456 or 02:03.456 as a duration
+func parseTimestamp(s string) time.Duration {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ':' || r == ',' || r == '.'
+	})
+	var seconds time.Duration
+	for _, f := range fields[:len(fields)-1] {
+		n, _ := strconv.Atoi(f)
+		seconds = seconds*60 + time.Duration(n)
+	}
+	millis, _ := strconv.Atoi((fields[len(fields)-1] + "00")[:3])
+	return seconds*time.Second + time.Duration(millis)*time.Millisecond
+}
+
+func isCueNumber(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,637 @@
+package formats
+
+import (
+	"fmt"
+	"go-reloaded/fsm"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// YAML is read line by line, as far as block mappings, block sequences
+// and scalars go: enough to know the path of every scalar and where its
+// text starts and ends. Plain, single-quoted, double-quoted and block
+// scalars are processed when selected; comments are written back as
+// they are. Flow collections, aliases, quoted or plain scalars spread
+// over several lines, and lines the walker can't place are written back
+// as they are too, with an unsupported-yaml diagnostic when the paths
+// could select a string in them. A processed plain scalar that could no
+// longer be read back as the same string is written double-quoted.
+
+// yamlNonString matches plain scalars YAML reads as null, a boolean, a
+// number or a date
+var yamlNonString = regexp.MustCompile(`^(?:~|null|Null|NULL|true|True|TRUE|false|False|FALSE|yes|Yes|YES|no|No|NO|on|On|ON|off|Off|OFF` +
+	`|[-+]?(?:[0-9][0-9_]*)(?:\.[0-9_]*)?(?:[eE][-+]?[0-9]+)?|[-+]?\.[0-9]+(?:[eE][-+]?[0-9]+)?` +
+	`|0x[0-9a-fA-F_]+|0o[0-7_]+|[-+]?\.(?:inf|Inf|INF)|\.(?:nan|NaN|NAN)|[0-9]{4}-[0-9]{2}-[0-9]{2}(?:[Tt ].*)?)$`)
+
+// yamlLevel is a mapping key or sequence item the current line is nested in
+type yamlLevel struct {
+	indent int
+	elem   pathElem
+	item   bool
+}
+
+// yamlWalker copies a YAML document to the output, replacing the
+// selected scalars as it goes
+type yamlWalker struct {
+	d         *document
+	lines     []line
+	stack     []yamlLevel
+	selectors []selector
+}
+
+// yaml runs the processor over the selected string scalars of a YAML
+// document
+func (d *document) yaml(input string, opts Options) error {
+	selectors, err := parseSelectors(opts.Paths)
+	if err != nil {
+		return err
+	}
+	y := yamlWalker{d: d, lines: splitLines(input), selectors: selectors}
+	for i := 0; i < len(y.lines); {
+		i = y.line(i)
+	}
+	return nil
+}
+
+func (y *yamlWalker) line(i int) int {
+	l := y.lines[i]
+	trimmed := strings.TrimSpace(l.text)
+	switch {
+	case trimmed == "", trimmed[0] == '#', l.text[0] == '%':
+		y.d.verbatim(l.full())
+		return i + 1
+	case isYAMLDocumentMarker(l.text):
+		y.stack = y.stack[:0]
+		y.d.verbatim(l.full())
+		return i + 1
+	}
+	col := len(l.text) - len(strings.TrimLeft(l.text, " "))
+	y.d.verbatim(l.text[:col])
+	return y.content(i, col)
+}
+
+// content walks the node written at column col of line i
+func (y *yamlWalker) content(i, col int) int {
+	l := y.lines[i]
+	rest := l.text[col:]
+	if rest == "-" || strings.HasPrefix(rest, "- ") {
+		y.enterItem(col)
+		sub := strings.TrimLeft(rest[1:], " ")
+		y.d.verbatim(rest[:len(rest)-len(sub)])
+		next := col + len(rest) - len(sub)
+		if sub == "-" || strings.HasPrefix(sub, "- ") {
+			return y.content(i, next)
+		}
+		if _, _, ok := yamlKey(sub); ok {
+			return y.content(i, next)
+		}
+		return y.value(i, next, col)
+	}
+	if key, valueStart, ok := yamlKey(rest); ok {
+		y.enterKey(col, key)
+		y.d.verbatim(rest[:valueStart])
+		return y.value(i, col+valueStart, col)
+	}
+	if rest[0] == '[' || rest[0] == '{' {
+		return y.flow(i, col)
+	}
+	y.unsupported(l.offset+col, "a line the YAML reader can't place")
+	y.d.verbatim(rest + l.eol)
+	return i + 1
+}
+
+// unsupported reports a construct the walker writes back without
+// processing, if the paths could select a string in it
+func (y *yamlWalker) unsupported(offset int, what string) {
+	if !reached(y.selectors, y.path()) {
+		return
+	}
+	y.d.diagnostics = append(y.d.diagnostics, fsm.Diagnostic{
+		Offset:  offset,
+		Code:    "unsupported-yaml",
+		Message: fmt.Sprintf("%s: %s is written back unprocessed", formatPath(y.path()), what),
+	})
+}
+
+// verbatimLines writes the rest of line i from column col, and the whole
+// lines after it up to end
+func (y *yamlWalker) verbatimLines(i, col, end int) int {
+	y.d.verbatim(y.lines[i].text[col:] + y.lines[i].eol)
+	for _, l := range y.lines[i+1 : end] {
+		y.d.verbatim(l.full())
+	}
+	return end
+}
+
+// flow writes back the flow collection starting at column col of line i,
+// over as many lines as it takes
+func (y *yamlWalker) flow(i, col int) int {
+	y.unsupported(y.lines[i].offset+col, "a flow collection")
+	depth := 0
+	var quote byte
+	prev := byte('[')
+	for j := i; j < len(y.lines); j++ {
+		text := y.lines[j].text
+		k := 0
+		if j == i {
+			k = col
+		}
+		for ; k < len(text); k++ {
+			c := text[k]
+			switch {
+			case quote == '"' && c == '\\':
+				k++
+			case quote == '\'' && c == '\'' && k+1 < len(text) && text[k+1] == '\'':
+				k++
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case (c == '"' || c == '\'') && strings.IndexByte("[{,:", prev) >= 0:
+				quote = c
+			case c == '#' && k > 0 && (text[k-1] == ' ' || text[k-1] == '\t'):
+				k = len(text)
+			case c == '[' || c == '{':
+				depth++
+			case c == ']' || c == '}':
+				if depth--; depth == 0 {
+					return y.verbatimLines(i, col, j+1)
+				}
+			}
+			if c != ' ' && c != '\t' {
+				prev = c
+			}
+		}
+	}
+	return y.verbatimLines(i, col, len(y.lines))
+}
+
+// quotedLines writes back the quoted scalar starting at column col of
+// line i, which doesn't close on that line
+func (y *yamlWalker) quotedLines(i, col int) int {
+	y.unsupported(y.lines[i].offset+col, "a quoted scalar over several lines")
+	quote := y.lines[i].text[col]
+	for j := i + 1; j < len(y.lines); j++ {
+		text := y.lines[j].text
+		for k := 0; k < len(text); k++ {
+			switch {
+			case quote == '"' && text[k] == '\\':
+				k++
+			case quote == '\'' && text[k] == '\'' && k+1 < len(text) && text[k+1] == '\'':
+				k++
+			case text[k] == quote:
+				return y.verbatimLines(i, col, j+1)
+			}
+		}
+	}
+	return y.verbatimLines(i, col, len(y.lines))
+}
+
+// plainEnd returns the line after the last continuation line of a plain
+// scalar on line i: the lines below it indented deeper than its key or
+// item at column parent
+func (y *yamlWalker) plainEnd(i, parent int) int {
+	end := i + 1
+	for j := i + 1; j < len(y.lines); j++ {
+		text := y.lines[j].text
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			continue
+		}
+		if trimmed[0] == '#' || len(text)-len(strings.TrimLeft(text, " ")) <= parent {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// enterKey makes the mapping key at column col the innermost level
+func (y *yamlWalker) enterKey(col int, key string) {
+	for len(y.stack) > 0 && y.stack[len(y.stack)-1].indent >= col {
+		y.stack = y.stack[:len(y.stack)-1]
+	}
+	y.stack = append(y.stack, yamlLevel{indent: col, elem: pathElem{key: key}})
+}
+
+// enterItem makes the sequence item at column col the innermost level,
+// numbering it after the item it follows. Items may sit at the same
+// column as the key holding the sequence.
+func (y *yamlWalker) enterItem(col int) {
+	index := 0
+	for len(y.stack) > 0 {
+		top := y.stack[len(y.stack)-1]
+		if top.indent < col || (top.indent == col && !top.item) {
+			break
+		}
+		if top.indent == col {
+			index = top.elem.index + 1
+		}
+		y.stack = y.stack[:len(y.stack)-1]
+	}
+	y.stack = append(y.stack, yamlLevel{indent: col, elem: pathElem{index: index, isIndex: true}, item: true})
+}
+
+func (y *yamlWalker) path() []pathElem {
+	path := make([]pathElem, len(y.stack))
+	for i, level := range y.stack {
+		path[i] = level.elem
+	}
+	return path
+}
+
+// value walks the value starting at column col of line i, belonging to
+// the key or item at column parent
+func (y *yamlWalker) value(i, col, parent int) int {
+	l := y.lines[i]
+	v := l.text[col:]
+	switch {
+	case v == "", v[0] == '#':
+		// A nested block on the next lines
+		y.d.verbatim(v + l.eol)
+		return i + 1
+	case v[0] == '[', v[0] == '{':
+		return y.flow(i, col)
+	case v[0] == '*':
+		// An alias: its value is processed where its anchor is, and a
+		// merge key only pulls in a mapping
+		if len(y.stack) == 0 || y.stack[len(y.stack)-1].elem.key != "<<" {
+			y.unsupported(l.offset+col, "alias "+strings.Fields(v)[0])
+		}
+		y.d.verbatim(v + l.eol)
+		return i + 1
+	case v[0] == '&' || v[0] == '!':
+		// An anchor or a tag in front of the value
+		end := strings.IndexByte(v, ' ')
+		if end < 0 {
+			y.d.verbatim(v + l.eol)
+			return i + 1
+		}
+		sub := strings.TrimLeft(v[end:], " ")
+		y.d.verbatim(v[:len(v)-len(sub)])
+		return y.value(i, col+len(v)-len(sub), parent)
+	case v[0] == '|' || v[0] == '>':
+		y.d.verbatim(v + l.eol)
+		return y.blockScalar(i+1, parent, v[0] == '>')
+	case v[0] == '"' || v[0] == '\'':
+		end := quotedScalarEnd(v)
+		if end < 0 {
+			return y.quotedLines(i, col)
+		}
+		y.quoted(v[:end], l.offset+col)
+		y.d.verbatim(v[end:] + l.eol)
+		return i + 1
+	}
+	end := len(v)
+	if c := strings.Index(v, " #"); c >= 0 {
+		end = c
+	}
+	scalar := strings.TrimRight(v[:end], " \t")
+	if last := y.plainEnd(i, parent); last > i+1 {
+		y.unsupported(l.offset+col, "a plain scalar over several lines")
+		return y.verbatimLines(i, col, last)
+	}
+	y.plain(scalar, l.offset+col)
+	y.d.verbatim(v[len(scalar):] + l.eol)
+	return i + 1
+}
+
+// plain processes a plain scalar found at offset
+func (y *yamlWalker) plain(scalar string, offset int) {
+	if yamlNonString.MatchString(scalar) || !selected(y.selectors, y.path()) {
+		y.d.verbatim(scalar)
+		return
+	}
+	b := newSegment(offset)
+	b.prose(scalar)
+	t := y.process(b.segment())
+	if t.changed && !yamlPlainSafe(t.text) {
+		t.text = `"` + escapeYAMLDouble(t.text) + `"`
+	}
+	y.d.verbatim(t.text)
+}
+
+// quoted processes a single- or double-quoted scalar found at offset
+func (y *yamlWalker) quoted(scalar string, offset int) {
+	if !selected(y.selectors, y.path()) {
+		y.d.verbatim(scalar)
+		return
+	}
+	quote := scalar[:1]
+	b := newSegment(offset + 1)
+	if quote == `"` {
+		b.escape = escapeYAMLDouble
+		addYAMLDouble(b, scalar[1:len(scalar)-1])
+	} else {
+		b.escape = escapeYAMLSingle
+		addYAMLSingle(b, scalar[1:len(scalar)-1])
+	}
+	y.d.verbatim(quote + y.process(b.segment()).text + quote)
+}
+
+// blockScalar processes the lines of a | or > block scalar, from line i
+// to the last line indented deeper than its key or item at column
+// parent. The indentation of every line is kept as it is. The lines of
+// a folded scalar are read the way YAML folds them: two lines of text
+// at the block's indentation are one line of the value, so the
+// processor sees them joined by a space and a changed scalar writes
+// them as one line.
+func (y *yamlWalker) blockScalar(i, parent int, folded bool) int {
+	end := i
+	for j := i; j < len(y.lines); j++ {
+		text := y.lines[j].text
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if len(text)-len(strings.TrimLeft(text, " ")) <= parent {
+			break
+		}
+		end = j + 1
+	}
+	if end == i || !selected(y.selectors, y.path()) {
+		for _, l := range y.lines[i:end] {
+			y.d.verbatim(l.full())
+		}
+		return end
+	}
+	indent := blockIndent(y.lines[i:end])
+	b := newSegment(y.lines[i].offset)
+	for j, l := range y.lines[i:end] {
+		text := strings.TrimLeft(l.text, " \t")
+		if j > 0 {
+			prev := y.lines[i+j-1]
+			if folded && foldsLine(prev.text, indent) && foldsLine(l.text, indent) {
+				b.decoded(" ", prev.eol+l.text[:len(l.text)-len(text)])
+				b.prose(text)
+				continue
+			}
+			b.prose(prev.eol)
+		}
+		b.protect(l.text[:len(l.text)-len(text)])
+		b.prose(text)
+	}
+	t := y.d.transform(y.d.p, b.segment())
+	if t.changed {
+		before, after := dedent(t.before, indent), dedent(t.after, indent)
+		if folded {
+			before, after = foldYAML(before), foldYAML(after)
+		}
+		y.edit(before, after)
+	}
+	y.d.verbatim(t.text + y.lines[end-1].eol)
+	return end
+}
+
+// foldsLine reports whether a line of a folded block scalar indented by
+// indent folds into the lines around it: one with text at the block's
+// indentation, not an empty or a more indented one
+func foldsLine(text string, indent int) bool {
+	return len(text) > indent && text[indent] != ' ' && text[indent] != '\t' &&
+		strings.TrimLeft(text[:indent], " ") == ""
+}
+
+// foldYAML returns the value of a dedented folded block scalar: a line
+// break between two lines of text is a space, one followed by empty
+// lines gives way to a line feed for each of them, and breaks next to a
+// more indented line are kept
+func foldYAML(block string) string {
+	lines := strings.Split(block, "\n")
+	var sb strings.Builder
+	text, empty := -1, 0 // The last line of text and the empty lines after it
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			empty++
+			continue
+		}
+		spaced := line[0] == ' ' || line[0] == '\t'
+		switch {
+		case text < 0:
+			sb.WriteString(strings.Repeat("\n", empty))
+		case spaced || lines[text][0] == ' ' || lines[text][0] == '\t':
+			sb.WriteString(strings.Repeat("\n", empty+1))
+		case empty > 0:
+			sb.WriteString(strings.Repeat("\n", empty))
+		default:
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(line)
+		text, empty = i, 0
+	}
+	return sb.String()
+}
+
+// blockIndent returns the indentation of a block scalar: that of its
+// first line with text
+func blockIndent(lines []line) int {
+	for _, l := range lines {
+		if text := strings.TrimLeft(l.text, " "); text != "" {
+			return len(l.text) - len(text)
+		}
+	}
+	return 0
+}
+
+// dedent takes up to indent leading spaces off every line of block, so
+// an edit of a block scalar holds its value, as it does for other scalars
+func dedent(block string, indent int) string {
+	lines := strings.Split(block, "\n")
+	for i, line := range lines {
+		n := 0
+		for n < indent && n < len(line) && line[n] == ' ' {
+			n++
+		}
+		lines[i] = line[n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// process runs the processor over a scalar and records the edit
+func (y *yamlWalker) process(s segment) transformed {
+	t := y.d.transform(y.d.p, s)
+	if t.changed {
+		y.edit(t.before, t.after)
+	}
+	return t
+}
+
+// edit records a change to the value at the current path
+func (y *yamlWalker) edit(before, after string) {
+	y.d.edits = append(y.d.edits, Edit{Path: formatPath(y.path()), Before: before, After: after})
+}
+
+// yamlKey reads the key of a block mapping entry such as "key: value" or
+// "'a key':" and returns it with the offset where its value starts
+func yamlKey(s string) (key string, valueStart int, ok bool) {
+	if s == "" || strings.IndexByte("[{#&*!|>%@`?", s[0]) >= 0 {
+		return "", 0, false
+	}
+	colon := -1
+	if s[0] == '"' || s[0] == '\'' {
+		end := quotedScalarEnd(s)
+		if end < 0 || end >= len(s) || s[end] != ':' {
+			return "", 0, false
+		}
+		colon = end
+		key = decodeYAMLQuoted(s[:end])
+	} else {
+		for i := 0; i < len(s); i++ {
+			if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ' || s[i+1] == '\t') {
+				colon = i
+				break
+			}
+			if s[i] == '#' && i > 0 && s[i-1] == ' ' {
+				break
+			}
+		}
+		if colon <= 0 {
+			return "", 0, false
+		}
+		key = strings.TrimRight(s[:colon], " \t")
+	}
+	valueStart = colon + 1
+	for valueStart < len(s) && (s[valueStart] == ' ' || s[valueStart] == '\t') {
+		valueStart++
+	}
+	return key, valueStart, true
+}
+
+// quotedScalarEnd returns the offset just past the quoted scalar at the
+// start of s, or -1 when it doesn't close on this line
+func quotedScalarEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func decodeYAMLQuoted(s string) string {
+	b := newSegment(0)
+	if s[0] == '"' {
+		addYAMLDouble(b, s[1:len(s)-1])
+	} else {
+		addYAMLSingle(b, s[1:len(s)-1])
+	}
+	return b.segment().restore(b.text.String())
+}
+
+// addYAMLSingle adds the content of a single-quoted scalar, where ” is '
+func addYAMLSingle(b *segmentBuilder, raw string) {
+	for {
+		i := strings.Index(raw, "''")
+		if i < 0 {
+			break
+		}
+		b.prose(raw[:i])
+		b.decoded("'", "''")
+		raw = raw[i+2:]
+	}
+	b.prose(raw)
+}
+
+// addYAMLDouble adds the content of a double-quoted scalar, decoding its
+// escapes
+func addYAMLDouble(b *segmentBuilder, raw string) {
+	for {
+		i := strings.IndexByte(raw, '\\')
+		if i < 0 {
+			break
+		}
+		b.prose(raw[:i])
+		raw = raw[i:]
+		text, size := decodeYAMLEscape(raw)
+		b.decoded(text, raw[:size])
+		raw = raw[size:]
+	}
+	b.prose(raw)
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v",
+	'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': `"`, '/': "/", '\\': `\`,
+	'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// decodeYAMLEscape decodes the escape sequence at the start of s and
+// returns its text and length
+func decodeYAMLEscape(s string) (string, int) {
+	if len(s) < 2 {
+		return s, len(s)
+	}
+	if text, ok := yamlEscapes[s[1]]; ok {
+		return text, 2
+	}
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[1]]
+	if digits > 0 && len(s) >= 2+digits {
+		if n, err := strconv.ParseUint(s[2:2+digits], 16, 32); err == nil {
+			return string(rune(n)), 2 + digits
+		}
+	}
+	return s[:2], 2
+}
+
+// escapeYAMLDouble escapes processed text for a double-quoted scalar
+func escapeYAMLDouble(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\x%02x`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// escapeYAMLSingle escapes processed text for a single-quoted scalar
+func escapeYAMLSingle(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+// yamlPlainSafe reports whether s reads back as the same string when
+// written as a plain scalar
+func yamlPlainSafe(s string) bool {
+	switch {
+	case s == "", strings.TrimSpace(s) != s, strings.ContainsAny(s, "\n\r"):
+		return false
+	case strings.IndexByte(",[]{}#&*!|>'\"%@`", s[0]) >= 0:
+		return false
+	case strings.HasPrefix(s, "- "), strings.HasPrefix(s, "? "), strings.HasPrefix(s, ": "):
+		return false
+	case strings.Contains(s, ": "), strings.Contains(s, " #"), strings.HasSuffix(s, ":"):
+		return false
+	}
+	return !yamlNonString.MatchString(s)
+}
+
+// isYAMLDocumentMarker matches the --- and ... lines between documents
+func isYAMLDocumentMarker(text string) bool {
+	for _, marker := range []string{"---", "..."} {
+		if text == marker || strings.HasPrefix(text, marker+" ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package formatters
+
+import (
+	"strings"
+	"unicode"
+)
+
+// elisions are words spelled with a leading or trailing apostrophe,
+// written with their apostrophes and in lower case
+var elisions = map[string]bool{
+	"'tis":   true,
+	"'twas":  true,
+	"'twill": true,
+	"'em":    true,
+	"'cause": true,
+	"'til":   true,
+	"'bout":  true,
+	"'round": true,
+	"'cept":  true,
+	"'nuff":  true,
+	"'n":     true,
+	"'n'":    true,
+	"o'":     true,
+	"ol'":    true,
+}
+
+// IsLeadingApostrophe reports whether a ' written directly before word
+// belongs to it instead of opening a quote: 'tis, 'n', '90s
+func IsLeadingApostrophe(word string) bool {
+	if elisions["'"+strings.ToLower(word)] {
+		return true
+	}
+	return isDecade(word)
+}
+
+// TrailingApostrophe reports whether a ' written directly after word can
+// belong to it: the students' books, goin', rock 'n' roll. Ambiguous is
+// set when the mark could just as well close a quote, so the caller has
+// to look at the context.
+func TrailingApostrophe(word string) (apostrophe, ambiguous bool) {
+	lower := strings.ReplaceAll(strings.ToLower(word), "’", "'")
+	if elisions[lower+"'"] {
+		return true, false
+	}
+	runes := []rune(lower)
+	if len(runes) < 2 || !unicode.IsLetter(runes[len(runes)-1]) {
+		return false, false
+	}
+	if strings.HasSuffix(lower, "s") || strings.HasSuffix(lower, "in") {
+		return true, true
+	}
+	return false, false
+}
+
+// isDecade matches the digits of an abbreviated year: 90s, 60, 20s
+func isDecade(word string) bool {
+	digits := strings.TrimSuffix(word, "s")
+	if len(digits) != 2 {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
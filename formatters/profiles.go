@@ -0,0 +1,123 @@
+package formatters
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	noBreakSpace       = "\u00A0"
+	narrowNoBreakSpace = "\u202F"
+)
+
+// Profile holds the punctuation spacing conventions of one language.
+// The zero value is the English profile: marks are glued to the word
+// they attach to.
+type Profile struct {
+	Name string
+	// before is written between a word and a mark that attaches to its left
+	before map[rune]string
+	// after is written between a mark that attaches to its right and the next word
+	after map[rune]string
+	// inverted maps a closing ? or ! to the opening mark the language requires
+	inverted map[rune]rune
+	// quotes are the typographic pairs straight quotes become with smart
+	// quotes: "double" becomes the first pair, 'single' the second
+	quotes [2][2]string
+}
+
+var englishQuotes = [2][2]string{{"“", "”"}, {"‘", "’"}}
+
+var profiles = []Profile{
+	{Name: "en", quotes: englishQuotes},
+	{
+		Name: "fr",
+		before: map[rune]string{
+			';': narrowNoBreakSpace,
+			'!': narrowNoBreakSpace,
+			'?': narrowNoBreakSpace,
+			'‽': narrowNoBreakSpace,
+			':': noBreakSpace,
+			'»': noBreakSpace,
+		},
+		after: map[rune]string{
+			'«': noBreakSpace,
+		},
+		quotes: [2][2]string{{"«", "»"}, {"“", "”"}},
+	},
+	{
+		Name: "es",
+		inverted: map[rune]rune{
+			'?': '¿',
+			'!': '¡',
+		},
+		quotes: [2][2]string{{"«", "»"}, {"“", "”"}},
+	},
+	{
+		Name:   "de",
+		quotes: [2][2]string{{"„", "“"}, {"‚", "‘"}},
+	},
+}
+
+// ParseProfile returns the profile for a language code such as "fr".
+// An empty name gives the English profile.
+func ParseProfile(name string) (Profile, error) {
+	if name == "" {
+		return profiles[0], nil
+	}
+	for _, profile := range profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown language %q (want %s)", name, strings.Join(ProfileNames(), ", "))
+}
+
+// ProfileNames returns the language codes ParseProfile accepts, English first
+func ProfileNames() []string {
+	names := make([]string, len(profiles))
+	for i, profile := range profiles {
+		names[i] = profile.Name
+	}
+	return names
+}
+
+// SpaceBefore returns the space written between a word and the
+// punctuation group that follows it; "" glues the group to the word
+func (p Profile) SpaceBefore(group string) string {
+	for _, mark := range group {
+		return p.before[mark]
+	}
+	return ""
+}
+
+// SpaceAfter returns the space written between an opening mark and the word it opens
+func (p Profile) SpaceAfter(mark string) string {
+	for _, r := range mark {
+		return p.after[r]
+	}
+	return ""
+}
+
+// InvertedMark returns the opening mark a sentence ending in mark needs,
+// such as ¿ before a Spanish question
+func (p Profile) InvertedMark(mark rune) (rune, bool) {
+	opening, ok := p.inverted[mark]
+	return opening, ok
+}
+
+// SmartQuotes returns the typographic pair a straight quote mark becomes.
+// It reports false for marks that are already typographic.
+func (p Profile) SmartQuotes(mark string) (open, close string, ok bool) {
+	quotes := p.quotes
+	if quotes[0][0] == "" {
+		quotes = englishQuotes
+	}
+	switch mark {
+	case "\"":
+		return quotes[0][0], quotes[0][1], true
+	case "'":
+		return quotes[1][0], quotes[1][1], true
+	}
+	return "", "", false
+}
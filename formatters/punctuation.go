@@ -1,10 +1,239 @@
 package formatters
 
-// FormatPunctuation formats punctuation with proper spacing
-// Punctuation sticks to previous word, space after
-// Note: Spacing is handled by FSM, this just returns the punctuation
-func FormatPunctuation(punct string) string {
-	// For now, just return as-is since FSM handles spacing
-	// Punctuation groups (e.g., "...") are already combined by FSM
-	return punct
+import (
+	"fmt"
+	"strings"
+)
+
+// Spacing says how a punctuation mark sits between its neighbours
+type Spacing int
+
+const (
+	// AttachLeft marks stick to the previous word, with a space after: "word, next"
+	AttachLeft Spacing = iota
+	// AttachRight marks stick to the next word, with a space before: "word (next"
+	AttachRight
+	// SpaceBoth marks stand alone with a space on each side: "word — next".
+	// One written between two words joins them instead: "10–20".
+	SpaceBoth
+)
+
+// PunctuationRule describes one punctuation mark
+type PunctuationRule struct {
+	Mark    rune
+	Spacing Spacing
+	// Boundary marks end a modifier's reach: words before them can't be
+	// counted by a modifier after them. Brackets and inverted marks wrap
+	// words instead of separating them, so they aren't boundaries.
+	Boundary bool
+}
+
+// punctuationTable lists every mark the lexer reports as punctuation
+var punctuationTable = []PunctuationRule{
+	{'.', AttachLeft, true},
+	{',', AttachLeft, true},
+	{'!', AttachLeft, true},
+	{'?', AttachLeft, true},
+	{':', AttachLeft, true},
+	{';', AttachLeft, true},
+	{'…', AttachLeft, true},
+	{'‽', AttachLeft, true},
+	{')', AttachLeft, false},
+	{']', AttachLeft, false},
+	{'}', AttachLeft, false},
+	{'(', AttachRight, false},
+	{'[', AttachRight, false},
+	{'{', AttachRight, false},
+	{'¿', AttachRight, false},
+	{'¡', AttachRight, false},
+	{'—', SpaceBoth, true},
+	{'–', SpaceBoth, true},
+}
+
+var punctuationRules = func() map[rune]PunctuationRule {
+	rules := make(map[rune]PunctuationRule, len(punctuationTable))
+	for _, rule := range punctuationTable {
+		rules[rule.Mark] = rule
+	}
+	return rules
+}()
+
+// LookupPunctuation returns the rule for mark, if it is punctuation
+func LookupPunctuation(mark rune) (PunctuationRule, bool) {
+	rule, ok := punctuationRules[mark]
+	return rule, ok
+}
+
+// IsPunctuation reports whether mark has a punctuation rule
+func IsPunctuation(mark rune) bool {
+	_, ok := punctuationRules[mark]
+	return ok
+}
+
+// PunctuationOptions picks which normalizations FormatPunctuation applies.
+// The zero value applies none and returns every group unchanged.
+type PunctuationOptions struct {
+	// Ellipsis rewrites runs of two or more dots, and the … character,
+	// to "..." or "…". Empty keeps them as written.
+	Ellipsis string
+	// CollapseRepeats squeezes a run of the same mark down to one: "!!!!" becomes "!"
+	CollapseRepeats bool
+	// Interrobang rewrites a run mixing ? and ! to "?!" or "‽". Empty keeps it.
+	Interrobang string
+	// DropComma removes a comma that runs into another mark: ",." becomes "."
+	DropComma bool
+}
+
+// PunctuationEdit records one normalization FormatPunctuation applied to a group
+type PunctuationEdit struct {
+	Rule   string
+	Before string
+	After  string
+}
+
+// punctuationNormalizers run in order, each on the output of the previous one
+var punctuationNormalizers = []struct {
+	rule  string
+	apply func(group string, opts PunctuationOptions) string
+}{
+	{"drop-comma", dropComma},
+	{"ellipsis", normalizeEllipsis},
+	{"collapse-repeats", collapseRepeats},
+	{"interrobang", normalizeInterrobang},
+}
+
+// FormatPunctuation normalizes a group of consecutive punctuation marks
+// such as "!!!" or ",..." and reports every rule that changed it.
+// Spacing around the group is handled by the FSM.
+func FormatPunctuation(punct string, opts PunctuationOptions) (string, []PunctuationEdit) {
+	var edits []PunctuationEdit
+	for _, n := range punctuationNormalizers {
+		if after := n.apply(punct, opts); after != punct {
+			edits = append(edits, PunctuationEdit{Rule: n.rule, Before: punct, After: after})
+			punct = after
+		}
+	}
+	return punct, edits
+}
+
+func dropComma(group string, opts PunctuationOptions) string {
+	if !opts.DropComma || !strings.Contains(group, ",") {
+		return group
+	}
+	runes := []rune(group)
+	var sb strings.Builder
+	for i, r := range runes {
+		if r == ',' && i+1 < len(runes) && runes[i+1] != ',' {
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func normalizeEllipsis(group string, opts PunctuationOptions) string {
+	if opts.Ellipsis == "" {
+		return group
+	}
+	runes := []rune(group)
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		if !isEllipsisRune(runes[i]) {
+			sb.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isEllipsisRune(runes[j]) {
+			j++
+		}
+		if j-i == 1 && runes[i] == '.' {
+			sb.WriteRune('.') // a lone period is not an ellipsis
+		} else {
+			sb.WriteString(opts.Ellipsis)
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+func isEllipsisRune(r rune) bool {
+	return r == '.' || r == '…'
+}
+
+func collapseRepeats(group string, opts PunctuationOptions) string {
+	if !opts.CollapseRepeats {
+		return group
+	}
+	var sb strings.Builder
+	var prev rune
+	for _, r := range group {
+		// Dots are left to the ellipsis rule
+		if r == prev && r != '.' {
+			continue
+		}
+		sb.WriteRune(r)
+		prev = r
+	}
+	return sb.String()
+}
+
+func normalizeInterrobang(group string, opts PunctuationOptions) string {
+	if opts.Interrobang == "" {
+		return group
+	}
+	runes := []rune(group)
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		if !isInterrobangRune(runes[i]) {
+			sb.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		mixed := false
+		for j < len(runes) && isInterrobangRune(runes[j]) {
+			mixed = mixed || runes[j] != runes[i] || runes[j] == '‽'
+			j++
+		}
+		if mixed {
+			sb.WriteString(opts.Interrobang)
+		} else {
+			sb.WriteString(string(runes[i:j]))
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+func isInterrobangRune(r rune) bool {
+	return r == '?' || r == '!' || r == '‽'
+}
+
+// ParseEllipsis maps a style name to PunctuationOptions.Ellipsis:
+// "keep" (or empty), "dots" for "..." and "char" for "…"
+func ParseEllipsis(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "keep":
+		return "", nil
+	case "dots":
+		return "...", nil
+	case "char":
+		return "…", nil
+	}
+	return "", fmt.Errorf("unknown ellipsis style %q (want keep, dots or char)", name)
+}
+
+// ParseInterrobang maps a style name to PunctuationOptions.Interrobang:
+// "keep" (or empty), "pair" for "?!" and "char" for "‽"
+func ParseInterrobang(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "keep":
+		return "", nil
+	case "pair":
+		return "?!", nil
+	case "char":
+		return "‽", nil
+	}
+	return "", fmt.Errorf("unknown interrobang style %q (want keep, pair or char)", name)
 }
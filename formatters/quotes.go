@@ -30,3 +30,61 @@ func FormatDoubleQuote(words []string) string {
 
 	return "\"" + strings.Join(words, " ") + "\""
 }
+
+// quoteCloses maps every quote mark to the marks that can close a quote
+// it opened. Straight quotes close themselves; typographic ones close with
+// their partner, in every direction some language uses.
+var quoteCloses = map[string][]string{
+	"'":  {"'"},
+	"\"": {"\""},
+	"‘":  {"’"},
+	"’":  {"’"},
+	"“":  {"”"},
+	"”":  {"”"},
+	"„":  {"“", "”"},
+	"‚":  {"‘", "’"},
+	"«":  {"»"},
+	"»":  {"«", "»"},
+	"‹":  {"›"},
+	"›":  {"‹", "›"},
+}
+
+// IsQuoteMark reports whether r delimits quotes: ' " ‘ ’ “ ” „ ‚ « » ‹ ›
+func IsQuoteMark(r rune) bool {
+	_, ok := quoteCloses[string(r)]
+	return ok
+}
+
+// ClosesQuote reports whether close ends a quote opened with open
+func ClosesQuote(open, close string) bool {
+	for _, mark := range quoteCloses[open] {
+		if mark == close {
+			return true
+		}
+	}
+	return false
+}
+
+// ClosingQuote returns the usual mark that closes a quote opened with open
+func ClosingQuote(open string) string {
+	if closes := quoteCloses[open]; len(closes) > 0 {
+		return closes[0]
+	}
+	return open
+}
+
+// IsStraightQuote reports whether mark is ' or "
+func IsStraightQuote(mark string) bool {
+	return mark == "'" || mark == "\""
+}
+
+// FormatQuotePair formats words between any pair of quote marks: “hi”
+func FormatQuotePair(open, close string, words []string) string {
+	return open + strings.Join(words, " ") + close
+}
+
+// SmartApostrophes turns the straight apostrophes of a word into
+// typographic ones: don't becomes don’t
+func SmartApostrophes(word string) string {
+	return strings.ReplaceAll(word, "'", "’")
+}
@@ -0,0 +1,89 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Diagnostic reports something in the input the processor had to work around.
+// Processing never stops for a diagnostic; they are collected and returned
+// alongside the output by Processor.Diagnostics.
+type Diagnostic struct {
+	Offset  int    // Byte offset in the input
+	Line    int    // 1-based line number
+	Column  int    // 1-based column, counted in characters
+	Code    string // Short machine-readable identifier such as "invalid-utf8"
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// Diagnostics returns what the last call to Process reported, in input order
+func (p *Processor) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// report records a diagnostic at a byte offset of the prepared text
+func (p *Processor) report(offset int, code, format string, args ...any) {
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Offset:  offset + p.offsetShift,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// LocateDiagnostics fills in Line and Column for every diagnostic
+// with a single pass over input
+func LocateDiagnostics(input string, diagnostics []Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Offset < diagnostics[j].Offset
+	})
+	l := locator{input: input, line: 1, column: 1}
+	for i := range diagnostics {
+		diagnostics[i].Line, diagnostics[i].Column = l.locate(diagnostics[i].Offset)
+	}
+}
+
+// LocateExplanations fills in Line and Column for every explanation
+func LocateExplanations(input string, explanations []Explanation) {
+	sort.SliceStable(explanations, func(i, j int) bool {
+		return explanations[i].Offset < explanations[j].Offset
+	})
+	l := locator{input: input, line: 1, column: 1}
+	for i := range explanations {
+		explanations[i].Line, explanations[i].Column = l.locate(explanations[i].Offset)
+	}
+}
+
+// locator turns byte offsets into line and column, reading input forward
+// only, so offsets must be asked for in increasing order
+type locator struct {
+	input        string
+	line, column int
+	pos          int
+}
+
+func (l *locator) locate(offset int) (line, column int) {
+	input := l.input
+	target := min(offset, len(input))
+	for l.pos < target {
+		r, size := utf8.DecodeRuneInString(input[l.pos:])
+		switch {
+		case r == '\n':
+			l.line++
+			l.column = 1
+		case r == '\r' && (l.pos+1 >= len(input) || input[l.pos+1] != '\n'):
+			l.line++
+			l.column = 1
+		case r == '\r':
+			// \r\n counts once, at the \n
+		default:
+			l.column++
+		}
+		l.pos += size
+	}
+	return l.line, l.column
+}
@@ -0,0 +1,131 @@
+package fsm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// emitter builds the output in a single forward pass.
+// Separating whitespace is tracked lazily as "pending" and only written
+// once the next piece of text arrives, so nothing ever has to be trimmed
+// or rewritten after the fact.
+type emitter struct {
+	out strings.Builder
+	//separator requested but not written yet
+	pending string
+	//pending came from the input and is kept even at the start of a line
+	verbatim bool
+	//last byte written, 0 when nothing was written
+	last byte
+	//characters written since the last newline
+	column int
+	//wrap lines longer than this many characters, 0 disables wrapping
+	width int
+	//line break written when wrapping
+	wrapBreak string
+}
+
+func (e *emitter) reset(width int, wrapBreak string) {
+	e.out.Reset()
+	e.pending = ""
+	e.verbatim = false
+	e.last = 0
+	e.column = 0
+	e.width = width
+	e.wrapBreak = wrapBreak
+}
+
+// prefix writes s ahead of everything else without counting it as
+// content, so the output still starts at the start of a line
+func (e *emitter) prefix(s string) {
+	e.out.WriteString(s)
+}
+
+// space requests a single space before the next piece of text.
+// It is dropped at the start of the output and at the start of a line.
+func (e *emitter) space() {
+	e.pending = " "
+	e.verbatim = false
+}
+
+// gap requests the exact whitespace ws before the next piece of text.
+// Unlike space it is also written at the start of a line, which keeps indentation.
+func (e *emitter) gap(ws string) {
+	e.pending = ws
+	e.verbatim = true
+}
+
+// text writes s, preceded by the pending separator if there is one.
+func (e *emitter) text(s string) {
+	if s == "" {
+		return
+	}
+	if e.pending != "" && (e.verbatim || !e.atLineStart()) {
+		if e.overflows(s) {
+			e.write(e.wrapBreak)
+		} else {
+			e.write(e.pending)
+		}
+	}
+	e.pending = ""
+	e.write(s)
+}
+
+// attach writes s glued to whatever came before it, dropping the pending separator.
+func (e *emitter) attach(s string) {
+	e.pending = ""
+	e.write(s)
+}
+
+// hug writes s against the text before it, keeping the pending separator
+// for the text after it
+func (e *emitter) hug(s string) {
+	e.write(s)
+}
+
+// newline ends the current line with ending; a pending separator is never written before it.
+func (e *emitter) newline(ending string) {
+	e.pending = ""
+	e.write(ending)
+}
+
+// atLineStart reports whether nothing was written yet on the current line
+func (e *emitter) atLineStart() bool {
+	return e.last == 0 || e.last == '\n' || e.last == '\r'
+}
+
+// overflows reports whether writing the pending separator and s
+// would push the current line past the wrap width. Lines never wrap at
+// a no-break space.
+func (e *emitter) overflows(s string) bool {
+	if e.width <= 0 || e.column == 0 || strings.ContainsAny(e.pending, "\r\n") || isNoBreakSpace(e.pending) {
+		return false
+	}
+	first := s
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		first = s[:i]
+	}
+	return e.column+utf8.RuneCountInString(e.pending)+utf8.RuneCountInString(first) > e.width
+}
+
+// isNoBreakSpace reports whether ws is a single no-break space
+func isNoBreakSpace(ws string) bool {
+	return ws == "\u00a0" || ws == "\u2007" || ws == "\u202f"
+}
+
+func (e *emitter) write(s string) {
+	if s == "" {
+		return
+	}
+	e.out.WriteString(s)
+	e.last = s[len(s)-1]
+	if i := strings.LastIndexAny(s, "\r\n"); i >= 0 {
+		e.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		e.column += utf8.RuneCountInString(s)
+	}
+}
+
+func (e *emitter) String() string {
+	return e.out.String()
+}
@@ -0,0 +1,37 @@
+package fsm
+
+import "fmt"
+
+// Explanation records one edit the processor made on its own initiative,
+// such as normalizing "!!!!" to "!". They are only collected when
+// Options.Explain is set, and returned by Processor.Explain.
+type Explanation struct {
+	Offset int    // Byte offset in the input
+	Line   int    // 1-based line number
+	Column int    // 1-based column, counted in characters
+	Rule   string // Short machine-readable rule name such as "ellipsis"
+	Before string
+	After  string
+}
+
+func (e Explanation) String() string {
+	return fmt.Sprintf("%d:%d: %s: %q -> %q", e.Line, e.Column, e.Rule, e.Before, e.After)
+}
+
+// Explain returns the edits the last call to Process explained, in input order
+func (p *Processor) Explain() []Explanation {
+	return p.explanations
+}
+
+// explain records an edit at a byte offset of the prepared text
+func (p *Processor) explain(offset int, rule, before, after string) {
+	if !p.opts.Explain {
+		return
+	}
+	p.explanations = append(p.explanations, Explanation{
+		Offset: offset + p.offsetShift,
+		Rule:   rule,
+		Before: before,
+		After:  after,
+	})
+}
@@ -0,0 +1,34 @@
+package fsm
+
+import "strings"
+
+// grower joins strings that grow one token at a time, as the text of
+// a$b$c... does, in amortized constant time. The strings it returns
+// share one buffer: a string that is still the last one returned is
+// extended in place instead of being copied again.
+type grower struct {
+	sb   strings.Builder
+	last string
+}
+
+// join returns s followed by more
+func (g *grower) join(s, more string) string {
+	if more == "" {
+		return s
+	}
+	if s != g.last || s == "" {
+		// A different string: start a new buffer, the strings already
+		// returned keep theirs
+		g.sb = strings.Builder{}
+		g.sb.WriteString(s)
+	}
+	g.sb.WriteString(more)
+	g.last = g.sb.String()
+	return g.last
+}
+
+// reset drops the buffer
+func (g *grower) reset() {
+	g.sb = strings.Builder{}
+	g.last = ""
+}
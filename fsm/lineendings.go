@@ -0,0 +1,92 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LineEnding selects how line breaks are written
+type LineEnding int
+
+const (
+	// LineEndingPreserve keeps every line break exactly as it was in the input
+	LineEndingPreserve LineEnding = iota
+	// LineEndingAuto rewrites every line break in the style most used by the input
+	LineEndingAuto
+	// LineEndingLF writes \n
+	LineEndingLF
+	// LineEndingCRLF writes \r\n
+	LineEndingCRLF
+	// LineEndingCR writes \r
+	LineEndingCR
+)
+
+var lineEndingNames = map[LineEnding]string{
+	LineEndingPreserve: "preserve",
+	LineEndingAuto:     "auto",
+	LineEndingLF:       "lf",
+	LineEndingCRLF:     "crlf",
+	LineEndingCR:       "cr",
+}
+
+func (l LineEnding) String() string {
+	if name, ok := lineEndingNames[l]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseLineEnding maps a name such as "crlf" to its LineEnding
+func ParseLineEnding(name string) (LineEnding, error) {
+	for l, n := range lineEndingNames {
+		if strings.EqualFold(name, n) {
+			return l, nil
+		}
+	}
+	return LineEndingPreserve, fmt.Errorf("unknown line ending %q (want preserve, auto, lf, crlf or cr)", name)
+}
+
+// sequence returns the characters written for l
+func (l LineEnding) sequence() string {
+	switch l {
+	case LineEndingCRLF:
+		return "\r\n"
+	case LineEndingCR:
+		return "\r"
+	default:
+		return "\n"
+	}
+}
+
+// DetectLineEnding returns the line ending style used most often in input:
+// LineEndingLF, LineEndingCRLF or LineEndingCR. Input without line breaks,
+// and ties, report LineEndingLF.
+func DetectLineEnding(input string) LineEnding {
+	lf, crlf, cr := 0, 0, 0
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '\n':
+			lf++
+		case '\r':
+			if i+1 < len(input) && input[i+1] == '\n' {
+				crlf++
+				i++
+			} else {
+				cr++
+			}
+		}
+	}
+	switch {
+	case crlf > lf && crlf >= cr:
+		return LineEndingCRLF
+	case cr > lf && cr > crlf:
+		return LineEndingCR
+	default:
+		return LineEndingLF
+	}
+}
+
+// isLineBreak reports whether a recorded separator starts with a line break
+func isLineBreak(space string) bool {
+	return strings.HasPrefix(space, "\n") || strings.HasPrefix(space, "\r")
+}
@@ -0,0 +1,185 @@
+package fsm
+
+import (
+	"go-reloaded/formatters"
+	"go-reloaded/lexer"
+	"go-reloaded/transforms"
+	"strings"
+)
+
+// Modifier describes one of the (name) markers the processor applies to
+// the words before it
+type Modifier struct {
+	Name    string
+	Summary string // One line saying what it does, for help and editors
+	Counted bool   // Takes a word count, as in (up, 2), or q for a whole quote
+	Apply   func(string) string
+}
+
+// modifiers is the registry, in the order editors list them
+var modifiers = []Modifier{
+	{Name: "up", Summary: "Uppercase the word before it", Counted: true, Apply: transforms.ToUpper},
+	{Name: "low", Summary: "Lowercase the word before it", Counted: true, Apply: transforms.ToLower},
+	{Name: "cap", Summary: "Capitalize the word before it", Counted: true, Apply: transforms.Capitalize},
+	{Name: "hex", Summary: "Replace the hexadecimal number before it by its decimal value", Apply: transforms.HexToDec},
+	{Name: "bin", Summary: "Replace the binary number before it by its decimal value", Apply: transforms.BinToDec},
+}
+
+// Modifiers returns every modifier the processor knows
+func Modifiers() []Modifier {
+	return append([]Modifier(nil), modifiers...)
+}
+
+// LookupModifier returns the modifier called name
+func LookupModifier(name string) (Modifier, bool) {
+	for _, m := range modifiers {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Modifier{}, false
+}
+
+// Span is a byte range of the input
+type Span struct {
+	Offset int
+	End    int
+}
+
+// AppliedModifier records a modifier the processor applied and the words
+// it changed, so an editor can show or redo that single edit
+type AppliedModifier struct {
+	Span             // The modifier itself, as written
+	Name    string   // Its type: "up", "hex"...
+	Targets []Span   // The words it applied to, in input order
+	Results []string // What each target reads in the output
+}
+
+// AppliedModifiers returns the modifiers the last call to Process
+// applied, in input order
+func (p *Processor) AppliedModifiers() []AppliedModifier {
+	return p.applied
+}
+
+// recordModifier records an applied modifier and the entries it reached
+func (p *Processor) recordModifier(token lexer.Token, modType string, targets []entry) {
+	applied := AppliedModifier{
+		Span: Span{Offset: token.Offset + p.offsetShift, End: token.End() + p.offsetShift},
+		Name: modType,
+	}
+	for i := len(targets) - 1; i >= 0; i-- {
+		if e := targets[i]; e.end > e.offset {
+			if p.targets == nil {
+				p.targets = make(map[int][]targetRef)
+			}
+			p.targets[e.offset] = append(p.targets[e.offset], targetRef{modifier: len(p.applied), target: len(applied.Targets)})
+			applied.Targets = append(applied.Targets, Span{Offset: e.offset + p.offsetShift, End: e.end + p.offsetShift})
+			applied.Results = append(applied.Results, e.text)
+		}
+	}
+	p.applied = append(p.applied, applied)
+}
+
+// targetRef points at one target of an applied modifier
+type targetRef struct {
+	modifier, target int
+}
+
+// recordResult records the text of a word as it is written to the
+// output for every modifier that targeted it, so chained modifiers and
+// a/an corrections made after a modifier show up in its results
+func (p *Processor) recordResult(e entry) {
+	if e.end <= e.offset {
+		return
+	}
+	for _, ref := range p.targets[e.offset] {
+		text := e.text
+		if p.opts.SmartQuotes {
+			text = formatters.SmartApostrophes(text)
+		}
+		p.applied[ref.modifier].Results[ref.target] = text
+	}
+}
+
+// checkModifier reports a modifier the processor is about to leave in
+// the text: one with a count too large to read, one with no word to apply
+// to, or a known name in the wrong case, as in (UP)
+func (p *Processor) checkModifier(token lexer.Token, modifier string) {
+	if _, _, err := parseModifier(modifier); err != nil && isModifier(modifier) {
+		p.report(token.Offset, "malformed-modifier", "%s has a count too large to apply, kept as text", modifier)
+		return
+	}
+	if isModifier(modifier) {
+		p.report(token.Offset, "modifier-no-word", "%s has no word to apply to, kept as text", modifier)
+		return
+	}
+	if lower := strings.ToLower(modifier); isModifier(lower) {
+		p.report(token.Offset, "unknown-modifier", "%s is not a modifier, did you mean %s?", modifier, lower)
+	}
+}
+
+// checkModifierShape reports a modifier whose count the lexer couldn't
+// read, so it was left as text: (up, x2), (cap, 1.5), (low,). A count
+// without digits is left alone unless it spells q or quote, since
+// "(low, high)" is ordinary prose.
+func (p *Processor) checkModifierShape(open lexer.Token) {
+	i := p.skipBlanks(1)
+	name, ok := p.peek(i)
+	if !ok || name.Kind != lexer.Word {
+		return
+	}
+	if _, known := LookupModifier(strings.ToLower(name.Text)); !known {
+		return
+	}
+	i = p.skipBlanks(i + 1)
+	if comma, ok := p.peek(i); !ok || comma.Text != "," {
+		return
+	}
+	var count strings.Builder
+	for i++; ; i++ {
+		token, ok := p.peek(i)
+		if !ok || token.Kind == lexer.Newline || token.Text == "," || token.Text == "(" {
+			return
+		}
+		if token.Text == ")" {
+			text := strings.TrimSpace(count.String())
+			if strings.ContainsAny(text, " \t") || !isMalformedCount(text) {
+				return
+			}
+			p.report(open.Offset, "malformed-modifier", "%s has a count that isn't a number, q or quote, kept as text", p.lookaheadText(i+1))
+			return
+		}
+		count.WriteString(token.Text)
+	}
+}
+
+// isMalformedCount reports whether the count of a modifier the lexer
+// rejected was meant as one
+func isMalformedCount(count string) bool {
+	if count == "" || strings.EqualFold(count, "q") || strings.EqualFold(count, "quote") {
+		return true
+	}
+	return strings.ContainsAny(count, "0123456789")
+}
+
+// skipBlanks returns the lookahead index of the first token at or after i
+// that isn't whitespace
+func (p *Processor) skipBlanks(i int) int {
+	for {
+		token, ok := p.peek(i)
+		if !ok || !isSkipped(token) {
+			return i
+		}
+		i++
+	}
+}
+
+// lookaheadText returns the text of the next n tokens
+func (p *Processor) lookaheadText(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		token, _ := p.peek(i)
+		sb.WriteString(token.Text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,85 @@
+package fsm
+
+import "go-reloaded/formatters"
+
+// Options tunes how a Processor lays out its output.
+// The zero value gives the classic behaviour: every run of blanks
+// between words collapses to a single space.
+type Options struct {
+	// PreserveWhitespace re-emits the original whitespace between tokens:
+	// tabs, runs of spaces and indentation survive. Only spacing that
+	// touches punctuation or a quote is still normalized, and trailing
+	// blanks at the end of a line are still dropped.
+	PreserveWhitespace bool
+
+	// CollapseBlankLines squeezes every run of blank lines down to one,
+	// so paragraphs are always separated by exactly one empty line.
+	CollapseBlankLines bool
+
+	// ParagraphBoundaries scopes modifiers and a/an lookahead to the
+	// paragraph instead of the line: they reach across the line breaks of
+	// a hard-wrapped paragraph, while a blank line stays a hard boundary.
+	// Without it every line break is a hard boundary.
+	ParagraphBoundaries bool
+
+	// UnwrapParagraphs joins the hard-wrapped lines of a paragraph into a
+	// single line. It implies ParagraphBoundaries.
+	UnwrapParagraphs bool
+
+	// WrapWidth, when positive, breaks lines at the last space that keeps
+	// them within WrapWidth characters. Together with UnwrapParagraphs it
+	// reflows paragraphs to a new width. Words longer than the width are
+	// never split.
+	WrapWidth int
+
+	// LineEndings picks how line breaks are written. The zero value keeps
+	// each line's original \n, \r\n or \r, so mixed files round-trip.
+	LineEndings LineEnding
+
+	// StripBOM drops a UTF-8 byte order mark found at the start of the
+	// input. By default it is kept and written back in front of the output.
+	StripBOM bool
+
+	// Normalization applies NFC or NFD to the input before tokenizing.
+	// Diagnostic positions then refer to the normalized text.
+	Normalization Normalization
+
+	// Profile sets the punctuation spacing of a language. The zero value
+	// is English; formatters.ParseProfile("fr") puts no-break spaces
+	// before ; : ! ? and inside « », and "es" reports questions and
+	// exclamations that lack their opening ¿ or ¡.
+	Profile formatters.Profile
+
+	// SmartQuotes writes straight quotes as the typographic pair of the
+	// Profile's language, “double” and ‘single’ in English, « » in French,
+	// and straight apostrophes as ’. Typographic quotes in the input are
+	// always kept as they are.
+	SmartQuotes bool
+
+	// Punctuation picks the normalizations applied to each group of
+	// punctuation marks, such as "!!!!" to "!". The zero value keeps
+	// every group as written.
+	Punctuation formatters.PunctuationOptions
+
+	// QuoteRecovery picks what happens to a quote that is never closed.
+	// A quote can't reach past its paragraph (past its line by default),
+	// so it is resolved there. Every recovery is reported as a diagnostic
+	// pointing at the open quote.
+	QuoteRecovery QuoteRecovery
+
+	// Explain records every normalization the processor makes, for
+	// Processor.Explain to return.
+	Explain bool
+
+	// Workers, when above 1, lets Process cut a large input at blank
+	// lines and process the pieces on up to Workers goroutines. The
+	// output, diagnostics and statistics are the same as sequential
+	// processing gives. Inputs under 128 KB are always
+	// processed on the calling goroutine.
+	Workers int
+}
+
+// softLines reports whether line breaks inside a paragraph are soft
+func (o Options) softLines() bool {
+	return o.ParagraphBoundaries || o.UnwrapParagraphs
+}
@@ -0,0 +1,138 @@
+package fsm
+
+import (
+	"go-reloaded/lexer"
+	"strings"
+	"sync"
+)
+
+// A blank line is a hard boundary for everything the processor carries
+// from word to word: the word buffer is flushed, open quotes are resolved,
+// a/an and every other lookahead stop at its first line break, and the
+// output is back at the start of a line. Cut right after the last line
+// break of a run of blank lines, the input falls into pieces a fresh
+// Processor turns into exactly the text the sequential run writes for
+// them. Only the line endings are decided once, from the whole input.
+
+// minChunkBytes is the smallest piece of input worth a goroutine
+const minChunkBytes = 64 << 10
+
+// chunkSize returns the size to aim for when cutting an input of n bytes
+// for workers goroutines, a few pieces each to even out their load. It is
+// n itself when the input is too small to be worth cutting.
+func chunkSize(n, workers int) int {
+	if n < 2*minChunkBytes {
+		return n
+	}
+	return max(minChunkBytes, n/(workers*4))
+}
+
+// splitParagraphs cuts input after runs of blank lines into pieces of at
+// least size bytes, the last one excepted. The lexer finds the runs, so a
+// line break inside a token, as in "(up\n\n)", never counts as one.
+func splitParagraphs(input string, size int) []string {
+	if len(input) <= size {
+		return []string{input}
+	}
+	var pieces []string
+	start, newlines, runEnd := 0, 0, 0
+	l := lexer.New(input)
+	for {
+		token, ok := l.Next()
+		if !ok {
+			break
+		}
+		switch token.Kind {
+		case lexer.Newline:
+			newlines++
+			runEnd = token.End()
+		case lexer.Whitespace:
+			// Blanks inside the run, or the indentation after it
+		default:
+			// A piece can't start with U+FEFF: it would be read as a BOM
+			if newlines >= 2 && runEnd-start >= size && !strings.HasPrefix(input[runEnd:], utf8BOM) {
+				pieces = append(pieces, input[start:runEnd])
+				start = runEnd
+			}
+			newlines = 0
+		}
+	}
+	return append(pieces, input[start:])
+}
+
+// processedChunk is a piece of the input after processing
+type processedChunk struct {
+	Result
+	prepared int // Length of the piece as tokenized
+}
+
+// processChunks processes the pieces of input on p.opts.Workers
+// goroutines, each with a Processor of its own, and puts the results
+// together as one sequential run would have written them
+func (p *Processor) processChunks(input string, pieces []string, eol, wrapBreak string) string {
+	opts := p.opts
+	opts.Workers = 0
+	chunks := make([]processedChunk, len(pieces))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(p.opts.Workers, len(pieces)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := NewProcessorWithOptions(opts)
+			for i := range jobs {
+				text := worker.process(pieces[i], eol, wrapBreak)
+				chunks[i] = processedChunk{Result: worker.result(text), prepared: worker.prepared}
+			}
+		}()
+	}
+	for i := range pieces {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	p.output.reset(0, "")
+	p.diagnostics, p.explanations, p.applied = nil, nil, nil
+	p.stats = Stats{}
+	var invalid []Diagnostic // Reported while preparing, before anything else
+	var out strings.Builder
+	out.Grow(len(input))
+	shift := 0  // Offset of the chunk in the input as a sequential run tokenizes it
+	source := 0 // Offset of the chunk in the input as read
+	for i, c := range chunks {
+		out.WriteString(c.Text)
+		for _, d := range c.Diagnostics {
+			if d.Code == "invalid-utf8" {
+				// Found before normalization, in the input as read
+				d.Offset += source
+				invalid = append(invalid, d)
+				continue
+			}
+			d.Offset += shift
+			p.diagnostics = append(p.diagnostics, d)
+		}
+		for _, e := range c.Explanations {
+			e.Offset += shift
+			p.explanations = append(p.explanations, e)
+		}
+		for _, m := range c.Modifiers {
+			m.Offset += shift
+			m.End += shift
+			targets := m.Targets
+			m.Targets = nil
+			for _, t := range targets {
+				m.Targets = append(m.Targets, Span{Offset: t.Offset + shift, End: t.End + shift})
+			}
+			p.applied = append(p.applied, m)
+		}
+		p.stats.Add(c.Stats)
+		shift += c.prepared
+		source += len(pieces[i])
+	}
+	// A sequential run lists them first, which decides ties when sorting
+	p.diagnostics = append(invalid, p.diagnostics...)
+	LocateDiagnostics(input, p.diagnostics)
+	LocateExplanations(input, p.explanations)
+	return out.String()
+}
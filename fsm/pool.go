@@ -0,0 +1,78 @@
+package fsm
+
+import "sync"
+
+// A Processor keeps the state of the run in progress in its own fields,
+// so one instance serves one call at a time. Process and Pool are the
+// ways to share the work across goroutines.
+
+// Result is everything one run of the processor produced. It holds no
+// reference to the processor that made it.
+type Result struct {
+	Text         string
+	Diagnostics  []Diagnostic
+	Explanations []Explanation
+	Stats        Stats
+	Modifiers    []AppliedModifier
+}
+
+// Process runs a new Processor with opts over input. Every call works on
+// state of its own, so it is safe for concurrent use.
+func Process(input string, opts Options) Result {
+	return NewProcessorWithOptions(opts).run(input)
+}
+
+// run processes input and collects the result
+func (p *Processor) run(input string) Result {
+	return p.result(p.Process(input))
+}
+
+// result collects what the last run produced, text being its output
+func (p *Processor) result(text string) Result {
+	return Result{
+		Text:         text,
+		Diagnostics:  p.diagnostics,
+		Explanations: p.explanations,
+		Stats:        p.stats,
+		Modifiers:    p.applied,
+	}
+}
+
+// Pool reuses Processors sharing one set of options, so a busy caller
+// doesn't allocate a Processor and its buffers for every input. It is
+// safe for concurrent use.
+type Pool struct {
+	opts Options
+	pool sync.Pool
+}
+
+// NewPool returns a Pool of Processors using opts
+func NewPool(opts Options) *Pool {
+	p := &Pool{opts: opts}
+	p.pool.New = func() any { return NewProcessorWithOptions(opts) }
+	return p
+}
+
+// Options returns the options of the pooled Processors
+func (p *Pool) Options() Options {
+	return p.opts
+}
+
+// Process runs a pooled Processor over input
+func (p *Pool) Process(input string) Result {
+	processor := p.Get()
+	defer p.Put(processor)
+	return processor.run(input)
+}
+
+// Get takes a Processor out of the pool, for callers that drive it
+// themselves, such as formats.Process. Hand it back with Put once its
+// results are read.
+func (p *Pool) Get() *Processor {
+	return p.pool.Get().(*Processor)
+}
+
+// Put returns a Processor taken with Get
+func (p *Pool) Put(processor *Processor) {
+	p.pool.Put(processor)
+}
@@ -0,0 +1,95 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization selects the Unicode normalization form applied to the input
+type Normalization int
+
+const (
+	// NormalizeNone leaves the text exactly as it is
+	NormalizeNone Normalization = iota
+	// NormalizeNFC composes characters: e + U+0301 becomes é
+	NormalizeNFC
+	// NormalizeNFD decomposes characters: é becomes e + U+0301
+	NormalizeNFD
+)
+
+var normalizationNames = map[Normalization]string{
+	NormalizeNone: "none",
+	NormalizeNFC:  "nfc",
+	NormalizeNFD:  "nfd",
+}
+
+func (n Normalization) String() string {
+	if name, ok := normalizationNames[n]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseNormalization maps a name such as "nfc" to its Normalization
+func ParseNormalization(name string) (Normalization, error) {
+	for n, s := range normalizationNames {
+		if strings.EqualFold(name, s) {
+			return n, nil
+		}
+	}
+	return NormalizeNone, fmt.Errorf("unknown normalization %q (want none, nfc or nfd)", name)
+}
+
+// utf8BOM is the byte order mark some editors put at the start of UTF-8 files
+const utf8BOM = "\uFEFF"
+
+// prepare runs before tokenizing. It takes the byte order mark off the
+// input so it can't glue itself to the first word, reports invalid UTF-8,
+// and applies the configured normalization. It returns the text to
+// tokenize and the BOM to write back in front of the output.
+func (p *Processor) prepare(input string) (text, bom string) {
+	text = input
+	if strings.HasPrefix(text, utf8BOM) {
+		text = text[len(utf8BOM):]
+		p.offsetShift = len(utf8BOM)
+		if !p.opts.StripBOM {
+			bom = utf8BOM
+		}
+	}
+
+	if !utf8.ValidString(text) {
+		p.reportInvalidUTF8(text)
+	}
+
+	switch p.opts.Normalization {
+	case NormalizeNFC:
+		text = norm.NFC.String(text)
+	case NormalizeNFD:
+		text = norm.NFD.String(text)
+	}
+	return text, bom
+}
+
+// reportInvalidUTF8 adds one diagnostic per run of invalid bytes.
+// The bytes themselves are kept: the lexer passes them through untouched.
+func (p *Processor) reportInvalidUTF8(text string) {
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r != utf8.RuneError || size != 1 {
+			i += size
+			continue
+		}
+		start := i
+		for i < len(text) {
+			r, size = utf8.DecodeRuneInString(text[i:])
+			if r != utf8.RuneError || size != 1 {
+				break
+			}
+			i++
+		}
+		p.report(start, "invalid-utf8", "invalid UTF-8: % x kept as-is", []byte(text[start:i]))
+	}
+}
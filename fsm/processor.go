@@ -1,186 +1,631 @@
 package fsm
 
 import (
-	"fmt"
 	"go-reloaded/formatters"
+	"go-reloaded/lexer"
 	"go-reloaded/transforms"
-	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// entryKind tells words apart from the markers that delimit a closed quote
+type entryKind uint8
+
+const (
+	wordEntry  entryKind = iota
+	quoteStart           // Opens a quote, mark holds its opening quote mark
+	quoteEnd             // Closes a quote, mark holds its closing quote mark
+)
+
+// entry is a word waiting in a buffer, with the whitespace to emit before it.
+// Brackets and other marks that hug the word are kept apart from its text
+// in pre and post, so modifiers and a/an only ever see the word itself.
+type entry struct {
+	kind  entryKind
+	text  string
+	space string
+	pre   string
+	post  string
+	mark  string
+	// Input span of text, for words read from the input. Entries the
+	// processor made up, such as punctuation inside a quote, have none.
+	offset, end int
+}
+
+func (e entry) String() string {
+	return e.pre + e.text + e.post
+}
+
+// extend stretches the input span of e to the end of token. An entry
+// with no span of its own, such as "," in a quote, starts at token.
+func (e *entry) extend(token lexer.Token) {
+	if e.end == 0 {
+		e.offset = token.Offset
+	}
+	e.end = token.End()
+}
+
+// Processor rewrites text one run at a time. It isn't safe for
+// concurrent use: see Process and Pool.
 type Processor struct {
+	//output options
+	opts Options
 	//It holds the input data
-	tokens []string
+	lexer *lexer.Lexer
+	//lookahead window over the input
+	tokens []lexer.Token
 	//tracks its progress
 	pos int
 	//builds the result
-	output strings.Builder
+	output emitter
 	//temp buffer for words
-	wordBuffer []string
-	//flags T if ' found
-	inQuote bool
-	//temp buffer for quoted words
-	quoteWords           []string
-	lastProcessedWasWord bool // Tracks if the last token processed was a word (not punctuation, modifier, or quote)
-	isDoubleQuote        bool // Tracks if current quote is double quote
+	wordBuffer []entry
+	//open quotes, innermost last
+	quotes               []quoteLevel
+	lastProcessedWasWord bool   // Tracks if the last token processed was a word (not punctuation, modifier, or quote)
+	lastTextEnd          int    // Input offset just past the last word or opaque token added to a buffer
+	marksEnd             int    // Input offset just past the last punctuation or quote mark, for markup touching it
+	pendingSpace         string // Whitespace seen since the last non-blank token
+	lineBreak            string // Soft line break not yet attached to a word
+	prefix               string // Opening marks waiting for the next word: ( [ ¿
+	prefixSpace          string // Whitespace to emit before the opening marks
+	prefixGlued          bool   // The opening marks touched the previous word: f(x)
+	sentenceOpeners      string // Opening marks seen since the sentence started, once each: ¿ ¡
+	eol                  string // Line ending forced on every line, "" keeps the input's
+	diagnostics          []Diagnostic
+	explanations         []Explanation
+	stats                Stats
+	applied              []AppliedModifier
+	targets              map[int][]targetRef // Targets of applied modifiers by input offset
+	offsetShift          int                 // Bytes removed from the front of the input before tokenizing
+	prepared             int                 // Length of the input as tokenized, offsetShift included
+	prevKind             lexer.Kind          // Kind of the last non-blank token consumed
+	texts, posts         grower              // Grow the text and post of the word tokens are glued onto
+	prefixes             grower              // Grows prefix
 }
 
 func NewProcessor() *Processor {
+	return NewProcessorWithOptions(Options{})
+}
+
+// NewProcessorWithOptions returns a Processor using opts for every call to Process
+func NewProcessorWithOptions(opts Options) *Processor {
 	return &Processor{
-		wordBuffer: make([]string, 0),
-		quoteWords: make([]string, 0),
+		opts:       opts,
+		wordBuffer: make([]entry, 0),
 	}
 }
 
+// Options returns the options the Processor was created with
+func (p *Processor) Options() Options {
+	return p.opts
+}
+
 func (p *Processor) Process(input string) string {
+	eol, wrapBreak := p.opts.lineBreaks(input)
+	if p.opts.Workers > 1 {
+		if pieces := splitParagraphs(input, chunkSize(len(input), p.opts.Workers)); len(pieces) > 1 {
+			return p.processChunks(input, pieces, eol, wrapBreak)
+		}
+	}
+	return p.process(input, eol, wrapBreak)
+}
+
+// lineBreaks decides from the whole input the line ending forced on every
+// line, "" to keep the input's, and the one wrapping writes
+func (o Options) lineBreaks(input string) (eol, wrapBreak string) {
+	if o.LineEndings == LineEndingAuto || (o.LineEndings == LineEndingPreserve && o.WrapWidth > 0) {
+		wrapBreak = DetectLineEnding(input).sequence()
+	}
+	if o.LineEndings != LineEndingPreserve {
+		if o.LineEndings != LineEndingAuto {
+			wrapBreak = o.LineEndings.sequence()
+		}
+		eol = wrapBreak
+	}
+	return eol, wrapBreak
+}
+
+// process runs the state machine over input on this goroutine, with the
+// line breaks decided by lineBreaks
+func (p *Processor) process(input, eol, wrapBreak string) string {
 	// RESET STATE - IMPORTANT!
-	p.tokens = nil
+	p.tokens = p.tokens[:0]
 	p.pos = 0
-	p.output.Reset() // Clear previous output
-	p.wordBuffer = make([]string, 0)
-	p.inQuote = false
-	p.quoteWords = make([]string, 0)
+	p.eol = eol
+	p.output.reset(p.opts.WrapWidth, wrapBreak) // Clear previous output
+	p.wordBuffer = make([]entry, 0)
+	p.quotes = p.quotes[:0]
 	p.lastProcessedWasWord = false // Reset state for new input
+	p.lastTextEnd = -1
+	p.marksEnd = -1
+	p.pendingSpace = ""
+	p.lineBreak = ""
+	p.prefix = ""
+	p.prefixGlued = false
+	p.sentenceOpeners = ""
+	p.prevKind = lexer.Newline // The input starts at the start of a line
+	p.diagnostics = nil
+	p.explanations = nil
+	p.stats = Stats{}
+	p.applied = nil
+	p.targets = nil
+	p.offsetShift = 0
+	p.texts.reset()
+	p.posts.reset()
+	p.prefixes.reset()
+
+	// Take off the BOM, check the encoding and normalize
+	text, bom := p.prepare(input)
+	p.output.prefix(bom)
+	p.prepared = p.offsetShift + len(text)
 
 	// Now tokenize and process
-	p.tokens = tokenize(input)
+	p.lexer = lexer.New(text)
 
-	for p.pos < len(p.tokens) {
-		token := p.tokens[p.pos]
+	for {
+		token, ok := p.peek(0)
+		if !ok {
+			break
+		}
 
 		// Handle newlines first (before trimming)
-		if token == "\n" {
-			p.flushBuffer()
-			// Trim trailing space before newline
-			if p.output.Len() > 0 && p.output.String()[p.output.Len()-1] == ' ' {
-				currentOutput := p.output.String()
-				p.output.Reset()
-				p.output.WriteString(strings.TrimRight(currentOutput, " "))
-			}
-			p.output.WriteString("\n")
-			p.pos++
-			p.lastProcessedWasWord = false
+		if token.Kind == lexer.Newline {
+			p.handleNewline()
+			continue // handleNewline advances pos
+		}
+
+		// Blanks only separate tokens
+		if isSkipped(token) {
+			p.advance()
 			continue
 		}
 
-		trimmedToken := strings.TrimSpace(token)
+		trimmedToken := strings.TrimSpace(token.Text)
 
-		// Skip empty tokens after trimming
-		if trimmedToken == "" {
-			p.pos++
+		// An apostrophe is part of a word: 'tis, the students' books
+		if token.Kind == lexer.Quote && p.isApostrophe(token) {
+			p.appendWord(token, token.Text)
+			p.lastProcessedWasWord = true
+			p.advance()
 			continue
 		}
 
 		// Handle quotes (both single and double)
-		if trimmedToken == "'" || trimmedToken == "\"" {
-			p.handleQuote()
-			p.pos++
+		if token.Kind == lexer.Quote {
+			p.handleQuote(token)
+			p.advance()
 			continue
 		}
 
 		// Check for modifiers - apply if buffer has words
-		if isModifier(trimmedToken) {
-			targetBuffer := &p.wordBuffer
-			if p.inQuote {
-				targetBuffer = &p.quoteWords
-			}
-			// Apply modifier if buffer has words (allow chaining)
-			if len(*targetBuffer) > 0 {
-				p.handleModifier(trimmedToken)
-				p.pos++
+		if token.Kind == lexer.Modifier && isModifier(trimmedToken) && len(*p.activeBuffer()) > 0 {
+			if modType, count, err := parseModifier(trimmedToken); err == nil {
+				// Apply modifier if buffer has words (allow chaining)
+				p.handleModifier(token, trimmedToken, modType, count)
+				// An opaque token touching the modifier still belongs to the word: "1F (hex)%"
+				p.lastTextEnd = token.End()
+				p.advance()
 				// Keep lastProcessedWasWord = true to allow next modifier to chain
 				p.lastProcessedWasWord = true
 				continue
 			}
-			// Otherwise, treat it as regular text (fall through)
+		}
+		// Otherwise, treat it as regular text (fall through)
+		if token.Kind == lexer.Modifier {
+			p.checkModifier(token, trimmedToken)
 		}
 
 		// Check for punctuation
-		if isPunctuation(trimmedToken) {
-			p.handlePunctuation()
+		if token.Kind == lexer.Punctuation {
+			if token.Text == "(" {
+				p.checkModifierShape(token)
+			}
+			p.handlePunctuation(token)
 			continue // handlePunctuation advances pos
 		}
 
-		// Regular word
-		if p.inQuote {
-			p.quoteWords = append(p.quoteWords, trimmedToken)
-		} else {
-			p.wordBuffer = append(p.wordBuffer, trimmedToken) // Add to buffer
-		}
+		// Regular word or opaque token ($, %, #, @, emoji...)
+		p.appendWord(token, trimmedToken)
 		p.lastProcessedWasWord = true // A word was just processed
 
-		p.pos++
+		p.advance()
 	}
 
 	// Flush remaining words
+	p.flushPrefix()
+	p.recoverQuotes(inputEnd)
 	p.flushBuffer()
+	p.flushLineBreak()
+
+	LocateDiagnostics(input, p.diagnostics)
+	LocateExplanations(input, p.explanations)
 
-	// Trim only leading/trailing spaces, preserve internal newlines
-	result := p.output.String()
-	result = strings.TrimRight(result, " \t")
-	result = strings.TrimLeft(result, " \t")
-	return result
+	// Pending spaces are never written at the edges, so there is nothing to trim
+	return p.output.String()
 }
 
-func (p *Processor) handleModifier(modifier string) {
-	targetBuffer := &p.wordBuffer
-	if p.inQuote {
-		targetBuffer = &p.quoteWords
+// appendWord adds a word or opaque token to the active buffer.
+// Opaque tokens carry no spacing rules of their own, so a token that
+// touched the previous word in the input is glued onto it: "$5", "10%",
+// "#tag" and "(50%)" stay single words, while "5 & 10" keeps its spaces.
+// Opaque runs at the edges of a word go in pre and post, as brackets do,
+// so modifiers and a/an only see the word: "$1F (hex)", "a *honest* man".
+// A word glued after an opaque run joins it: "AT&T" stays one word.
+func (p *Processor) appendWord(token lexer.Token, text string) {
+	targetBuffer := p.activeBuffer()
+
+	last := len(*targetBuffer) - 1
+	glue := token.Offset == p.lastTextEnd && last >= 0
+	if isMarkup(token) && p.appendMarkup(token, glue) {
+		return
+	}
+	switch {
+	case p.prefixGlued:
+		// f(x): the bracket touched the previous word, keep all of it together
+		w := &(*targetBuffer)[last]
+		w.post = p.posts.join(w.post, p.prefix+text)
+		p.prefix = ""
+		p.prefixGlued = false
+	case glue && token.Kind != lexer.Other && (*targetBuffer)[last].kind == wordEntry && isOpaque((*targetBuffer)[last].post):
+		// AT&T: the run was inside the word
+		w := &(*targetBuffer)[last]
+		w.text = p.texts.join(w.text, w.post+text)
+		w.post = ""
+		w.extend(token)
+	case glue && token.Kind != lexer.Other && (*targetBuffer)[last].kind == wordEntry && (*targetBuffer)[last].post == "" && isOpaque((*targetBuffer)[last].text):
+		// $5, @bob: the run so far leads the word
+		w := &(*targetBuffer)[last]
+		w.pre += w.text
+		w.text = text
+		w.offset, w.end = token.Offset, token.End()
+	case glue && ((*targetBuffer)[last].post != "" || (*targetBuffer)[last].kind != wordEntry ||
+		token.Kind == lexer.Other && !isOpaque((*targetBuffer)[last].text)):
+		w := &(*targetBuffer)[last]
+		w.post = p.posts.join(w.post, text)
+	case glue:
+		w := &(*targetBuffer)[last]
+		w.text = p.texts.join(w.text, text)
+		w.extend(token)
+	default:
+		space := p.gap(token)
+		if p.prefix != "" {
+			space = p.prefixSpace
+		}
+		*targetBuffer = append(*targetBuffer, entry{text: text, space: space, pre: p.prefix, offset: token.Offset, end: token.End()})
+		p.prefix = ""
 	}
+	p.lastTextEnd = token.End()
+}
 
-	modType, count := parseModifier(modifier)
+// isOpaque reports whether s is a non-empty run of opaque tokens: $, @,
+// *, # or emoji, with no word, punctuation or quote in it
+func isOpaque(s string) bool {
+	l := lexer.New(s)
+	for n := 0; ; n++ {
+		token, ok := l.Next()
+		if !ok {
+			return n > 0
+		}
+		if token.Kind != lexer.Other || isMarkup(token) {
+			return false
+		}
+	}
+}
+
+// Callers that cut markup out of the text, such as an HTML tag, put a
+// placeholder rune in its place, from the Supplementary Private Use Areas
+const (
+	FirstPlaceholder rune = 0xF0000
+	LastPlaceholder  rune = 0x10FFFD
+)
+
+// IsPlaceholder reports whether r is in the range the processor reads as
+// markup
+func IsPlaceholder(r rune) bool {
+	return r >= FirstPlaceholder && r <= LastPlaceholder
+}
 
-	switch modType {
-	case "hex":
-		idx := len(*targetBuffer) - 1
-		if idx >= 0 {
-			(*targetBuffer)[idx] = transforms.HexToDec((*targetBuffer)[idx])
+// isMarkup reports whether token stands for markup: a run of
+// placeholders. Markup touching a word is kept in its pre or post,
+// so modifiers and a/an see the word through it: "a <em>apple</em>".
+func isMarkup(token lexer.Token) bool {
+	if token.Kind != lexer.Other {
+		return false
+	}
+	for _, r := range token.Text {
+		if !IsPlaceholder(r) {
+			return false
 		}
-	case "bin":
-		idx := len(*targetBuffer) - 1
-		if idx >= 0 {
-			(*targetBuffer)[idx] = transforms.BinToDec((*targetBuffer)[idx])
+	}
+	return true
+}
+
+// appendMarkup sticks markup to the word it touches, after the previous
+// word or before the next one, and reports whether it found one
+func (p *Processor) appendMarkup(token lexer.Token, glue bool) bool {
+	targetBuffer := p.activeBuffer()
+	if glue && p.prefix == "" {
+		w := &(*targetBuffer)[len(*targetBuffer)-1]
+		w.post = p.posts.join(w.post, token.Text)
+		p.lastTextEnd = token.End()
+		return true
+	}
+	if token.Offset == p.marksEnd && p.prefix == "" {
+		// Markup closing after a mark, as in "<b>Hi.</b> there"
+		if last := len(*targetBuffer) - 1; last >= 0 {
+			w := &(*targetBuffer)[last]
+			w.post = p.posts.join(w.post, token.Text)
+		} else {
+			p.output.hug(token.Text)
+		}
+		p.marksEnd = token.End()
+		return true
+	}
+	if next, ok := p.peek(1); ok && next.Offset == token.End() && next.Kind != lexer.Whitespace && next.Kind != lexer.Newline {
+		if p.prefix == "" {
+			p.prefixGlued = glue
+			p.prefixSpace = p.gap(token)
+		}
+		p.prefix = p.prefixes.join(p.prefix, token.Text)
+		return true
+	}
+	return false
+}
+
+// handleNewline consumes a run of line breaks, blank lines included.
+// A single line break inside a paragraph is soft when paragraphs are
+// enabled: it is kept with the next word and doesn't flush the buffer.
+// Everything else (blank lines, the end of the input, or any line break
+// in the default mode) is a hard boundary.
+func (p *Processor) handleNewline() {
+	// Measure the run without consuming it, so a/an lookahead during the
+	// flush below still stops at this newline
+	var endings []string
+	n := 0
+	indent := ""
+	for {
+		token, ok := p.peek(n)
+		if !ok || (token.Kind != lexer.Newline && token.Kind != lexer.Whitespace) {
+			break
+		}
+		if token.Kind == lexer.Newline {
+			endings = append(endings, p.lineEnding(token.Text))
+			indent = ""
+		} else {
+			indent = token.Text
+		}
+		n++
+	}
+	_, more := p.peek(n)
+
+	if len(endings) == 1 && more && p.opts.softLines() {
+		p.recoverQuotes(softLineBreak)
+		prevKind := p.prevKind
+		p.skip(n)
+		if p.opts.UnwrapParagraphs {
+			// The wrapped line just continues the paragraph
+			p.prevKind = prevKind
+			p.pendingSpace = " "
+			return
+		}
+		p.lineBreak = endings[0]
+		if p.opts.PreserveWhitespace {
+			p.lineBreak += indent
+		}
+		return
+	}
+
+	p.flushPrefix()
+	if len(endings) > 1 {
+		p.recoverQuotes(paragraphEnd)
+	} else {
+		p.recoverQuotes(lineEnd)
+	}
+	p.flushBuffer()
+	p.flushLineBreak()
+	p.sentenceOpeners = ""
+	p.skip(n)
+	if len(endings) > 2 && p.opts.CollapseBlankLines {
+		endings = endings[:2]
+	}
+	for _, ending := range endings {
+		// The emitter never writes a pending space before a newline
+		p.output.newline(ending)
+	}
+	p.lastProcessedWasWord = false
+}
+
+// flushLineBreak writes a soft line break that no word has claimed
+func (p *Processor) flushLineBreak() {
+	if p.lineBreak != "" {
+		p.output.newline(strings.TrimRight(p.lineBreak, " \t"))
+		p.lineBreak = ""
+	}
+}
+
+// lineEnding returns the line break to write for one found in the input
+func (p *Processor) lineEnding(original string) string {
+	if p.eol != "" {
+		return p.eol
+	}
+	return original
+}
+
+func (p *Processor) handleModifier(token lexer.Token, modifier, modType string, count int) {
+	targetBuffer := p.activeBuffer()
+
+	if count == wholeQuote {
+		p.applyToQuote(token, modifier, modType, *targetBuffer)
+		return
+	}
+
+	m, _ := LookupModifier(modType)
+	if !m.Counted {
+		// hex and bin convert the last word only
+		if idx := len(*targetBuffer) - 1; idx >= 0 {
+			if applyTo(m.Apply, &(*targetBuffer)[idx]) {
+				p.countModifier(modType)
+			}
+			p.recordModifier(token, modType, (*targetBuffer)[idx:])
 		}
-	case "up":
-		p.applyCase(transforms.ToUpper, count, targetBuffer)
-	case "low":
-		p.applyCase(transforms.ToLower, count, targetBuffer)
-	case "cap":
-		p.applyCase(transforms.Capitalize, count, targetBuffer)
+		return
 	}
+	words, changed := p.applyCase(m.Apply, count, targetBuffer)
+	if changed {
+		p.countModifier(modType)
+	}
+	p.recordModifier(token, modType, words)
+}
+
+// applyTo runs fn over the word e and reports whether its text changed
+func applyTo(fn func(string) string, e *entry) bool {
+	before := e.text
+	e.text = fn(before)
+	return e.text != before
 }
 
-func (p *Processor) applyCase(fn func(string) string, count int, buffer *[]string) {
+// applyCase runs fn over the last count words of buffer and returns them,
+// last first, and whether any of them changed
+func (p *Processor) applyCase(fn func(string) string, count int, buffer *[]entry) ([]entry, bool) {
 	if count == 0 {
 		count = 1
 	}
 
 	// Count actual words (skip quote markers)
-	wordCount := 0
-	for i := len(*buffer) - 1; i >= 0 && wordCount < count; i-- {
-		if (*buffer)[i] != "'QUOTE_START'" && (*buffer)[i] != "'QUOTE_END'" && (*buffer)[i] != "\"QUOTE_START\"" && (*buffer)[i] != "\"QUOTE_END\"" {
-			(*buffer)[i] = fn((*buffer)[i])
-			wordCount++
+	var words []entry
+	changed := false
+	for i := len(*buffer) - 1; i >= 0 && len(words) < count; i-- {
+		if (*buffer)[i].kind == wordEntry {
+			changed = applyTo(fn, &(*buffer)[i]) || changed
+			words = append(words, (*buffer)[i])
 		}
 	}
+	return words, changed
 }
 
-func (p *Processor) handlePunctuation() {
-	// Collect consecutive punctuation
-	group := ""
-	for p.pos < len(p.tokens) && isPunctuation(p.tokens[p.pos]) {
-		group += p.tokens[p.pos]
-		p.pos++
+// applyToQuote runs a modifier over every word of the last quote in
+// buffer, nested quotes included, however many words it holds
+func (p *Processor) applyToQuote(token lexer.Token, modifier, modType string, buffer []entry) {
+	end := len(buffer) - 1
+	for end >= 0 && buffer[end].kind != quoteEnd {
+		end--
 	}
+	if end < 0 {
+		p.report(token.Offset, "modifier-no-quote", "%s has no quote to apply to", modifier)
+		return
+	}
+	fn := modifierFunc(modType)
+	var words []entry
+	changed := false
+	depth := 0
+	for i := end; i >= 0; i-- {
+		switch buffer[i].kind {
+		case quoteEnd:
+			depth++
+		case quoteStart:
+			depth--
+		default:
+			changed = applyTo(fn, &buffer[i]) || changed
+			words = append(words, buffer[i])
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if changed {
+		p.countModifier(modType)
+	}
+	p.recordModifier(token, modType, words)
+}
+
+// modifierFunc returns the transformation a modifier applies to each word
+func modifierFunc(modType string) func(string) string {
+	if m, ok := LookupModifier(modType); ok {
+		return m.Apply
+	}
+	return func(s string) string { return s }
+}
 
-	if p.inQuote {
+func (p *Processor) handlePunctuation(token lexer.Token) {
+	rule := punctuationRule(token)
+	switch {
+	case rule.Spacing == formatters.SpaceBoth && p.joinsWords(token):
+		// 10–20, well—known: the dash is part of the word, as a hyphen is
+		w := &(*p.activeBuffer())[len(*p.activeBuffer())-1]
+		w.text = p.texts.join(w.text, token.Text)
+		w.extend(token)
+		p.lastTextEnd = token.End()
+		p.advance()
+		return
+	case rule.Spacing == formatters.AttachRight:
+		p.handleOpeningMark(token)
+		return
+	case rule.Spacing == formatters.AttachLeft && !rule.Boundary:
+		p.handleClosingMark(token)
+		return
+	}
+
+	// Blanks before the group, unless it starts the line
+	spaced := p.pendingSpace
+	if p.prevKind == lexer.Newline {
+		spaced = ""
+	}
+	space := p.gap(token)
+	p.flushPrefix()
+
+	if rule.Spacing == formatters.SpaceBoth {
+		p.advance()
+		if p.inQuote() {
+			buffer := p.activeBuffer()
+			*buffer = append(*buffer, entry{text: token.Text, space: space})
+			return
+		}
+		// Dashes stand alone, spaced on both sides
+		p.flushBuffer()
+		p.lastProcessedWasWord = false
+		p.writeSpace(space)
+		p.output.text(token.Text)
+		p.output.space()
+		return
+	}
+
+	// Collect consecutive punctuation, blanks between marks don't break the group
+	var sb strings.Builder
+	for {
+		token, ok := p.peek(0)
+		if !ok {
+			break
+		}
+		if token.Kind == lexer.Punctuation {
+			next := punctuationRule(token)
+			if next.Spacing != formatters.AttachLeft || !next.Boundary {
+				break
+			}
+			sb.WriteString(token.Text)
+			p.marksEnd = token.End()
+		} else if !isSkipped(token) {
+			break
+		}
+		p.advance()
+	}
+	group := p.formatPunctuation(token.Offset, sb.String())
+	p.checkInvertedMarks(token.Offset, group)
+	before := p.opts.Profile.SpaceBefore(group)
+	if group != sb.String() || spaced != before || p.touchesText(p.marksEnd) {
+		p.stats.Punctuation++
+	}
+
+	if p.inQuote() {
 		// If inside a quote, attach punctuation to the last word.
-		if len(p.quoteWords) > 0 {
-			lastIndex := len(p.quoteWords) - 1
-			p.quoteWords[lastIndex] += group
+		buffer := p.activeBuffer()
+		if lastIndex := len(*buffer) - 1; lastIndex >= 0 {
+			w := &(*buffer)[lastIndex]
+			w.post = p.posts.join(w.post, before+group)
 		} else {
-			p.quoteWords = append(p.quoteWords, group)
+			*buffer = append(*buffer, entry{text: group, space: space})
 		}
 		return
 	}
@@ -188,151 +633,298 @@ func (p *Processor) handlePunctuation() {
 	// Flush words before punctuation
 	p.flushBuffer()
 
-	// Trim trailing space from output before adding punctuation
-	if p.output.Len() > 0 && p.output.String()[p.output.Len()-1] == ' ' {
-		currentOutput := p.output.String()
-		p.output.Reset()
-		p.output.WriteString(currentOutput[:len(currentOutput)-1])
-	}
-
 	// Add punctuation (sticks to previous word, space after)
 	p.lastProcessedWasWord = false // Punctuation was just processed
-	p.output.WriteString(formatters.FormatPunctuation(group))
-	p.output.WriteString(" ") // Add space after punctuation
-}
-func (p *Processor) handleQuote() {
-	if !p.inQuote {
-		// Check if it's a double quote
-		if p.tokens[p.pos] == "\"" {
-			p.isDoubleQuote = true
-		} else {
-			p.isDoubleQuote = false
-		}
-		p.inQuote = true
-		p.lastProcessedWasWord = false
-		p.quoteWords = make([]string, 0)
+	if isLineBreak(space) {
+		// Punctuation opening a wrapped line stays on that line
+		p.output.gap(space)
+		p.output.text(group)
+	} else if p.output.atLineStart() {
+		p.output.attach(group)
 	} else {
-		// Apply a/an transformation inside quotes before formatting
-		for i := 0; i < len(p.quoteWords)-1; i++ {
-			p.quoteWords[i] = transforms.FixArticle(p.quoteWords[i], p.quoteWords[i+1])
-		}
+		p.output.attach(before + group)
+	}
+	p.output.space() // Add space after punctuation
+}
 
-		// Add quote marker with type
-		if p.isDoubleQuote {
-			p.wordBuffer = append(p.wordBuffer, "\"QUOTE_START\"")
-		} else {
-			p.wordBuffer = append(p.wordBuffer, "'QUOTE_START'")
-		}
-		p.wordBuffer = append(p.wordBuffer, p.quoteWords...)
-		if p.isDoubleQuote {
-			p.wordBuffer = append(p.wordBuffer, "\"QUOTE_END\"")
-		} else {
-			p.wordBuffer = append(p.wordBuffer, "'QUOTE_END'")
-		}
+// touchesText reports whether the next token is a word or a number
+// starting at offset, which the output will space away from the marks
+// before it
+func (p *Processor) touchesText(offset int) bool {
+	next, ok := p.peek(0)
+	return ok && next.Offset == offset && (next.Kind == lexer.Word || next.Kind == lexer.Number)
+}
 
-		p.inQuote = false
-		p.lastProcessedWasWord = true
-		p.quoteWords = make([]string, 0)
+// handleOpeningMark holds ( [ { ¿ ¡ until the next word arrives, so they
+// hug it: "( note )" becomes "(note)". They don't end a modifier's reach.
+func (p *Processor) handleOpeningMark(token lexer.Token) {
+	if p.prefix == "" {
+		p.prefixGlued = token.Offset == p.lastTextEnd && len(*p.activeBuffer()) > 0
+		p.prefixSpace = p.gap(token)
+	}
+	p.prefix = p.prefixes.join(p.prefix, token.Text+p.opts.Profile.SpaceAfter(token.Text))
+	if !strings.Contains(p.sentenceOpeners, token.Text) {
+		p.sentenceOpeners += token.Text
+	}
+	if next, ok := p.peek(1); ok && next.Kind == lexer.Whitespace {
+		p.stats.Punctuation++
+	}
+	p.advance()
+}
+
+// handleClosingMark sticks ) ] } to the previous word without flushing,
+// so "(hello world) (up, 2)" still reaches both words.
+func (p *Processor) handleClosingMark(token lexer.Token) {
+	if p.pendingSpace != "" && p.prevKind != lexer.Newline {
+		p.stats.Punctuation++
+	}
+	space := p.gap(token)
+	p.flushPrefix()
+	p.advance()
+	p.marksEnd = token.End()
+
+	targetBuffer := p.activeBuffer()
+	mark := p.opts.Profile.SpaceBefore(token.Text) + token.Text
+	if last := len(*targetBuffer) - 1; last >= 0 {
+		w := &(*targetBuffer)[last]
+		w.post = p.posts.join(w.post, mark)
+		return
+	}
+	if p.inQuote() {
+		*targetBuffer = append(*targetBuffer, entry{text: token.Text, space: space})
+		return
+	}
+	// Nothing buffered: the previous word is already in the output
+	p.output.attach(mark)
+}
+
+// flushPrefix turns opening marks that never met a word into a word of their own
+func (p *Processor) flushPrefix() {
+	if p.prefix == "" {
+		return
+	}
+	targetBuffer := p.activeBuffer()
+	if p.prefixGlued {
+		last := len(*targetBuffer) - 1
+		w := &(*targetBuffer)[last]
+		w.post = p.posts.join(w.post, p.prefix)
+	} else {
+		*targetBuffer = append(*targetBuffer, entry{text: p.prefix, space: p.prefixSpace})
+	}
+	p.prefix = ""
+	p.prefixGlued = false
+}
+
+// formatPunctuation normalizes a punctuation group found at offset
+// and explains every rule that changed it
+func (p *Processor) formatPunctuation(offset int, group string) string {
+	formatted, edits := formatters.FormatPunctuation(group, p.opts.Punctuation)
+	for _, edit := range edits {
+		p.explain(offset, edit.Rule, edit.Before, edit.After)
+	}
+	return formatted
+}
+
+// checkInvertedMarks reports a question or exclamation that ends without
+// the opening mark its language requires, like ? without ¿ in Spanish.
+// A sentence-ending group starts a new sentence.
+func (p *Processor) checkInvertedMarks(offset int, group string) {
+	for _, mark := range group {
+		if opening, ok := p.opts.Profile.InvertedMark(mark); ok && !strings.ContainsRune(p.sentenceOpeners, opening) {
+			p.report(offset, "missing-inverted-mark", "%q has no opening %q", mark, opening)
+			break
+		}
 	}
+	if strings.ContainsAny(group, ".!?…‽") {
+		p.sentenceOpeners = ""
+	}
+}
+
+// punctuationRule returns the formatters rule for a punctuation token
+func punctuationRule(token lexer.Token) formatters.PunctuationRule {
+	r, _ := utf8.DecodeRuneInString(token.Text)
+	rule, _ := formatters.LookupPunctuation(r)
+	return rule
 }
 
+// flushBuffer writes the buffered words. Quotes come back together from
+// their markers, innermost first: a closed inner quote becomes one word
+// of the quote around it.
 func (p *Processor) flushBuffer() {
-	inQuoteSection := false
-	quoteWords := []string{}
-	isDouble := false
+	// Words collected for each quote being rebuilt, innermost last
+	var open [][]entry
+	var starts []entry
 
 	for i := 0; i < len(p.wordBuffer); i++ {
-		word := p.wordBuffer[i]
+		current := p.wordBuffer[i]
+		word := current.text
 
 		// Handle quote markers
-		if word == "'QUOTE_START'" || word == "\"QUOTE_START\"" {
-			inQuoteSection = true
-			isDouble = (word == "\"QUOTE_START\"")
-			quoteWords = []string{}
+		if current.kind == quoteStart {
+			open = append(open, []entry{})
+			starts = append(starts, current)
 			continue
 		}
-		if word == "'QUOTE_END'" || word == "\"QUOTE_END\"" {
-			inQuoteSection = false
-			// Format and output the quote
-			var quoted string
-			if isDouble {
-				quoted = formatters.FormatDoubleQuote(quoteWords)
-			} else {
-				quoted = formatters.FormatQuote(quoteWords)
+		if current.kind == quoteEnd {
+			n := len(open) - 1
+			start := starts[n]
+			quoted := entry{
+				text:  p.formatQuote(start.mark, current.mark, p.quoteText(open[n])),
+				space: start.space,
+				pre:   start.pre,
+				post:  current.post,
 			}
-			if p.output.Len() > 0 && !endsWithSpace(p.output.String()) {
-				p.output.WriteString(" ")
+			open, starts = open[:n], starts[:n]
+			if n > 0 {
+				// A nested quote is one word of the quote around it
+				open[n-1] = append(open[n-1], quoted)
+				continue
 			}
-			p.output.WriteString(quoted)
-			quoteWords = []string{}
+			p.writeSpace(quoted.space)
+			p.output.text(quoted.String())
 			continue
 		}
 
-		if inQuoteSection {
+		if len(open) > 0 {
 			// Collect words inside quote
-			quoteWords = append(quoteWords, word)
+			open[len(open)-1] = append(open[len(open)-1], current)
+			continue
+		}
+
+		// Regular word outside quote: check a/an rule
+		nextWord := ""
+		if i < len(p.wordBuffer)-1 {
+			nextWord = nextWordText(p.wordBuffer, i+1)
 		} else {
-			// Regular word outside quote
-			nextWord := ""
-
-			// Check a/an rule
-			if i < len(p.wordBuffer)-1 {
-				nextWord = p.wordBuffer[i+1]
-				if (nextWord == "'QUOTE_START'" || nextWord == "\"QUOTE_START\"") && i+2 < len(p.wordBuffer) {
-					nextWord = p.wordBuffer[i+2]
+			// Peek ahead in tokens
+			for j := 0; ; j++ {
+				potentialNextToken, ok := p.peek(j)
+				if !ok {
+					break
 				}
-			} else {
-				// Peek ahead in tokens
-				for j := p.pos; j < len(p.tokens); j++ {
-					potentialNextToken := p.tokens[j]
-					if !isModifier(potentialNextToken) && !isPunctuation(potentialNextToken) && potentialNextToken != "'" && potentialNextToken != "\"" && potentialNextToken != "" {
-						nextWord = potentialNextToken
-						break
+				if potentialNextToken.Kind == lexer.Newline && p.opts.softLines() && !p.isParagraphBreak(j) {
+					continue
+				}
+				if isSkipped(potentialNextToken) || potentialNextToken.Kind == lexer.Punctuation || potentialNextToken.Kind == lexer.Quote {
+					continue
+				}
+				if potentialNextToken.Kind == lexer.Modifier && isModifier(potentialNextToken.Text) {
+					continue
+				}
+				if potentialNextToken.Kind == lexer.Other {
+					// An opaque run leading the word, as in "a *honest* man"
+					if after, ok := p.peek(j + 1); ok && after.Offset == potentialNextToken.End() && !isSkipped(after) {
+						continue
 					}
 				}
+				nextWord = potentialNextToken.Text
+				break
 			}
+		}
 
-			if nextWord != "" && nextWord != "'QUOTE_START'" && nextWord != "'QUOTE_END'" && nextWord != "\"QUOTE_START\"" && nextWord != "\"QUOTE_END\"" {
-				word = transforms.FixArticle(word, nextWord)
-			}
-
-			if p.output.Len() > 0 && !endsWithSpace(p.output.String()) {
-				p.output.WriteString(" ")
+		if nextWord != "" {
+			if fixed := transforms.FixArticle(word, nextWord); fixed != word {
+				word = fixed
+				p.stats.Articles++
 			}
-			p.output.WriteString(word)
 		}
+
+		current.text = word
+		p.recordResult(current)
+		p.writeSpace(current.space)
+		p.output.text(p.wordText(current))
 	}
 
-	p.wordBuffer = make([]string, 0)
+	p.wordBuffer = make([]entry, 0)
 }
 
-// tokenize function with contraction and hyphenated word support
-func tokenize(input string) []string {
-	// Updated regex to handle:
-	// - Contractions: "Let's", "It's", "don't"
-	// - Hyphenated words: "well-known", "state-of-the-art"
-	// - Slash compounds: "a/an", "and/or"
-	// - Modifiers: (hex), (up, 2)
-	// - Punctuation: . , ! ? : ;
-	// - Quotes: ' and "
-	// - Newlines: \n
-	// Use Unicode-aware word matching to support accented characters
-	re := regexp.MustCompile(`([\p{L}\p{N}_]+(?:[-'/→][\p{L}\p{N}_]+)*|[.,!?:;'"]|\(\s*\w+\s*(?:,\s*\d+\s*)?\)|\n)`)
-	matches := re.FindAllString(input, -1)
+// joinsWords reports whether the dash token touches a word on both sides
+func (p *Processor) joinsWords(token lexer.Token) bool {
+	buffer := *p.activeBuffer()
+	if len(buffer) == 0 || p.prefix != "" {
+		return false
+	}
+	last := buffer[len(buffer)-1]
+	if last.kind != wordEntry || last.post != "" || last.end != token.Offset || p.lastTextEnd != token.Offset {
+		return false
+	}
+	next, ok := p.peek(1)
+	return ok && next.Offset == token.End() && (next.Kind == lexer.Word || next.Kind == lexer.Number)
+}
 
-	var tokens []string
-	for _, match := range matches {
-		if match == "\n" {
-			tokens = append(tokens, "\n")
-		} else {
-			trimmedMatch := strings.TrimSpace(match)
-			if trimmedMatch != "" {
-				tokens = append(tokens, trimmedMatch)
+// gap returns the whitespace to keep before token.
+// Without PreserveWhitespace that is a single space, unless the words
+// were kept apart by typographic spaces such as a no-break space. With
+// it, the original whitespace is kept. Either way whitespace that touches
+// punctuation or a quote is normalized; indentation at the start of a
+// line is kept only with PreserveWhitespace.
+func (p *Processor) gap(token lexer.Token) string {
+	if p.lineBreak != "" {
+		lineBreak := p.lineBreak
+		p.lineBreak = ""
+		return lineBreak
+	}
+	if p.prevKind == lexer.Newline {
+		if p.opts.PreserveWhitespace {
+			return p.pendingSpace
+		}
+		return " "
+	}
+	if !p.opts.PreserveWhitespace && !isTypographicSpace(p.pendingSpace) {
+		return " "
+	}
+	if p.prevKind == lexer.Punctuation || p.prevKind == lexer.Quote ||
+		token.Kind == lexer.Punctuation || token.Kind == lexer.Quote {
+		return " "
+	}
+	return p.pendingSpace
+}
+
+// writeSpace requests the separator recorded for a buffered entry
+func (p *Processor) writeSpace(space string) {
+	if p.opts.PreserveWhitespace || isLineBreak(space) || isTypographicSpace(space) {
+		p.output.gap(space)
+	} else {
+		p.output.space()
+	}
+}
+
+// quoteText returns the words of a quote ready for the formatters.
+// The words are joined with their recorded spacing first (single spaces
+// unless whitespace is preserved or a line break or typographic space
+// was kept), so the
+// formatter only adds the quote marks.
+func (p *Processor) quoteText(words []entry) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	for i, w := range words {
+		if i > 0 {
+			if p.opts.PreserveWhitespace || isLineBreak(w.space) || isTypographicSpace(w.space) {
+				sb.WriteString(w.space)
+			} else {
+				sb.WriteString(" ")
 			}
 		}
+		p.recordResult(w)
+		sb.WriteString(p.wordText(w))
 	}
-	return tokens
+	return []string{sb.String()}
 }
+
+// isTypographicSpace reports whether ws is made only of space characters
+// other than the ASCII space, such as no-break or thin spaces, which the
+// text chose over a plain space on purpose
+func isTypographicSpace(ws string) bool {
+	for _, r := range ws {
+		if r == ' ' || !unicode.Is(unicode.Zs, r) {
+			return false
+		}
+	}
+	return ws != ""
+}
+
 func isModifier(token string) bool {
 	if !strings.HasPrefix(token, "(") || !strings.HasSuffix(token, ")") {
 		return false
@@ -345,11 +937,16 @@ func isModifier(token string) bool {
 		return false
 	}
 
-	base := strings.TrimSpace(parts[0])
-	return base == "hex" || base == "bin" || base == "up" || base == "low" || base == "cap"
+	_, ok := LookupModifier(strings.TrimSpace(parts[0]))
+	return ok
 }
 
-func parseModifier(token string) (string, int) {
+// wholeQuote is the count parseModifier returns for (up, q) and (up, quote)
+const wholeQuote = -1
+
+// parseModifier returns the type and count of a modifier the lexer read.
+// A count too large for an int is an error.
+func parseModifier(token string) (string, int, error) {
 	content := strings.TrimPrefix(strings.TrimSuffix(token, ")"), "(")
 	parts := strings.Split(content, ",")
 
@@ -358,35 +955,14 @@ func parseModifier(token string) (string, int) {
 
 	if len(parts) > 1 {
 		countStr := strings.TrimSpace(parts[1])
-		if countStr == "" {
-			// If the count string is empty, default to 1 and log a warning
-			fmt.Printf("Warning: Empty count in modifier '%s', defaulting to 1\n", token)
-			count = 1
-		} else {
-			var err error
-			count, err = strconv.Atoi(countStr)
-			if err != nil {
-				// Log the error if strconv.Atoi fails, then default to 1
-				fmt.Printf("Error parsing count '%s' in modifier '%s': %v, defaulting to 1\n", countStr, token, err)
-				count = 1
-			}
+		if countStr == "q" || countStr == "quote" {
+			return modType, wholeQuote, nil
+		}
+		var err error
+		if count, err = strconv.Atoi(countStr); err != nil {
+			return modType, 0, err
 		}
-	} else if modType != "hex" && modType != "bin" && len(parts) == 1 {
-		// This is for single-word modifiers like (up), (low), (cap)
-	}
-
-	return modType, count
-}
-
-// isPunctuation checks if a string consists entirely of punctuation characters.
-func isPunctuation(token string) bool {
-	if len(token) != 1 {
-		return false
 	}
-	ch := token[0]
-	return ch == '.' || ch == ',' || ch == '!' || ch == '?' || ch == ':' || ch == ';'
-}
 
-func endsWithSpace(s string) bool {
-	return len(s) > 0 && s[len(s)-1] == ' '
+	return modType, count, nil
 }
@@ -0,0 +1,369 @@
+package fsm
+
+import (
+	"fmt"
+	"go-reloaded/formatters"
+	"go-reloaded/lexer"
+	"go-reloaded/transforms"
+	"strings"
+)
+
+// QuoteRecovery selects what happens to a quote that is never closed
+type QuoteRecovery int
+
+const (
+	// QuoteLiteral writes the stray quote mark as it was and its words as
+	// ordinary words, at the end of the paragraph or the input
+	QuoteLiteral QuoteRecovery = iota
+	// QuoteCloseLine closes the quote at the end of its line
+	QuoteCloseLine
+	// QuoteCloseParagraph closes the quote at the end of its paragraph
+	QuoteCloseParagraph
+)
+
+var quoteRecoveryNames = map[QuoteRecovery]string{
+	QuoteLiteral:        "literal",
+	QuoteCloseLine:      "close-line",
+	QuoteCloseParagraph: "close-paragraph",
+}
+
+func (r QuoteRecovery) String() string {
+	if name, ok := quoteRecoveryNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseQuoteRecovery maps a name such as "close-line" to its QuoteRecovery
+func ParseQuoteRecovery(name string) (QuoteRecovery, error) {
+	for r, n := range quoteRecoveryNames {
+		if strings.EqualFold(name, n) {
+			return r, nil
+		}
+	}
+	return QuoteLiteral, fmt.Errorf("unknown quote recovery %q (want literal, close-line or close-paragraph)", name)
+}
+
+// maxQuoteDepth is how many quotes can be open inside one another. A
+// mark that would open one more is kept as a word, so work on the open
+// quotes costs the same however many marks the input has.
+const maxQuoteDepth = 32
+
+// quoteLevel is one open quote. Quotes nest by alternating kinds, as in
+// " he said ' hi ' to me " or “ he said ‘ hi ’ to me ”, and every level buffers its own words so
+// modifiers and a/an only see the words of the innermost open quote.
+type quoteLevel struct {
+	mark   string  // The quote character that opened this level: ' " “ « ...
+	space  string  // Whitespace to emit before the open quote
+	pre    string  // Opening marks hugging the open quote
+	offset int     // Input offset of the open quote
+	tight  bool    // The open quote touched the word after it
+	words  []entry // Words gathered so far
+}
+
+// inQuote reports whether a quote is open
+func (p *Processor) inQuote() bool {
+	return len(p.quotes) > 0
+}
+
+// activeBuffer returns the buffer new words go to:
+// the innermost open quote, or the word buffer outside quotes
+func (p *Processor) activeBuffer() *[]entry {
+	if n := len(p.quotes); n > 0 {
+		return &p.quotes[n-1].words
+	}
+	return &p.wordBuffer
+}
+
+// handleQuote closes the innermost quote when token is its closing mark
+// and opens a nested quote otherwise. A mark closing an outer quote first
+// resolves the quotes opened inside it.
+func (p *Processor) handleQuote(token lexer.Token) {
+	p.resolveMisnested(token.Text)
+	n := len(p.quotes)
+	if n == 0 || !formatters.ClosesQuote(p.quotes[n-1].mark, token.Text) {
+		if n == maxQuoteDepth {
+			p.report(token.Offset, "quote-too-deep", "quote %s would nest deeper than %d quotes, kept as is", token.Text, maxQuoteDepth)
+			p.appendWord(token, token.Text)
+			p.lastProcessedWasWord = true
+			return
+		}
+		p.openQuote(token)
+		return
+	}
+	level := p.quotes[n-1]
+	spaced := token.Offset != p.lastTextEnd && token.Offset != p.marksEnd
+	smart := p.opts.SmartQuotes && formatters.IsStraightQuote(level.mark)
+	if !level.tight || spaced || smart {
+		p.stats.Quotes++
+	}
+	p.closeQuote(token.Text)
+	p.marksEnd = token.End()
+}
+
+// resolveMisnested handles a closing mark that matches an outer quote
+// rather than the innermost one, as the second " of " a ' b " c ': the
+// quotes opened inside the outer one are kept as is, or closed with
+// QuoteCloseLine and QuoteCloseParagraph, and reported once. A mark that
+// can open a further nested quote, as in " a ' b " c " d ' e ", does.
+func (p *Processor) resolveMisnested(close string) {
+	n := len(p.quotes)
+	if n < 2 || formatters.ClosesQuote(p.quotes[n-1].mark, close) || p.nestingCloses(close) {
+		return
+	}
+	outer := n - 2
+	for outer >= 0 && !formatters.ClosesQuote(p.quotes[outer].mark, close) {
+		outer--
+	}
+	if outer < 0 {
+		return
+	}
+	inner := p.quotes[outer+1]
+	if p.opts.QuoteRecovery == QuoteLiteral {
+		p.report(inner.offset, "misnested-quote", "quote %s is still open when the outer quote %s closes, kept as is", inner.mark, p.quotes[outer].mark)
+	} else {
+		p.report(inner.offset, "misnested-quote", "quote %s is still open when the outer quote %s closes, closed before it", inner.mark, p.quotes[outer].mark)
+	}
+	for len(p.quotes) > outer+1 {
+		if p.opts.QuoteRecovery == QuoteLiteral {
+			p.unwindQuote()
+		} else {
+			p.closeQuote(formatters.ClosingQuote(p.quotes[len(p.quotes)-1].mark))
+		}
+	}
+}
+
+// closeQuote closes the innermost open quote with the mark close
+func (p *Processor) closeQuote(close string) {
+	p.flushPrefix()
+	n := len(p.quotes)
+	level := p.quotes[n-1]
+	p.quotes = p.quotes[:n-1]
+
+	// Apply a/an transformation inside quotes before formatting
+	p.stats.Articles += fixArticles(level.words)
+
+	// Hand the quote to the enclosing level, between typed markers
+	buffer := p.activeBuffer()
+	*buffer = append(*buffer, entry{kind: quoteStart, mark: level.mark, space: level.space, pre: level.pre})
+	*buffer = append(*buffer, level.words...)
+	*buffer = append(*buffer, entry{kind: quoteEnd, mark: close})
+	p.lastProcessedWasWord = true
+}
+
+func (p *Processor) openQuote(token lexer.Token) {
+	level := quoteLevel{
+		mark:   token.Text,
+		space:  p.gap(token),
+		offset: token.Offset,
+		words:  make([]entry, 0),
+	}
+	if next, ok := p.peek(1); ok && next.Offset == token.End() {
+		level.tight = next.Kind != lexer.Whitespace && next.Kind != lexer.Newline
+	}
+	if p.prefixGlued {
+		p.flushPrefix()
+	}
+	if p.prefix != "" {
+		level.pre = p.prefix
+		level.space = p.prefixSpace
+		p.prefix = ""
+	}
+	p.quotes = append(p.quotes, level)
+	p.lastProcessedWasWord = false
+}
+
+// quoteBoundary is a place recoverQuotes resolves open quotes at
+type quoteBoundary int
+
+const (
+	softLineBreak quoteBoundary = iota // A line break inside a paragraph, where only QuoteCloseLine acts
+	lineEnd                            // A line break that ends the paragraph too
+	paragraphEnd                       // A blank line
+	inputEnd
+)
+
+var quoteBoundaryNames = [...]string{
+	softLineBreak: "line",
+	lineEnd:       "line",
+	paragraphEnd:  "paragraph",
+	inputEnd:      "input",
+}
+
+// recoverQuotes deals with quotes still open at a boundary they can't
+// cross. Every boundary but a soft line break resolves every open quote.
+// A quote with nothing after its mark is kept as is even when recovery
+// closes quotes, rather than written as an empty pair.
+func (p *Processor) recoverQuotes(at quoteBoundary) {
+	recovery := p.opts.QuoteRecovery
+	if at == softLineBreak && recovery != QuoteCloseLine {
+		return
+	}
+	for len(p.quotes) > 0 {
+		level := p.quotes[len(p.quotes)-1]
+		if recovery == QuoteLiteral || len(level.words) == 0 && p.prefix == "" {
+			p.report(level.offset, "unterminated-quote", "quote %s is never closed, kept as is", level.mark)
+			p.unwindQuote()
+		} else {
+			p.report(level.offset, "unterminated-quote", "quote %s is never closed, closed at the end of the %s",
+				level.mark, quoteBoundaryNames[at])
+			p.closeQuote(formatters.ClosingQuote(level.mark))
+		}
+	}
+}
+
+// unwindQuote drops the innermost open quote: its mark becomes an
+// ordinary word and its words move to the enclosing level
+func (p *Processor) unwindQuote() {
+	p.flushPrefix()
+	n := len(p.quotes)
+	level := p.quotes[n-1]
+	p.quotes = p.quotes[:n-1]
+
+	buffer := p.activeBuffer()
+	words := level.words
+	if level.tight && len(words) > 0 {
+		words[0].pre = level.pre + level.mark + words[0].pre
+		words[0].space = level.space
+	} else {
+		*buffer = append(*buffer, entry{text: level.mark, space: level.space, pre: level.pre, offset: level.offset, end: level.offset + len(level.mark)})
+	}
+	*buffer = append(*buffer, words...)
+}
+
+// isApostrophe decides whether a ' or ’ touching a word belongs to that
+// word instead of opening or closing a quote. Apostrophes inside a word
+// such as don't never reach here: the lexer keeps them in the word.
+func (p *Processor) isApostrophe(token lexer.Token) bool {
+	if token.Text != "'" && token.Text != "’" {
+		return false
+	}
+	next, ok := p.peek(1)
+	nextGlued := ok && next.Offset == token.End() && (next.Kind == lexer.Word || next.Kind == lexer.Number)
+	buffer := *p.activeBuffer()
+	prevGlued := token.Offset == p.lastTextEnd && len(buffer) > 0
+
+	switch {
+	case nextGlued && !prevGlued:
+		return formatters.IsLeadingApostrophe(next.Text)
+	case prevGlued && !nextGlued:
+		last := buffer[len(buffer)-1]
+		if last.post != "" {
+			return false
+		}
+		apostrophe, ambiguous := formatters.TrailingApostrophe(last.text)
+		if !apostrophe || !ambiguous {
+			return apostrophe
+		}
+		// "the students' books" inside ' ... ': it only closes the quote
+		// when no other ' on the line could close it
+		n := len(p.quotes)
+		if n == 0 || !formatters.ClosesQuote(p.quotes[n-1].mark, token.Text) {
+			return true
+		}
+		return p.quoteFollowsOnLine(1, token.Text)
+	}
+	return false
+}
+
+// quoteFollowsOnLine reports whether mark comes up again as a quote
+// token from i on, before the end of the line or quoteLookahead tokens
+func (p *Processor) quoteFollowsOnLine(i int, mark string) bool {
+	for ; ; i++ {
+		token, ok := p.peek(i)
+		if !ok || i > quoteLookahead || token.Kind == lexer.Newline {
+			return false
+		}
+		if token.Kind == lexer.Quote && token.Text == mark {
+			return true
+		}
+	}
+}
+
+// quoteLookahead is how many tokens nestingCloses and
+// quoteFollowsOnLine read ahead. A quote mark on a long line is decided
+// within it, so every mark costs the same however long the line is.
+const quoteLookahead = 256
+
+// nestingCloses reports whether opening a quote with mark here lets the
+// quote marks after it close every open quote before the quotes reach
+// their end, reading each mark the way handleQuote would. Quotes left
+// open quoteLookahead tokens on count as not closing.
+func (p *Processor) nestingCloses(mark string) bool {
+	marks := make([]string, 0, len(p.quotes)+1)
+	for _, level := range p.quotes {
+		marks = append(marks, level.mark)
+	}
+	marks = append(marks, mark)
+	for i := 1; len(marks) > 0; i++ {
+		token, ok := p.peek(i)
+		if !ok || i > quoteLookahead || token.Kind == lexer.Newline && (!p.opts.ParagraphBoundaries || p.isParagraphBreak(i)) {
+			return false
+		}
+		if token.Kind != lexer.Quote {
+			continue
+		}
+		if formatters.ClosesQuote(marks[len(marks)-1], token.Text) {
+			marks = marks[:len(marks)-1]
+		} else {
+			marks = append(marks, token.Text)
+		}
+	}
+	return true
+}
+
+// fixArticles applies the a/an rule to every word of a closed quote,
+// looking past the markers of quotes nested inside it, and returns how
+// many it changed
+func fixArticles(words []entry) int {
+	fixed := 0
+	for i := range words {
+		if words[i].kind != wordEntry {
+			continue
+		}
+		if next := nextWordText(words, i+1); next != "" {
+			if word := transforms.FixArticle(words[i].text, next); word != words[i].text {
+				words[i].text = word
+				fixed++
+			}
+		}
+	}
+	return fixed
+}
+
+// nextWordText returns the first word at or after i that isn't a quote
+// marker, or "" when a quote ends first or the buffer runs out
+func nextWordText(words []entry, i int) string {
+	for ; i < len(words); i++ {
+		switch {
+		case words[i].kind == quoteStart:
+			continue
+		case words[i].kind == quoteEnd:
+			return ""
+		default:
+			return words[i].text
+		}
+	}
+	return ""
+}
+
+// formatQuote wraps already joined quote text in the marks recorded by
+// its start and end entries. Straight quotes become the typographic pair
+// of the language with smart quotes, and a language's spacing inside
+// quotes, such as « bonjour » in French, is added.
+func (p *Processor) formatQuote(open, close string, text []string) string {
+	if p.opts.SmartQuotes && formatters.IsStraightQuote(open) {
+		open, close, _ = p.opts.Profile.SmartQuotes(open)
+	}
+	profile := p.opts.Profile
+	return formatters.FormatQuotePair(open+profile.SpaceAfter(open), profile.SpaceBefore(close)+close, text)
+}
+
+// wordText returns an entry as written, with typographic apostrophes
+// when smart quotes are on
+func (p *Processor) wordText(e entry) string {
+	if p.opts.SmartQuotes {
+		return formatters.SmartApostrophes(e.String())
+	}
+	return e.String()
+}
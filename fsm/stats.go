@@ -0,0 +1,44 @@
+package fsm
+
+// Stats counts the edits the last call to Process made, by kind. They are
+// collected as the processor goes, whatever the options.
+type Stats struct {
+	// Modifiers counts the modifiers applied, by type: "up", "low",
+	// "cap", "hex" and "bin". A modifier left in the text as a word, or
+	// one that changed none of its words, as hex after a word that isn't
+	// a number, isn't counted.
+	Modifiers map[string]int
+	// Articles counts the a and an changed to fit the next word
+	Articles int
+	// Punctuation counts the marks, or groups of marks, that were
+	// respaced or rewritten: " ," to ",", "( note" to "(note", "!!!" to "!"
+	Punctuation int
+	// Quotes counts the quotes whose inner spacing or marks changed
+	Quotes int
+}
+
+// Stats returns what the last call to Process changed
+func (p *Processor) Stats() Stats {
+	return p.stats
+}
+
+// Add adds the counts of other to s
+func (s *Stats) Add(other Stats) {
+	for modType, n := range other.Modifiers {
+		if s.Modifiers == nil {
+			s.Modifiers = make(map[string]int)
+		}
+		s.Modifiers[modType] += n
+	}
+	s.Articles += other.Articles
+	s.Punctuation += other.Punctuation
+	s.Quotes += other.Quotes
+}
+
+// countModifier records a modifier that changed the words it applied to
+func (p *Processor) countModifier(modType string) {
+	if p.stats.Modifiers == nil {
+		p.stats.Modifiers = make(map[string]int)
+	}
+	p.stats.Modifiers[modType]++
+}
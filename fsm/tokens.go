@@ -0,0 +1,82 @@
+package fsm
+
+import (
+	"go-reloaded/lexer"
+	"strings"
+)
+
+// compactThreshold is how many consumed tokens the window keeps
+// before dropping them, so memory stays bounded on huge inputs.
+const compactThreshold = 4096
+
+// peek returns the token i positions ahead of the current one,
+// pulling more tokens from the lexer as needed.
+func (p *Processor) peek(i int) (lexer.Token, bool) {
+	for p.pos+i >= len(p.tokens) {
+		tok, ok := p.lexer.Next()
+		if !ok {
+			return lexer.Token{}, false
+		}
+		if tok.Kind == lexer.Modifier && !isModifierName(tok.Text) {
+			// ( note ) is a bracketed word
+			p.tokens = append(p.tokens, lexer.Unwrap(tok)...)
+			continue
+		}
+		p.tokens = append(p.tokens, tok)
+	}
+	return p.tokens[p.pos+i], true
+}
+
+// isModifierName reports whether a token shaped like a modifier names
+// one, in any case, so (UP) is still reported as a misspelled modifier
+func isModifierName(text string) bool {
+	name, _, _ := parseModifier(text)
+	_, ok := LookupModifier(strings.ToLower(name))
+	return ok
+}
+
+// advance moves past the current token, remembering the whitespace
+// and the kind of token the next one follows
+func (p *Processor) advance() {
+	if tok := p.tokens[p.pos]; tok.Kind == lexer.Whitespace {
+		p.pendingSpace = tok.Text
+	} else {
+		p.pendingSpace = ""
+		p.prevKind = tok.Kind
+	}
+	p.pos++
+	if p.pos >= compactThreshold && p.pos*2 >= len(p.tokens) {
+		n := copy(p.tokens, p.tokens[p.pos:])
+		p.tokens = p.tokens[:n]
+		p.pos = 0
+	}
+}
+
+// skip advances past the next n tokens
+func (p *Processor) skip(n int) {
+	for i := 0; i < n; i++ {
+		p.advance()
+	}
+}
+
+// isSkipped reports whether the token only separates other tokens
+func isSkipped(tok lexer.Token) bool {
+	return tok.Kind == lexer.Whitespace
+}
+
+// isParagraphBreak reports whether the newline i tokens ahead
+// is followed by another one, with only blanks in between
+func (p *Processor) isParagraphBreak(i int) bool {
+	for j := i + 1; ; j++ {
+		token, ok := p.peek(j)
+		if !ok {
+			return false
+		}
+		if token.Kind == lexer.Newline {
+			return true
+		}
+		if !isSkipped(token) {
+			return false
+		}
+	}
+}
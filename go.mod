@@ -1,3 +1,5 @@
 module go-reloaded
 
 go 1.25.1
+
+require golang.org/x/text v0.41.0
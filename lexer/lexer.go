@@ -0,0 +1,252 @@
+package lexer
+
+import (
+	"go-reloaded/formatters"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind classifies a token
+type Kind uint8
+
+const (
+	Word        Kind = iota // letters, digits, contractions, hyphenated and slash compounds
+	Number                  // a word made only of digits
+	Modifier                // anything shaped like (name) or (name, 2)
+	Punctuation             // a mark from the formatters punctuation table: . , ! ? ( ) — …
+	Quote                   // ' " and the typographic quotes ‘ ’ “ ” „ ‚ « » ‹ ›
+	Whitespace              // spaces, tabs and other blanks except line breaks
+	Newline                 // \n, \r\n or a lone \r
+	Other                   // every rune no other kind claims
+)
+
+var kindNames = [...]string{
+	Word:        "word",
+	Number:      "number",
+	Modifier:    "modifier",
+	Punctuation: "punctuation",
+	Quote:       "quote",
+	Whitespace:  "whitespace",
+	Newline:     "newline",
+	Other:       "other",
+}
+
+func (k Kind) String() string {
+	if int(k) >= len(kindNames) {
+		return "unknown"
+	}
+	return kindNames[k]
+}
+
+// Token is a typed slice of the input.
+// Text is never empty and Offset is the byte offset of Text in the input,
+// so concatenating the Text of every token gives back the input exactly.
+type Token struct {
+	Kind   Kind
+	Text   string
+	Offset int
+}
+
+// End returns the byte offset just past the token
+func (t Token) End() int {
+	return t.Offset + len(t.Text)
+}
+
+// Lexer hands out tokens one at a time, so callers never need
+// to hold the whole token list in memory.
+type Lexer struct {
+	input string
+	pos   int
+}
+
+// New returns a Lexer positioned at the start of input
+func New(input string) *Lexer {
+	return &Lexer{input: input}
+}
+
+// Next returns the next token, or false once the input is exhausted.
+// No byte of the input is dropped: anything that isn't a word, modifier,
+// punctuation mark, quote or blank comes back as an Other token, and
+// invalid UTF-8 is returned byte by byte as Other.
+func (l *Lexer) Next() (Token, bool) {
+	if l.pos >= len(l.input) {
+		return Token{}, false
+	}
+	start := l.pos
+	kind, end := scan(l.input, start)
+	// Merge runs of unclaimed runes into a single Other token
+	for kind == Other && end < len(l.input) {
+		next, nextEnd := scan(l.input, end)
+		if next != Other {
+			break
+		}
+		end = nextEnd
+	}
+	l.pos = end
+	return Token{Kind: kind, Text: l.input[start:end], Offset: start}, true
+}
+
+// Tokenize splits the whole input into tokens
+func Tokenize(input string) []Token {
+	var tokens []Token
+	l := New(input)
+	for {
+		tok, ok := l.Next()
+		if !ok {
+			return tokens
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// Unwrap reads a Modifier token as plain text: its opening bracket, then
+// the blanks, name, comma, count and closing bracket inside it, at the
+// same offsets. The lexer can't tell (note) from (up) on its own, since
+// it doesn't know the modifier names.
+func Unwrap(token Token) []Token {
+	tokens := []Token{{Kind: Punctuation, Text: "(", Offset: token.Offset}}
+	for _, tok := range Tokenize(token.Text[1:]) {
+		tok.Offset += token.Offset + 1
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+// scan classifies the token starting at pos and returns its end offset
+func scan(input string, pos int) (Kind, int) {
+	r, size := utf8.DecodeRuneInString(input[pos:])
+	switch {
+	case r == utf8.RuneError && size <= 1:
+		return Other, pos + 1
+	case r == '\n':
+		return Newline, pos + 1
+	case r == '\r':
+		if pos+1 < len(input) && input[pos+1] == '\n' {
+			return Newline, pos + 2
+		}
+		return Newline, pos + 1
+	case isBlank(r):
+		end := pos + size
+		for end < len(input) {
+			r, size = utf8.DecodeRuneInString(input[end:])
+			if !isBlank(r) || r == utf8.RuneError {
+				break
+			}
+			end += size
+		}
+		return Whitespace, end
+	case isWordRune(r):
+		return scanWord(input, pos)
+	case formatters.IsQuoteMark(r):
+		return Quote, pos + size
+	case r == '(':
+		if end, ok := scanModifier(input, pos); ok {
+			return Modifier, end
+		}
+	}
+	if formatters.IsPunctuation(r) {
+		return Punctuation, pos + size
+	}
+	return Other, pos + size
+}
+
+// scanWord reads a letter, digit or underscore, then any run of those and
+// combining marks, followed by any number of separator-joined parts:
+// don't, don’t, well-known, and/or, A→B. Combining marks keep decomposed text
+// such as e + U+0301 in one word. A separator only belongs to the word if
+// another word rune follows it.
+func scanWord(input string, pos int) (Kind, int) {
+	end := skipWordRunes(input, pos)
+	for end < len(input) {
+		r, size := utf8.DecodeRuneInString(input[end:])
+		if !isWordSeparator(r) || end+size >= len(input) {
+			break
+		}
+		next, _ := utf8.DecodeRuneInString(input[end+size:])
+		if !isWordRune(next) {
+			break
+		}
+		end = skipWordRunes(input, end+size)
+	}
+
+	kind := Number
+	for _, r := range input[pos:end] {
+		if !unicode.IsNumber(r) {
+			kind = Word
+			break
+		}
+	}
+	return kind, end
+}
+
+func skipWordRunes(input string, pos int) int {
+	for pos < len(input) {
+		r, size := utf8.DecodeRuneInString(input[pos:])
+		if !isWordRune(r) && !unicode.Is(unicode.M, r) {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+// scanModifier matches \(\s*\w+\s*(?:,\s*(?:\d+|q|quote)\s*)?\) at pos.
+// \w and \d are ASCII-only, as in the original regular expression.
+// The q and quote counts aim a modifier at the last quote as a whole.
+func scanModifier(input string, pos int) (int, bool) {
+	i := pos + 1
+	i = skipASCII(input, i, isASCIISpace)
+	start := i
+	i = skipASCII(input, i, isASCIIWord)
+	if i == start {
+		return 0, false
+	}
+	i = skipASCII(input, i, isASCIISpace)
+	if i < len(input) && input[i] == ',' {
+		i = skipASCII(input, i+1, isASCIISpace)
+		start = i
+		i = skipASCII(input, i, isASCIIDigit)
+		if i == start {
+			i = skipASCII(input, i, isASCIIWord)
+			if count := input[start:i]; count != "q" && count != "quote" {
+				return 0, false
+			}
+		}
+		i = skipASCII(input, i, isASCIISpace)
+	}
+	if i < len(input) && input[i] == ')' {
+		return i + 1, true
+	}
+	return 0, false
+}
+
+func skipASCII(input string, pos int, fn func(byte) bool) int {
+	for pos < len(input) && fn(input[pos]) {
+		pos++
+	}
+	return pos
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+func isWordSeparator(r rune) bool {
+	return r == '-' || r == '\'' || r == '’' || r == '/' || r == '→'
+}
+
+func isBlank(r rune) bool {
+	return r != '\n' && r != '\r' && unicode.IsSpace(r)
+}
+
+func isASCIISpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func isASCIIWord(b byte) bool {
+	return b == '_' || isASCIIDigit(b) || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isASCIIDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
@@ -0,0 +1,145 @@
+package lsp
+
+import (
+	"go-reloaded/fsm"
+	"go-reloaded/lexer"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// document is an open file with what the processor made of it
+type document struct {
+	uri         string
+	version     int
+	text        string
+	lines       []int // Byte offset of every line
+	tokens      []lexer.Token
+	output      string
+	diagnostics []fsm.Diagnostic
+	applied     []fsm.AppliedModifier
+}
+
+// newDocument runs p over text
+func newDocument(p *fsm.Processor, uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version, text: text, lines: lineOffsets(text)}
+	d.output = p.Process(text)
+	d.diagnostics = append(d.diagnostics, p.Diagnostics()...)
+	d.applied = append(d.applied, p.AppliedModifiers()...)
+	l := lexer.New(text)
+	for token, ok := l.Next(); ok; token, ok = l.Next() {
+		d.tokens = append(d.tokens, token)
+	}
+	return d
+}
+
+// lineOffsets returns the offset of every line of text. Lines end at \n,
+// \r\n or a lone \r, as the protocol counts them.
+func lineOffsets(text string) []int {
+	lines := []int{0}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\n':
+			lines = append(lines, i+1)
+		case text[i] == '\r' && (i+1 >= len(text) || text[i+1] != '\n'):
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// position turns a byte offset into a line and a UTF-16 column
+func (d *document) position(offset int) Position {
+	offset = max(0, min(offset, len(d.text)))
+	line := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset
+	}) - 1
+	character := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		character += utf16.RuneLen(r) // Invalid UTF-8 counts as U+FFFD
+	}
+	return Position{Line: line, Character: character}
+}
+
+// offset turns a position back into a byte offset, clamped to its line
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line]
+	end := len(d.text)
+	if pos.Line+1 < len(d.lines) {
+		end = d.lines[pos.Line+1]
+	}
+	for units := 0; offset < end && units < pos.Character; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:end])
+		if r == '\n' || r == '\r' {
+			break
+		}
+		units += utf16.RuneLen(r)
+		offset += size
+	}
+	return offset
+}
+
+func (d *document) span(offset, end int) Range {
+	return Range{Start: d.position(offset), End: d.position(end)}
+}
+
+// tokenAt returns the token covering offset
+func (d *document) tokenAt(offset int) (lexer.Token, bool) {
+	i := sort.Search(len(d.tokens), func(i int) bool {
+		return d.tokens[i].End() > offset
+	})
+	if i == len(d.tokens) || d.tokens[i].Offset > offset {
+		return lexer.Token{}, false
+	}
+	return d.tokens[i], true
+}
+
+// diagnosticEnd returns where the text a diagnostic is about ends: the
+// token it points at, or the whole modifier for a malformed one, which
+// the lexer reads as several tokens
+func (d *document) diagnosticEnd(diag fsm.Diagnostic) int {
+	if diag.Code == "malformed-modifier" {
+		if end := strings.IndexByte(d.text[diag.Offset:], ')'); end >= 0 {
+			return diag.Offset + end + 1
+		}
+	}
+	if token, ok := d.tokenAt(diag.Offset); ok {
+		return token.End()
+	}
+	return diag.Offset
+}
+
+// appliedAt returns the applied modifier written at offset
+func (d *document) appliedAt(offset int) (fsm.AppliedModifier, bool) {
+	for _, m := range d.applied {
+		if m.Offset <= offset && offset < m.End {
+			return m, true
+		}
+	}
+	return fsm.AppliedModifier{}, false
+}
+
+// modifierEdits returns the edits that apply m alone: its words rewritten
+// from the source and the modifier taken out with the blanks before it
+func (d *document) modifierEdits(m fsm.AppliedModifier) []TextEdit {
+	mod, _ := fsm.LookupModifier(m.Name)
+	var edits []TextEdit
+	for _, target := range m.Targets {
+		word := d.text[target.Offset:target.End]
+		if changed := mod.Apply(word); changed != word {
+			edits = append(edits, TextEdit{Range: d.span(target.Offset, target.End), NewText: changed})
+		}
+	}
+	start := m.Offset
+	for start > 0 && (d.text[start-1] == ' ' || d.text[start-1] == '\t') {
+		start--
+	}
+	return append(edits, TextEdit{Range: d.span(start, m.End), NewText: ""})
+}
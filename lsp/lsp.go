@@ -0,0 +1,339 @@
+// Package lsp is a Language Server Protocol server over stdio, for
+// editors to show what the processor would change while text is typed.
+//
+// Every open document is processed on each change. The server publishes
+// the processor's diagnostics, such as malformed modifiers and quotes that
+// never close, and offers code actions applying one modifier or the whole
+// result, hover text explaining a modifier and completion of modifier
+// names.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-reloaded/fsm"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Server answers one client, one message at a time
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	processor *fsm.Processor
+	documents map[string]*document
+	started   bool // initialize was answered
+	stopping  bool // shutdown was answered
+}
+
+// New returns a Server reading requests from in and writing to out, that
+// processes documents with opts
+func New(in io.Reader, out io.Writer, opts fsm.Options) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		processor: fsm.NewProcessorWithOptions(opts),
+		documents: make(map[string]*document),
+	}
+}
+
+// Run serves until the client sends exit or closes the input. Exiting
+// without a shutdown request first is an error, as the protocol says.
+func (s *Server) Run() error {
+	for {
+		msg, err := readMessage(s.in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			if err := s.reply(nil, nil, rpcErr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.stopping {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers a request, or acts on a notification
+func (s *Server) handle(msg message) error {
+	isRequest := msg.ID != nil
+	switch {
+	case !s.started && msg.Method != "initialize":
+		if isRequest {
+			return s.reply(msg.ID, nil, &rpcError{Code: codeNotInitialized, Message: "initialize first"})
+		}
+		return nil
+	case s.stopping:
+		if isRequest {
+			return s.reply(msg.ID, nil, &rpcError{Code: codeInvalidRequest, Message: "shutting down"})
+		}
+		return nil
+	}
+
+	var result any
+	var err error
+	switch msg.Method {
+	case "initialize":
+		s.started = true
+		result = initializeResult()
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil
+	case "shutdown":
+		s.stopping = true
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err = decodeParams(msg, &params); err == nil {
+			doc := params.TextDocument
+			err = s.update(doc.URI, doc.Version, doc.Text)
+		}
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err = decodeParams(msg, &params); err == nil && len(params.ContentChanges) > 0 {
+			// Full sync: the last change holds the whole text
+			text := params.ContentChanges[len(params.ContentChanges)-1].Text
+			err = s.update(params.TextDocument.URI, params.TextDocument.Version, text)
+		}
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err = decodeParams(msg, &params); err == nil {
+			delete(s.documents, params.TextDocument.URI)
+			err = s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		}
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err = decodeParams(msg, &params); err == nil {
+			result = s.hover(params)
+		}
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err = decodeParams(msg, &params); err == nil {
+			result = s.completion(params)
+		}
+	case "textDocument/codeAction":
+		var params CodeActionParams
+		if err = decodeParams(msg, &params); err == nil {
+			result = s.codeActions(params)
+		}
+	default:
+		if isRequest {
+			return s.reply(msg.ID, nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not supported", msg.Method)})
+		}
+		return nil
+	}
+
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) {
+		if isRequest {
+			return s.reply(msg.ID, nil, rpcErr)
+		}
+		return nil
+	}
+	if err != nil || !isRequest {
+		return err
+	}
+	return s.reply(msg.ID, result, nil)
+}
+
+func initializeResult() any {
+	return map[string]any{
+		"capabilities": map[string]any{
+			"textDocumentSync":   1, // Full
+			"hoverProvider":      true,
+			"completionProvider": map[string]any{"triggerCharacters": []string{"("}},
+			"codeActionProvider": map[string]any{"codeActionKinds": []string{kindQuickFix, kindRewrite, kindFixAll}},
+		},
+		"serverInfo": map[string]any{"name": "go-reloaded"},
+	}
+}
+
+// decodeParams reads the params of msg into v
+func decodeParams(msg message, v any) error {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// update processes a new version of a document and publishes its
+// diagnostics
+func (s *Server) update(uri string, version int, text string) error {
+	doc := newDocument(s.processor, uri, version, text)
+	s.documents[uri] = doc
+	diagnostics := make([]Diagnostic, 0, len(doc.diagnostics))
+	for _, d := range doc.diagnostics {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    doc.span(d.Offset, doc.diagnosticEnd(d)),
+			Severity: SeverityWarning,
+			Code:     d.Code,
+			Source:   "go-reloaded",
+			Message:  d.Message,
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Version: version, Diagnostics: diagnostics})
+}
+
+// hover explains the modifier under the cursor, and what it does to the
+// words before it
+func (s *Server) hover(params TextDocumentPositionParams) *Hover {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	offset := doc.offset(params.Position)
+	if m, ok := doc.appliedAt(offset); ok {
+		mod, _ := fsm.LookupModifier(m.Name)
+		var before []string
+		for _, target := range m.Targets {
+			before = append(before, doc.text[target.Offset:target.End])
+		}
+		// The words as processed, after any modifier chained to this one
+		value := fmt.Sprintf("**%s**: %s\n\n`%s` → `%s`", doc.text[m.Offset:m.End], mod.Summary,
+			strings.Join(before, " "), strings.Join(m.Results, " "))
+		return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}, Range: doc.span(m.Offset, m.End)}
+	}
+
+	// A modifier the processor left in the text says why
+	for _, d := range doc.diagnostics {
+		end := doc.diagnosticEnd(d)
+		if strings.Contains(d.Code, "modifier") && d.Offset <= offset && offset < end {
+			value := fmt.Sprintf("**%s**: not applied\n\n%s", doc.text[d.Offset:end], d.Message)
+			return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}, Range: doc.span(d.Offset, end)}
+		}
+	}
+	return nil
+}
+
+// modifierStart matches an opening parenthesis and the start of a
+// modifier name, right before the cursor
+var modifierStart = regexp.MustCompile(`\(\s*(\w*)$`)
+
+// completion suggests the modifier names that start with what was typed
+// after an opening parenthesis
+func (s *Server) completion(params TextDocumentPositionParams) CompletionList {
+	list := CompletionList{Items: []CompletionItem{}}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return list
+	}
+	offset := doc.offset(params.Position)
+	line := doc.text[doc.lines[doc.position(offset).Line]:offset]
+	m := modifierStart.FindStringSubmatchIndex(line)
+	if m == nil {
+		return list
+	}
+	typed := line[m[2]:m[3]]
+	replace := doc.span(offset-len(typed), offset)
+	closed := strings.HasPrefix(doc.text[offset:], ")")
+	for _, mod := range fsm.Modifiers() {
+		if !strings.HasPrefix(mod.Name, strings.ToLower(typed)) {
+			continue
+		}
+		text := mod.Name
+		if !closed {
+			text += ")"
+		}
+		detail := "(" + mod.Name + ")"
+		if mod.Counted {
+			detail = "(" + mod.Name + "), (" + mod.Name + ", n) or (" + mod.Name + ", q)"
+		}
+		list.Items = append(list.Items, CompletionItem{
+			Label:         mod.Name,
+			Kind:          completionFunction,
+			Detail:        detail,
+			Documentation: MarkupContent{Kind: "markdown", Value: mod.Summary},
+			TextEdit:      TextEdit{Range: replace, NewText: text},
+		})
+	}
+	return list
+}
+
+// codeActions offers to apply each modifier in the range on its own, to
+// fix the case of a modifier name, and to apply the whole result
+func (s *Server) codeActions(params CodeActionParams) []CodeAction {
+	actions := []CodeAction{}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return actions
+	}
+	start, end := doc.offset(params.Range.Start), doc.offset(params.Range.End)
+	overlaps := func(offset, stop int) bool {
+		return offset <= end && start <= stop
+	}
+	edit := func(edits ...TextEdit) WorkspaceEdit {
+		return WorkspaceEdit{Changes: map[string][]TextEdit{doc.uri: edits}}
+	}
+
+	for _, m := range doc.applied {
+		if overlaps(m.Offset, m.End) {
+			actions = append(actions, CodeAction{
+				Title: "Apply " + doc.text[m.Offset:m.End],
+				Kind:  kindRewrite,
+				Edit:  edit(doc.modifierEdits(m)...),
+			})
+		}
+	}
+	for _, d := range doc.diagnostics {
+		stop := doc.diagnosticEnd(d)
+		if d.Code != "unknown-modifier" || !overlaps(d.Offset, stop) {
+			continue
+		}
+		fixed := strings.ToLower(doc.text[d.Offset:stop])
+		actions = append(actions, CodeAction{
+			Title: "Change to " + fixed,
+			Kind:  kindQuickFix,
+			Diagnostics: []Diagnostic{{
+				Range: doc.span(d.Offset, stop), Severity: SeverityWarning, Code: d.Code, Source: "go-reloaded", Message: d.Message,
+			}},
+			Edit: edit(TextEdit{Range: doc.span(d.Offset, stop), NewText: fixed}),
+		})
+	}
+	if doc.output != doc.text {
+		actions = append(actions, CodeAction{
+			Title: "Apply all go-reloaded edits",
+			Kind:  kindFixAll,
+			Edit:  edit(TextEdit{Range: doc.span(0, len(doc.text)), NewText: doc.output}),
+		})
+	}
+	return actions
+}
+
+// reply answers the request id with a result or an error
+func (s *Server) reply(id json.RawMessage, result any, rpcErr *rpcError) error {
+	msg := message{ID: id, Error: rpcErr}
+	if id == nil {
+		msg.ID = json.RawMessage("null")
+	}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg.Result = data
+	}
+	return writeMessage(s.out, msg)
+}
+
+// notify sends a notification to the client
+func (s *Server) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, message{Method: method, Params: data})
+}
@@ -1,19 +1,118 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"go-reloaded/formats"
+	"go-reloaded/formatters"
 	"go-reloaded/fsm"
+	"go-reloaded/lsp"
+	"go-reloaded/report"
+	"go-reloaded/server"
+	"net"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: go run . <input_file> <output_file>")
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		if err := lsp.New(os.Stdin, os.Stdout, fsm.Options{}).Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving LSP: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var opts fsm.Options
+	format := formats.Text
+	var formatOpts formats.Options
+	var reportFile string
+	flag.BoolVar(&opts.PreserveWhitespace, "preserve-whitespace", false, "keep tabs, runs of spaces and indentation")
+	flag.BoolVar(&opts.CollapseBlankLines, "collapse-blank-lines", false, "squeeze runs of blank lines down to one")
+	flag.BoolVar(&opts.ParagraphBoundaries, "paragraphs", false, "let modifiers and a/an reach across line breaks inside a paragraph")
+	flag.BoolVar(&opts.UnwrapParagraphs, "unwrap", false, "join hard-wrapped lines of a paragraph into one line")
+	flag.IntVar(&opts.WrapWidth, "wrap", 0, "wrap lines at this many characters (0 disables wrapping)")
+	flag.Func("line-endings", "preserve (default), auto, lf, crlf or cr", func(name string) error {
+		var err error
+		opts.LineEndings, err = fsm.ParseLineEnding(name)
+		return err
+	})
+	flag.BoolVar(&opts.StripBOM, "strip-bom", false, "drop a UTF-8 byte order mark at the start of the input")
+	flag.Func("normalize", "Unicode normalization: none (default), nfc or nfd", func(name string) error {
+		var err error
+		opts.Normalization, err = fsm.ParseNormalization(name)
+		return err
+	})
+	langs := formatters.ProfileNames()
+	langUsage := fmt.Sprintf("punctuation spacing profile: %s (default), %s or %s",
+		langs[0], strings.Join(langs[1:len(langs)-1], ", "), langs[len(langs)-1])
+	flag.Func("lang", langUsage, func(name string) error {
+		var err error
+		opts.Profile, err = formatters.ParseProfile(name)
+		return err
+	})
+	flag.Func("ellipsis", "rewrite .. and ... : keep (default), dots or char", func(name string) error {
+		var err error
+		opts.Punctuation.Ellipsis, err = formatters.ParseEllipsis(name)
+		return err
+	})
+	flag.Func("interrobang", "rewrite ?! and !? : keep (default), pair or char", func(name string) error {
+		var err error
+		opts.Punctuation.Interrobang, err = formatters.ParseInterrobang(name)
+		return err
+	})
+	flag.BoolVar(&opts.SmartQuotes, "smart-quotes", false, "write typographic quotes and apostrophes for the --lang language")
+	flag.BoolVar(&opts.Punctuation.CollapseRepeats, "collapse-repeats", false, "squeeze repeated marks such as !!!! down to one")
+	flag.BoolVar(&opts.Punctuation.DropComma, "drop-comma", false, "drop a comma that runs into another mark, as in ,.")
+	flag.Func("unclosed-quotes", "recover a quote that never closes: literal (default), close-line or close-paragraph", func(name string) error {
+		var err error
+		opts.QuoteRecovery, err = fsm.ParseQuoteRecovery(name)
+		return err
+	})
+	flag.Func("format", "input format: text (default), markdown, html, json, yaml, srt, vtt, csv or tsv", func(name string) error {
+		var err error
+		format, err = formats.ParseFormat(name)
+		return err
+	})
+	flag.Func("path", "JSONPath of the json or yaml values to process, such as '$.messages.*' (repeatable, default all strings)", func(path string) error {
+		formatOpts.Paths = append(formatOpts.Paths, path)
+		return nil
+	})
+	flag.Func("columns", "comma-separated csv or tsv columns to process, by header name (default all)", func(names string) error {
+		formatOpts.Columns = append(formatOpts.Columns, strings.Split(names, ",")...)
+		return nil
+	})
+	flag.BoolVar(&formatOpts.SpanCues, "span-cues", false, "let modifiers, a/an and quotes of srt or vtt subtitles reach across the cues of a caption block")
+	flag.IntVar(&opts.Workers, "workers", 1, "process a large input on this many goroutines, cut at blank lines (0 uses every CPU)")
+	flag.BoolVar(&opts.Explain, "explain", false, "print every normalization to stderr")
+	flag.StringVar(&reportFile, "report", "", "write a JSON report of what was changed in each file to this path")
+	flag.Usage = func() {
+		fmt.Println("Usage: go run . [options] <input_file> <output_file>")
+		fmt.Println("       go run . serve [--addr :8080] [--max-bytes N]")
+		fmt.Println("       go run . lsp")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
 		os.Exit(1)
 	}
+	if opts.Workers == 0 {
+		opts.Workers = runtime.NumCPU()
+	}
 
-	inputFile := os.Args[1]
-	outputFile := os.Args[2]
+	inputFile := flag.Arg(0)
+	outputFile := flag.Arg(1)
 
 	input, err := os.ReadFile(inputFile)
 	if err != nil {
@@ -21,14 +120,62 @@ func main() {
 		os.Exit(1)
 	}
 
-	processor := fsm.NewProcessor()
-	result := processor.Process(string(input))
+	processor := fsm.NewProcessorWithOptions(opts)
+	start := time.Now()
+	result, err := formats.Process(processor, format, string(input), formatOpts)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Printf("Error processing input file: %v\n", err)
+		os.Exit(1)
+	}
+	for _, d := range result.Diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", inputFile, d)
+	}
+	for _, e := range result.Explanations {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", inputFile, e)
+	}
+	for _, e := range result.Edits {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", inputFile, e)
+	}
 
-	err = os.WriteFile(outputFile, []byte(result), 0644)
+	err = os.WriteFile(outputFile, []byte(result.Text), 0644)
 	if err != nil {
 		fmt.Printf("Error writing output file: %v\n", err)
 		os.Exit(1)
 	}
 
+	if reportFile != "" {
+		var r report.Report
+		r.Add(report.NewFile(inputFile, outputFile, format.String(), string(input), result.Text, result.Stats, result.Diagnostics, elapsed))
+		if err := r.Write(reportFile); err != nil {
+			fmt.Printf("Error writing report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Printf("✓ Success: %s → %s\n", inputFile, outputFile)
 }
+
+// serve runs the HTTP service until it is interrupted, then lets the
+// requests in flight finish
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	maxBytes := fs.Int64("max-bytes", server.DefaultMaxBodyBytes, "largest request body accepted, in bytes")
+	fs.Parse(args)
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Printf("Error starting server: %v\n", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Listening on %s\n", ln.Addr())
+	if err := server.Serve(ctx, ln, server.Config{MaxBodyBytes: *maxBytes}); err != nil {
+		fmt.Printf("Error serving: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("✓ Server stopped")
+}
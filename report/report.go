@@ -0,0 +1,130 @@
+// Package report builds the machine-readable summary of a run that
+// --report writes: what the processor changed in every file, what it had
+// to work around, and how long it took. The counts come from fsm.Stats,
+// collected while processing, never from diffing the output.
+package report
+
+import (
+	"encoding/json"
+	"go-reloaded/fsm"
+	"os"
+	"time"
+)
+
+// Report is the summary of a run
+type Report struct {
+	Files  []File `json:"files"`
+	Totals Counts `json:"totals"`
+}
+
+// File is the summary of one processed file
+type File struct {
+	Input       string       `json:"input"`
+	Output      string       `json:"output"`
+	Format      string       `json:"format"`
+	DurationMS  float64      `json:"duration_ms"`
+	Counts      Counts       `json:"counts"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Counts adds up the sizes and edits of one file or of a whole run
+type Counts struct {
+	Files       int            `json:"files,omitempty"`
+	InputBytes  int            `json:"input_bytes"`
+	OutputBytes int            `json:"output_bytes"`
+	Modifiers   map[string]int `json:"modifiers"`
+	Articles    int            `json:"articles"`
+	Punctuation int            `json:"punctuation"`
+	Quotes      int            `json:"quotes"`
+	Diagnostics int            `json:"diagnostics"`
+}
+
+// Diagnostic is an fsm.Diagnostic as the report writes it
+type Diagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewFile summarizes the processing of input into output
+func NewFile(inputPath, outputPath, format, input, output string, stats fsm.Stats, diagnostics []fsm.Diagnostic, elapsed time.Duration) File {
+	return File{
+		Input:       inputPath,
+		Output:      outputPath,
+		Format:      format,
+		DurationMS:  float64(elapsed.Microseconds()) / 1000,
+		Counts:      NewCounts(input, output, stats, len(diagnostics)),
+		Diagnostics: NewDiagnostics(diagnostics),
+	}
+}
+
+// NewCounts counts the sizes and edits of processing input into output
+func NewCounts(input, output string, stats fsm.Stats, diagnostics int) Counts {
+	return Counts{
+		InputBytes:  len(input),
+		OutputBytes: len(output),
+		Modifiers:   modifierCounts(stats.Modifiers),
+		Articles:    stats.Articles,
+		Punctuation: stats.Punctuation,
+		Quotes:      stats.Quotes,
+		Diagnostics: diagnostics,
+	}
+}
+
+// NewDiagnostics converts diagnostics for the report, never nil
+func NewDiagnostics(diagnostics []fsm.Diagnostic) []Diagnostic {
+	converted := make([]Diagnostic, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		converted = append(converted, Diagnostic{Line: d.Line, Column: d.Column, Code: d.Code, Message: d.Message})
+	}
+	return converted
+}
+
+// Add appends f to the report and to its totals
+func (r *Report) Add(f File) {
+	r.Files = append(r.Files, f)
+	t := &r.Totals
+	if t.Modifiers == nil {
+		t.Modifiers = modifierCounts(nil)
+	}
+	t.Files++
+	t.InputBytes += f.Counts.InputBytes
+	t.OutputBytes += f.Counts.OutputBytes
+	for modType, n := range f.Counts.Modifiers {
+		t.Modifiers[modType] += n
+	}
+	t.Articles += f.Counts.Articles
+	t.Punctuation += f.Counts.Punctuation
+	t.Quotes += f.Counts.Quotes
+	t.Diagnostics += f.Counts.Diagnostics
+}
+
+// Write saves the report as indented JSON
+func (r Report) Write(path string) error {
+	if r.Files == nil {
+		r.Files = []File{}
+	}
+	if r.Totals.Modifiers == nil {
+		r.Totals.Modifiers = modifierCounts(nil)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// modifierCounts copies counts with every registered modifier present,
+// zero or not, so every report has the same shape
+func modifierCounts(counts map[string]int) map[string]int {
+	modifiers := fsm.Modifiers()
+	all := make(map[string]int, len(modifiers))
+	for _, m := range modifiers {
+		all[m.Name] = 0
+	}
+	for modType, n := range counts {
+		all[modType] += n
+	}
+	return all
+}
@@ -0,0 +1,360 @@
+// Package server exposes the processor over HTTP, for services that would
+// otherwise shell out to the command line tool.
+//
+//	POST /v1/process  process text/plain or a JSON request
+//	GET  /healthz     report that the server is up
+//
+// Every response of /v1/process is JSON holding the processed text, the
+// diagnostics, the explain log and the statistics of the run.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-reloaded/formats"
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"go-reloaded/report"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBodyBytes is the request size limit when Config leaves it unset
+const DefaultMaxBodyBytes = 1 << 20
+
+// maxPools bounds how many sets of options keep a pool of processors.
+// Requests with other options still work, on processors that aren't kept.
+const maxPools = 64
+
+// shutdownTimeout is how long Serve waits for requests in flight to finish
+const shutdownTimeout = 10 * time.Second
+
+// Config tunes the server
+type Config struct {
+	// MaxBodyBytes caps the size of a request body. Zero uses
+	// DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+// Options are the processor options a request can set, named after the
+// command line flags. The zero value is the command line default.
+type Options struct {
+	PreserveWhitespace bool   `json:"preserve_whitespace"`
+	CollapseBlankLines bool   `json:"collapse_blank_lines"`
+	Paragraphs         bool   `json:"paragraphs"`
+	Unwrap             bool   `json:"unwrap"`
+	Wrap               int    `json:"wrap"`
+	LineEndings        string `json:"line_endings"`
+	Normalize          string `json:"normalize"`
+	StripBOM           bool   `json:"strip_bom"`
+	Lang               string `json:"lang"`
+	SmartQuotes        bool   `json:"smart_quotes"`
+	Ellipsis           string `json:"ellipsis"`
+	Interrobang        string `json:"interrobang"`
+	CollapseRepeats    bool   `json:"collapse_repeats"`
+	DropComma          bool   `json:"drop_comma"`
+	UnclosedQuotes     string `json:"unclosed_quotes"`
+}
+
+// Request is the JSON body of POST /v1/process
+type Request struct {
+	Text     string   `json:"text"`
+	Format   string   `json:"format"`
+	Options  Options  `json:"options"`
+	Paths    []string `json:"paths"`
+	Columns  []string `json:"columns"`
+	SpanCues bool     `json:"span_cues"`
+}
+
+// Response is the body POST /v1/process answers with
+type Response struct {
+	Text         string              `json:"text"`
+	Diagnostics  []report.Diagnostic `json:"diagnostics"`
+	Explanations []Explanation       `json:"explanations"`
+	Edits        []Edit              `json:"edits"`
+	Stats        report.Counts       `json:"stats"`
+}
+
+// Explanation is one entry of the explain log
+type Explanation struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Rule   string `json:"rule"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Edit is a changed value of a JSON, YAML, CSV or TSV document
+type Edit struct {
+	Path   string `json:"path"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// errorResponse is the body of every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server handles the requests. A Processor isn't safe for concurrent use,
+// so every request borrows one of its own from a pool kept per set of
+// options, for up to maxPools sets.
+type Server struct {
+	maxBodyBytes int64
+	mu           sync.Mutex
+	pools        map[poolKey]*fsm.Pool
+	mux          *http.ServeMux
+}
+
+// poolKey tells parsed processor options apart, so "FR" and "fr" share a
+// pool. fsm.Options holds the maps of its profile, so it can't be a key.
+type poolKey struct {
+	preserveWhitespace bool
+	collapseBlankLines bool
+	paragraphs         bool
+	unwrap             bool
+	wrap               int
+	lineEndings        fsm.LineEnding
+	normalization      fsm.Normalization
+	stripBOM           bool
+	profile            string
+	smartQuotes        bool
+	punctuation        formatters.PunctuationOptions
+	quoteRecovery      fsm.QuoteRecovery
+}
+
+func newPoolKey(opts fsm.Options) poolKey {
+	return poolKey{
+		preserveWhitespace: opts.PreserveWhitespace,
+		collapseBlankLines: opts.CollapseBlankLines,
+		paragraphs:         opts.ParagraphBoundaries,
+		unwrap:             opts.UnwrapParagraphs,
+		wrap:               opts.WrapWidth,
+		lineEndings:        opts.LineEndings,
+		normalization:      opts.Normalization,
+		stripBOM:           opts.StripBOM,
+		profile:            opts.Profile.Name,
+		smartQuotes:        opts.SmartQuotes,
+		punctuation:        opts.Punctuation,
+		quoteRecovery:      opts.QuoteRecovery,
+	}
+}
+
+// New returns a Server configured by cfg
+func New(cfg Config) *Server {
+	s := &Server{maxBodyBytes: cfg.MaxBodyBytes, pools: make(map[poolKey]*fsm.Pool), mux: http.NewServeMux()}
+	if s.maxBodyBytes <= 0 {
+		s.maxBodyBytes = DefaultMaxBodyBytes
+	}
+	s.mux.HandleFunc("POST /v1/process", s.handleProcess)
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve answers requests on ln until ctx is done, then shuts down
+// gracefully: it stops accepting connections and waits for the requests
+// in flight, up to shutdownTimeout.
+func Serve(ctx context.Context, ln net.Listener, cfg Config) error {
+	srv := &http.Server{
+		Handler:           New(cfg),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
+	req, status, err := s.readRequest(w, r)
+	if err != nil {
+		writeJSON(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+	opts, err := req.Options.processorOptions()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	format := formats.Text
+	if req.Format != "" {
+		if format, err = formats.ParseFormat(req.Format); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	pool := s.pool(opts)
+	p := pool.Get()
+	defer pool.Put(p)
+	result, err := formats.Process(p, format, req.Text, formats.Options{
+		Paths:    req.Paths,
+		Columns:  req.Columns,
+		SpanCues: req.SpanCues,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, newResponse(req.Text, result))
+}
+
+// readRequest reads a text/plain or JSON body, within the size limit. A
+// text/plain body can pick its format with the format query parameter.
+func (s *Server) readRequest(w http.ResponseWriter, r *http.Request) (Request, int, error) {
+	var req Request
+	body := http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	mediaType := "text/plain"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return req, http.StatusUnsupportedMediaType, fmt.Errorf("bad Content-Type: %v", err)
+		}
+	}
+
+	switch mediaType {
+	case "text/plain":
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return req, bodyStatus(err), err
+		}
+		req.Text = string(data)
+		req.Format = r.URL.Query().Get("format")
+	case "application/json":
+		dec := json.NewDecoder(body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return req, bodyStatus(err), fmt.Errorf("invalid JSON request: %w", err)
+		}
+		if dec.More() {
+			return req, http.StatusBadRequest, errors.New("invalid JSON request: more than one value")
+		}
+	default:
+		return req, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %q (want text/plain or application/json)", mediaType)
+	}
+	return req, http.StatusOK, nil
+}
+
+// bodyStatus picks the status for an error reading the body
+func bodyStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// pool returns the pool of processors for a set of options. Once maxPools
+// sets have one, new sets get a pool that isn't kept, so clients varying
+// their options can't grow the server without bound.
+func (s *Server) pool(opts fsm.Options) *fsm.Pool {
+	key := newPoolKey(opts)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pool, ok := s.pools[key]; ok {
+		return pool
+	}
+	pool := fsm.NewPool(opts)
+	if len(s.pools) < maxPools {
+		s.pools[key] = pool
+	}
+	return pool
+}
+
+// processorOptions parses the options. The explain log is always kept.
+func (o Options) processorOptions() (fsm.Options, error) {
+	if o.Wrap < 0 {
+		return fsm.Options{}, fmt.Errorf("wrap must not be negative, got %d", o.Wrap)
+	}
+	opts := fsm.Options{
+		PreserveWhitespace:  o.PreserveWhitespace,
+		CollapseBlankLines:  o.CollapseBlankLines,
+		ParagraphBoundaries: o.Paragraphs,
+		UnwrapParagraphs:    o.Unwrap,
+		WrapWidth:           o.Wrap,
+		StripBOM:            o.StripBOM,
+		SmartQuotes:         o.SmartQuotes,
+		Explain:             true,
+	}
+	opts.Punctuation.CollapseRepeats = o.CollapseRepeats
+	opts.Punctuation.DropComma = o.DropComma
+	var err error
+	if o.LineEndings != "" {
+		if opts.LineEndings, err = fsm.ParseLineEnding(o.LineEndings); err != nil {
+			return opts, err
+		}
+	}
+	if o.Normalize != "" {
+		if opts.Normalization, err = fsm.ParseNormalization(o.Normalize); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Profile, err = formatters.ParseProfile(o.Lang); err != nil {
+		return opts, err
+	}
+	if opts.Punctuation.Ellipsis, err = formatters.ParseEllipsis(o.Ellipsis); err != nil {
+		return opts, err
+	}
+	if opts.Punctuation.Interrobang, err = formatters.ParseInterrobang(o.Interrobang); err != nil {
+		return opts, err
+	}
+	if o.UnclosedQuotes != "" {
+		if opts.QuoteRecovery, err = fsm.ParseQuoteRecovery(o.UnclosedQuotes); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func newResponse(input string, result formats.Result) Response {
+	resp := Response{
+		Text:         result.Text,
+		Diagnostics:  report.NewDiagnostics(result.Diagnostics),
+		Explanations: make([]Explanation, 0, len(result.Explanations)),
+		Edits:        make([]Edit, 0, len(result.Edits)),
+		Stats:        report.NewCounts(input, result.Text, result.Stats, len(result.Diagnostics)),
+	}
+	for _, e := range result.Explanations {
+		resp.Explanations = append(resp.Explanations, Explanation{Line: e.Line, Column: e.Column, Rule: e.Rule, Before: e.Before, After: e.After})
+	}
+	for _, e := range result.Edits {
+		resp.Edits = append(resp.Edits, Edit{Path: e.Path, Before: e.Before, After: e.After})
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
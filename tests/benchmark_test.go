@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"fmt"
+	"go-reloaded/fsm"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ==================== SCALING BENCHMARKS ====================
+//
+// Run with:  go test -bench Process -benchmem ./tests
+//
+// Every size reports MB/s through b.SetBytes. Linear processing keeps
+// MB/s within a small factor from 1 MB to 100 MB: it dips by about a
+// quarter from 1 MB to 10 MB and holds from there. A quadratic
+// regression makes throughput collapse as the input grows.
+
+// benchParagraph is punctuation-heavy on purpose: every punctuation group
+// and newline used to rewrite the whole output buffer.
+const benchParagraph = "it (cap) was a amazing day , the sun was shining ! " +
+	"FF (hex) birds , 101 (bin) cats and ' a honest dog ' were there ... " +
+	"what a story (up, 3) ! ? yes ; no : maybe .\n"
+
+func buildBenchInput(size int) string {
+	var sb strings.Builder
+	sb.Grow(size + len(benchParagraph))
+	for sb.Len() < size {
+		sb.WriteString(benchParagraph)
+	}
+	return sb.String()
+}
+
+// checkLinear fails t when processing unit repeated takes about sixteen
+// times longer, rather than four times as long, once the input grows
+// fourfold. The
+// input grows until a run takes long enough to time, and each size is
+// timed a few times after a GC, the fastest run counting, to keep noise
+// from other tests out.
+func checkLinear(t *testing.T, unit string, opts fsm.Options) {
+	t.Helper()
+	fastest := func(input string) time.Duration {
+		best := time.Duration(0)
+		for i := 0; i < 5; i++ {
+			runtime.GC()
+			start := time.Now()
+			fsm.Process(input, opts)
+			if elapsed := time.Since(start); best == 0 || elapsed < best {
+				best = elapsed
+			}
+		}
+		return best
+	}
+	n := 1000
+	small := fastest(strings.Repeat(unit, n))
+	for small < 50*time.Millisecond && n < 1<<20 {
+		n *= 2
+		small = fastest(strings.Repeat(unit, n))
+	}
+	large := fastest(strings.Repeat(unit, 4*n))
+	if large > 8*small {
+		t.Errorf("%q x %d took %v, x %d took %v; want about four times as long", unit, n, small, 4*n, large)
+	}
+}
+
+func benchmarkProcess(b *testing.B, size int) {
+	input := buildBenchInput(size)
+	processor := fsm.NewProcessor()
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		processor.Process(input)
+	}
+}
+
+func BenchmarkProcess(b *testing.B) {
+	sizes := []int{1 << 20, 10 << 20, 100 << 20}
+	for _, size := range sizes {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			if size > 10<<20 && testing.Short() {
+				b.Skip("skipping 100 MB input in short mode")
+			}
+			benchmarkProcess(b, size)
+		})
+	}
+}
+
+func BenchmarkProcessNoPunctuation(b *testing.B) {
+	input := strings.Repeat("lorem ipsum dolor sit amet ", (1<<20)/27)
+	processor := fsm.NewProcessor()
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		processor.Process(input)
+	}
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"fmt"
+	"go-reloaded/formats"
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// ==================== CONCURRENCY TESTS ====================
+//
+// Run with:  go test -race -run Concurrent ./tests
+//
+// Every goroutine checks its results against a sequential run, so a
+// processor sharing state between calls shows up as a wrong result as
+// well as a reported race.
+
+var concurrentInputs = []string{
+	"it (cap) was a amazing day , the sun was shining !",
+	"FF (hex) birds , 101 (bin) cats and ' a honest dog ' were there ...",
+	"what a story (up, 3) ! ? yes ; no : maybe .",
+	"he said ' hello there\nand \" a b \" (up, q) left",
+	"wow !!!! ok... (up) first (UP) and (cap, 1.5)",
+	"a\r\nb , c\n\n\nd ' e ' f",
+}
+
+func concurrentOptions() []fsm.Options {
+	fr, _ := formatters.ParseProfile("fr")
+	smart := fsm.Options{SmartQuotes: true, Explain: true}
+	smart.Punctuation.CollapseRepeats = true
+	return []fsm.Options{
+		{},
+		{PreserveWhitespace: true, CollapseBlankLines: true},
+		{ParagraphBoundaries: true, QuoteRecovery: fsm.QuoteCloseLine},
+		{UnwrapParagraphs: true, WrapWidth: 20, LineEndings: fsm.LineEndingLF},
+		{Profile: fr, Explain: true},
+		smart,
+	}
+}
+
+// sequentialResults runs every input with every set of options on one
+// goroutine
+func sequentialResults(options []fsm.Options) [][]fsm.Result {
+	expected := make([][]fsm.Result, len(options))
+	for i, opts := range options {
+		processor := fsm.NewProcessorWithOptions(opts)
+		for _, input := range concurrentInputs {
+			text := processor.Process(input)
+			expected[i] = append(expected[i], fsm.Result{
+				Text:         text,
+				Diagnostics:  processor.Diagnostics(),
+				Explanations: processor.Explain(),
+				Stats:        processor.Stats(),
+				Modifiers:    processor.AppliedModifiers(),
+			})
+		}
+	}
+	return expected
+}
+
+func checkResult(t *testing.T, input string, expected, got fsm.Result) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\nInput:    %q\nExpected: %+v\nGot:      %+v", input, expected, got)
+	}
+}
+
+func TestProcessMatchesProcessor(t *testing.T) {
+	options := concurrentOptions()
+	expected := sequentialResults(options)
+	for i, opts := range options {
+		for j, input := range concurrentInputs {
+			checkResult(t, input, expected[i][j], fsm.Process(input, opts))
+		}
+	}
+}
+
+// hammer runs fn from many goroutines, each going over every input and
+// set of options in its own order
+func hammer(options []fsm.Options, fn func(i, j int)) {
+	const goroutines = 32
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < len(options)*len(concurrentInputs); k++ {
+				n := (k + g*7) % (len(options) * len(concurrentInputs))
+				fn(n/len(concurrentInputs), n%len(concurrentInputs))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentProcess(t *testing.T) {
+	options := concurrentOptions()
+	expected := sequentialResults(options)
+	hammer(options, func(i, j int) {
+		checkResult(t, concurrentInputs[j], expected[i][j], fsm.Process(concurrentInputs[j], options[i]))
+	})
+}
+
+func TestConcurrentPool(t *testing.T) {
+	options := concurrentOptions()
+	expected := sequentialResults(options)
+	pools := make([]*fsm.Pool, len(options))
+	for i, opts := range options {
+		pools[i] = fsm.NewPool(opts)
+	}
+	hammer(options, func(i, j int) {
+		checkResult(t, concurrentInputs[j], expected[i][j], pools[i].Process(concurrentInputs[j]))
+	})
+}
+
+func TestConcurrentPoolWithFormats(t *testing.T) {
+	inputs := []struct {
+		format formats.Format
+		input  string
+	}{
+		{formats.Markdown, "# a apple (up)\n\nsome `code , here` and text , here"},
+		{formats.HTML, "<p>hello , <b>world</b> (up)</p>"},
+		{formats.JSON, `{"a": "x , y (cap)", "b": ["a honest one"]}`},
+		{formats.CSV, "id,note\n1,it was a apple (up)\n"},
+	}
+	pool := fsm.NewPool(fsm.Options{})
+	expected := make([]formats.Result, len(inputs))
+	for i, in := range inputs {
+		result, err := formats.Process(fsm.NewProcessor(), in.format, in.input, formats.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected[i] = result
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range inputs {
+				i := (k + g) % len(inputs)
+				processor := pool.Get()
+				result, err := formats.Process(processor, inputs[i].format, inputs[i].input, formats.Options{})
+				pool.Put(processor)
+				if err != nil || !reflect.DeepEqual(result, expected[i]) {
+					t.Errorf("\nInput:    %q\nExpected: %+v\nGot:      %+v (%v)", inputs[i].input, expected[i], result, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPoolResultsOutliveTheProcessor(t *testing.T) {
+	pool := fsm.NewPool(fsm.Options{Explain: true})
+	first := pool.Process("wow !!!! ' open (up)")
+	saved := fmt.Sprintf("%+v", first)
+	for i := 0; i < 100; i++ {
+		pool.Process("other text , here ' too (cap) !!")
+	}
+	if got := fmt.Sprintf("%+v", first); got != saved {
+		t.Errorf("result changed after the processor was reused:\nExpected: %s\nGot:      %s", saved, got)
+	}
+}
+
+func BenchmarkPoolParallel(b *testing.B) {
+	input := buildBenchInput(64 << 10)
+	pool := fsm.NewPool(fsm.Options{})
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			pool.Process(input)
+		}
+	})
+}
+
+func BenchmarkProcessParallel(b *testing.B) {
+	input := buildBenchInput(64 << 10)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fsm.Process(input, fsm.Options{})
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"go-reloaded/formats"
+	"go-reloaded/fsm"
+	"reflect"
+	"testing"
+)
+
+// ==================== CSV AND TSV FORMAT TESTS ====================
+
+func TestCSVFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   formats.Format
+		input    string
+		columns  []string
+		expected string
+	}{
+		{"every column by default", formats.CSV, "a,b\nx (up),it is a owl\n", nil, "a,b\nX,it is an owl\n"},
+		{"header untouched", formats.CSV, "it is a owl,b (up)\nx (up),y (up)\n", nil, "it is a owl,b (up)\nX,Y\n"},
+		{"selected columns only", formats.CSV, "id,description,notes\n1 (up),a (up),b (up)\n", []string{"description"}, "id,description,notes\n1 (up),A,b (up)\n"},
+		{"several columns", formats.CSV, "id,description,notes\nx (up),a (up),b (up)\n", []string{"description", "notes"}, "id,description,notes\nx (up),A,B\n"},
+		{"cells are independent", formats.CSV, "a,b\nhello,(up) world\n", nil, "a,b\nhello,(up) world\n"},
+		{"quoted cell stays quoted", formats.CSV, "a\n\"x , y\"\n", nil, "a\n\"x, y\"\n"},
+		{"escaped quotes", formats.CSV, "a\n\"he said \"\" hi \"\" (up)\"\n", nil, "a\n\"he said \"\"HI\"\"\"\n"},
+		{"unchanged cells keep their quoting", formats.CSV, "a,b\n\"ok\",\"fine.\"\n", nil, "a,b\n\"ok\",\"fine.\"\n"},
+		{"multiline cell", formats.CSV, "a,b\n\"one ,\r\ntwo (up)\",x\r\n", nil, "a,b\n\"one,\r\nTWO\",x\r\n"},
+		{"surrounding spaces kept", formats.CSV, "a,b\n  x (up)  ,z\n", []string{"a"}, "a,b\n  X  ,z\n"},
+		{"empty and short records", formats.CSV, "a,b,c\n,,\nx (up)\n", nil, "a,b,c\n,,\nX\n"},
+		{"crlf records", formats.CSV, "a,b\r\nx (up),y\r\n", nil, "a,b\r\nX,y\r\n"},
+		{"header only", formats.CSV, "a,b\n", nil, "a,b\n"},
+		{"empty input", formats.CSV, "", nil, ""},
+		{"tsv", formats.TSV, "id\tnotes\n1 ,\tit is a apple , ok\n", []string{"notes"}, "id\tnotes\n1 ,\tit is an apple, ok\n"},
+		{"tsv commas are text", formats.TSV, "a\tb\nx ,y\tz\n", nil, "a\tb\nx, y\tz\n"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, tt.format, tt.input, formats.Options{Columns: tt.columns})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestCSVEdits(t *testing.T) {
+	input := "id,notes,two words\n1,a apple,ok\n2,fine.,\"x , y\"\n"
+	expected := []formats.Edit{
+		{Path: "$[0].notes", Before: "a apple", After: "an apple"},
+		{Path: "$[1]['two words']", Before: "x , y", After: "x, y"},
+	}
+
+	result, err := formats.Process(fsm.NewProcessor(), formats.CSV, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Edits, expected) {
+		t.Errorf("\nExpected: %v\nGot:      %v", expected, result.Edits)
+	}
+}
+
+func TestCSVDiagnosticPositions(t *testing.T) {
+	input := "a,b\nx,\"say \"\"hi\"\" ' open\"\n"
+	result, err := formats.Process(fsm.NewProcessor(), formats.CSV, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", result.Diagnostics)
+	}
+	if d := result.Diagnostics[0]; d.Line != 2 || d.Column != 15 {
+		t.Errorf("expected 2:15, got %d:%d", d.Line, d.Column)
+	}
+}
+
+func TestCSVErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns []string
+	}{
+		{"bare quote", "a\nx \"y\" z\n", nil},
+		{"unterminated quote", "a\n\"x\n", nil},
+		{"unknown column", "a,b\nx,y\n", []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := formats.Process(fsm.NewProcessor(), formats.CSV, tt.input, formats.Options{Columns: tt.columns})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
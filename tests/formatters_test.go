@@ -2,6 +2,7 @@ package tests
 
 import (
 	"go-reloaded/formatters"
+	"strings"
 	"testing"
 )
 
@@ -32,17 +33,114 @@ func TestFormatPunctuation(t *testing.T) {
 		{"empty", "", ""},
 		{"complex group", "...!?", "...!?"},
 
-		// Note: FormatPunctuation just returns the string as-is
-		// The FSM handles spacing logic
+		// Note: with no normalization enabled FormatPunctuation
+		// returns the group as-is. The FSM handles spacing logic
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := formatters.FormatPunctuation(tt.input)
+			result, edits := formatters.FormatPunctuation(tt.input, formatters.PunctuationOptions{})
 			if result != tt.expected {
 				t.Errorf("FormatPunctuation(%q) = %q; want %q",
 					tt.input, result, tt.expected)
 			}
+			if len(edits) != 0 {
+				t.Errorf("FormatPunctuation(%q) edits = %v; want none", tt.input, edits)
+			}
+		})
+	}
+}
+
+func TestFormatPunctuationNormalization(t *testing.T) {
+	dots := formatters.PunctuationOptions{Ellipsis: "..."}
+	char := formatters.PunctuationOptions{Ellipsis: "…"}
+	repeats := formatters.PunctuationOptions{CollapseRepeats: true}
+	pair := formatters.PunctuationOptions{Interrobang: "?!"}
+	bang := formatters.PunctuationOptions{Interrobang: "‽"}
+	comma := formatters.PunctuationOptions{DropComma: true}
+	all := formatters.PunctuationOptions{Ellipsis: "…", CollapseRepeats: true, Interrobang: "‽", DropComma: true}
+
+	tests := []struct {
+		name     string
+		input    string
+		opts     formatters.PunctuationOptions
+		expected string
+		rules    []string
+	}{
+		{"two dots", "..", dots, "...", []string{"ellipsis"}},
+		{"four dots", "....", dots, "...", []string{"ellipsis"}},
+		{"three dots kept", "...", dots, "...", nil},
+		{"character to dots", "…", dots, "...", []string{"ellipsis"}},
+		{"dots to character", "...", char, "…", []string{"ellipsis"}},
+		{"single period kept", ".", char, ".", nil},
+		{"ellipsis then question", "....?", char, "…?", []string{"ellipsis"}},
+		{"repeated exclaim", "!!!!", repeats, "!", []string{"collapse-repeats"}},
+		{"repeated question", "???", repeats, "?", []string{"collapse-repeats"}},
+		{"repeats leave dots", "...", repeats, "...", nil},
+		{"mixed kept as pair", "?!", pair, "?!", nil},
+		{"reversed to pair", "!?", pair, "?!", []string{"interrobang"}},
+		{"pair to interrobang", "?!", bang, "‽", []string{"interrobang"}},
+		{"long mix to interrobang", "?!?!", bang, "‽", []string{"interrobang"}},
+		{"one kind untouched", "!!", bang, "!!", nil},
+		{"comma before period", ",.", comma, ".", []string{"drop-comma"}},
+		{"comma before ellipsis", ",...", comma, "...", []string{"drop-comma"}},
+		{"comma alone", ",", comma, ",", nil},
+		{"everything", ",....!!!?", all, "…‽", []string{"drop-comma", "ellipsis", "collapse-repeats", "interrobang"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, edits := formatters.FormatPunctuation(tt.input, tt.opts)
+			if result != tt.expected {
+				t.Errorf("FormatPunctuation(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+			var rules []string
+			for _, edit := range edits {
+				rules = append(rules, edit.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+				t.Errorf("FormatPunctuation(%q) rules = %v; want %v", tt.input, rules, tt.rules)
+			}
+			if len(edits) > 0 && (edits[0].Before != tt.input || edits[len(edits)-1].After != tt.expected) {
+				t.Errorf("FormatPunctuation(%q) edits = %+v don't chain from input to result", tt.input, edits)
+			}
+		})
+	}
+}
+
+func TestLookupPunctuation(t *testing.T) {
+	tests := []struct {
+		mark     rune
+		ok       bool
+		spacing  formatters.Spacing
+		boundary bool
+	}{
+		{'.', true, formatters.AttachLeft, true},
+		{'…', true, formatters.AttachLeft, true},
+		{'‽', true, formatters.AttachLeft, true},
+		{')', true, formatters.AttachLeft, false},
+		{'[', true, formatters.AttachRight, false},
+		{'¿', true, formatters.AttachRight, false},
+		{'—', true, formatters.SpaceBoth, true},
+		{'–', true, formatters.SpaceBoth, true},
+		{'-', false, 0, false},
+		{'a', false, 0, false},
+		{'\'', false, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mark), func(t *testing.T) {
+			rule, ok := formatters.LookupPunctuation(tt.mark)
+			if ok != tt.ok {
+				t.Fatalf("LookupPunctuation(%q) ok = %v; want %v", tt.mark, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if rule.Mark != tt.mark || rule.Spacing != tt.spacing || rule.Boundary != tt.boundary {
+				t.Errorf("LookupPunctuation(%q) = %+v; want spacing %d, boundary %v",
+					tt.mark, rule, tt.spacing, tt.boundary)
+			}
 		})
 	}
 }
@@ -133,3 +231,56 @@ func BenchmarkFormatQuoteMultiple(b *testing.B) {
 		formatters.FormatQuote(words)
 	}
 }
+
+// ==================== APOSTROPHE TESTS ====================
+
+func TestIsLeadingApostrophe(t *testing.T) {
+	tests := []struct {
+		word     string
+		expected bool
+	}{
+		{"tis", true},
+		{"Twas", true},
+		{"em", true},
+		{"n", true},
+		{"90s", true},
+		{"60", true},
+		{"hello", false},
+		{"1990s", false},
+		{"s", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			if result := formatters.IsLeadingApostrophe(tt.word); result != tt.expected {
+				t.Errorf("IsLeadingApostrophe(%q) = %v; want %v", tt.word, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrailingApostrophe(t *testing.T) {
+	tests := []struct {
+		word       string
+		apostrophe bool
+		ambiguous  bool
+	}{
+		{"'n", true, false},
+		{"ol", true, false},
+		{"students", true, true},
+		{"goin", true, true},
+		{"hello", false, false},
+		{"42", false, false},
+		{"s", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			apostrophe, ambiguous := formatters.TrailingApostrophe(tt.word)
+			if apostrophe != tt.apostrophe || ambiguous != tt.ambiguous {
+				t.Errorf("TrailingApostrophe(%q) = %v, %v; want %v, %v",
+					tt.word, apostrophe, ambiguous, tt.apostrophe, tt.ambiguous)
+			}
+		})
+	}
+}
@@ -124,3 +124,15 @@ func TestComplexExample(t *testing.T) {
 		t.Errorf("\nInput:    %s\nExpected: %s\nGot:      %s", input, expected, result)
 	}
 }
+
+func TestNewlineNoLeadingSpace(t *testing.T) {
+	input := "hello .\nworld , again\n\nthe end"
+	expected := "hello.\nworld, again\n\nthe end"
+
+	processor := fsm.NewProcessor()
+	result := processor.Process(input)
+
+	if result != expected {
+		t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", input, expected, result)
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"go-reloaded/formats"
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== HTML FORMAT TESTS ====================
+
+func TestHTMLFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"text node", "<p>it is a apple , ok</p>", "<p>it is an apple, ok</p>"},
+		{"attributes untouched", `<p class="a , b" title='x > y'>hi , there</p>`, `<p class="a , b" title='x > y'>hi, there</p>`},
+		{"inline elements transparent", "<p>Hello <em>world</em> , it is a <a href=\"/a,b\">apple</a> !</p>", "<p>Hello <em>world</em>, it is an <a href=\"/a,b\">apple</a>!</p>"},
+		{"inline element closing after punctuation", "<p><b>Hi , there.</b> Next</p>", "<p><b>Hi, there.</b> Next</p>"},
+		{"modifier through inline element", "<p>say <b>hello</b> (up)</p>", "<p>say <b>HELLO</b></p>"},
+		{"capitalize through inline element", "<p><i>hello</i> there (cap, 2)</p>", "<p><i>Hello</i> There</p>"},
+		{"block elements separate text", "<ul><li>one (up)</li><li>two (up, 2)</li></ul>", "<ul><li>ONE</li><li>TWO</li></ul>"},
+		{"script skipped", "<script>if (a < b) { s = \"a , b\" }</script><p>a , b</p>", "<script>if (a < b) { s = \"a , b\" }</script><p>a, b</p>"},
+		{"style skipped", "<style>p , a { color: red }</style>", "<style>p , a { color: red }</style>"},
+		{"pre skipped", "<pre>keep   this , (up)\n  as is</pre>", "<pre>keep   this , (up)\n  as is</pre>"},
+		{"code skipped inside text", "<p>use <code>x , y (up)</code> now , ok</p>", "<p>use <code>x , y (up)</code> now, ok</p>"},
+		{"nested pre and code", "<pre><code>a , b</code> c , d</pre>", "<pre><code>a , b</code> c , d</pre>"},
+		{"comment kept", "<p>a , b <!-- c , d --> e , f</p>", "<p>a, b <!-- c , d --> e, f</p>"},
+		{"doctype kept", "<!DOCTYPE html>\n<p>a , b</p>", "<!DOCTYPE html>\n<p>a, b</p>"},
+		{"entities decoded for processing", "<p>&quot; hi &quot; , don&#39;t</p>", "<p>&quot;hi&quot;, don&#39;t</p>"},
+		{"references keep their spelling", "<p>caf&eacute; &mdash; wait&hellip; it&#8217;s a apple</p><p>caf&eacute; ok</p>",
+			"<p>caf&eacute; &mdash; wait&hellip; it&#8217;s an apple</p><p>caf&eacute; ok</p>"},
+		{"literal character spelled first", "<p>don't , can&#39;t</p>", "<p>don't, can't</p>"},
+		{"other entities kept", "<p>Tom &amp; Jerry &lt;3 , &nbsp;ok &copy;</p>", "<p>Tom &amp; Jerry &lt;3, &nbsp;ok &copy;</p>"},
+		{"no-break space keeps words apart", "<p>ten&nbsp;dollars (up)</p>", "<p>ten&nbsp;DOLLARS</p>"},
+		{"no-break space before an article's word", "<p>a&nbsp;apple and a&#160;pear</p>", "<p>an&nbsp;apple and a&#160;pear</p>"},
+		{"run of space references", "<p>a&nbsp;&thinsp;apple , ok</p>", "<p>an&nbsp;&thinsp;apple, ok</p>"},
+		{"no-break space before a modifier", "<p>1E&nbsp;(hex) items</p>", "<p>30 items</p>"},
+		{"letter entities decoded", "<p>caf&eacute; (up) , ok</p>", "<p>CAF&Eacute;, ok</p>"},
+		{"numeric reference changes case", "<p>&#xe9;t&#233; (up)</p>", "<p>&#xc9;T&#xc9;</p>"},
+		{"unchanged text keeps entities", "<p>&copy; 2024 &nbsp;Acme.</p>", "<p>&copy; 2024 &nbsp;Acme.</p>"},
+		{"stray ampersand escaped", "<p>R&D , now</p>", "<p>R&amp;D, now</p>"},
+		{"lone angle bracket is text", "<p>3 < 4 , yes</p>", "<p>3 &lt; 4, yes</p>"},
+		{"unclosed tag is text", "<p>a , b <oops", "<p>a, b &lt;oops"},
+		{"uppercase tags", "<P>a , b</P><SCRIPT>x , y</SCRIPT>", "<P>a, b</P><SCRIPT>x , y</SCRIPT>"},
+		{"whitespace between blocks kept", "<div>\n  <p>a , b</p>\n</div>\n", "<div>\n  <p>a, b</p>\n</div>\n"},
+		{"unclosed script runs to the end", "<script>a , b", "<script>a , b"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.HTML, tt.input, formats.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestHTMLDiagnosticPositions(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		line   int
+		column int
+	}{
+		{"after a tag", "<p>see <b>x</b> ' open</p>", 1, 17},
+		{"after an entity", "<p>a &amp; b ' open</p>", 1, 14},
+		{"decoded quote", "<div>\n<p>a &quot; open</p>", 2, 6},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.HTML, tt.input, formats.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Diagnostics) != 1 {
+				t.Fatalf("expected 1 diagnostic, got %v", result.Diagnostics)
+			}
+			d := result.Diagnostics[0]
+			if d.Line != tt.line || d.Column != tt.column {
+				t.Errorf("expected %d:%d, got %d:%d", tt.line, tt.column, d.Line, d.Column)
+			}
+		})
+	}
+}
+
+func TestMarkupPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"article sees through markup", "a \U000F0000apple\U000F0001", "an \U000F0000apple\U000F0001"},
+		{"capitalize sees through markup", "\U000F0000hello\U000F0001 (cap)", "\U000F0000Hello\U000F0001"},
+		{"markup before punctuation", "hi\U000F0000 , there", "hi\U000F0000, there"},
+		{"markup after punctuation", "\U000F0000Hi.\U000F0001 there", "\U000F0000Hi.\U000F0001 there"},
+		{"markup after a closing quote", "say 'hi'\U000F0000 now", "say 'hi'\U000F0000 now"},
+		{"markup after punctuation in a quote", "say ' hi ,\U000F0000 you '", "say 'hi,\U000F0000 you'"},
+		{"standalone markup", "a \U000F0000 b", "a \U000F0000 b"},
+		{"last placeholder is markup", "a \U0010FFFDapple", "an \U0010FFFDapple"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+
+	for r, expected := range map[rune]bool{
+		fsm.FirstPlaceholder - 1: false,
+		fsm.FirstPlaceholder:     true,
+		fsm.LastPlaceholder:      true,
+		fsm.LastPlaceholder + 1:  false,
+	} {
+		if got := fsm.IsPlaceholder(r); got != expected {
+			t.Errorf("IsPlaceholder(%U) = %v; want %v", r, got, expected)
+		}
+	}
+}
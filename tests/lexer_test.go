@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"go-reloaded/lexer"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// ==================== TOKEN KIND TESTS ====================
+
+func TestTokenizeKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []lexer.Kind
+	}{
+		{"word", "hello", []lexer.Kind{lexer.Word}},
+		{"number", "42", []lexer.Kind{lexer.Number}},
+		{"hex digits are a word", "1E", []lexer.Kind{lexer.Word}},
+		{"contraction", "don't", []lexer.Kind{lexer.Word}},
+		{"hyphenated", "state-of-the-art", []lexer.Kind{lexer.Word}},
+		{"slash compound", "and/or", []lexer.Kind{lexer.Word}},
+		{"modifier", "(up)", []lexer.Kind{lexer.Modifier}},
+		{"modifier with count", "( cap , 12 )", []lexer.Kind{lexer.Modifier}},
+		{"quote modifier", "(up, q) (cap,quote)", []lexer.Kind{lexer.Modifier, lexer.Whitespace, lexer.Modifier}},
+		{"invalid modifier shape", "(up, x)", []lexer.Kind{lexer.Punctuation, lexer.Word, lexer.Punctuation, lexer.Whitespace, lexer.Word, lexer.Punctuation}},
+		{"extended punctuation", "…‽—–[]{}¿¡", []lexer.Kind{lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation, lexer.Punctuation}},
+		{"punctuation", ".,", []lexer.Kind{lexer.Punctuation, lexer.Punctuation}},
+		{"quotes", "'\"", []lexer.Kind{lexer.Quote, lexer.Quote}},
+		{"whitespace run", " \t ", []lexer.Kind{lexer.Whitespace}},
+		{"newline", "a\nb", []lexer.Kind{lexer.Word, lexer.Newline, lexer.Word}},
+		{"crlf", "a\r\nb", []lexer.Kind{lexer.Word, lexer.Newline, lexer.Word}},
+		{"lone cr", "a\rb", []lexer.Kind{lexer.Word, lexer.Newline, lexer.Word}},
+		{"cr cr lf", "\r\r\n", []lexer.Kind{lexer.Newline, lexer.Newline}},
+		{"trailing apostrophe", "dogs'", []lexer.Kind{lexer.Word, lexer.Quote}},
+		{"dangling hyphen", "well-", []lexer.Kind{lexer.Word, lexer.Other}},
+		{"other run", "$&", []lexer.Kind{lexer.Other}},
+		{"emoji", "hi 🙂", []lexer.Kind{lexer.Word, lexer.Whitespace, lexer.Other}},
+		{"invalid utf8", "a\xffb", []lexer.Kind{lexer.Word, lexer.Other, lexer.Word}},
+		{"decomposed accent", "cafe\u0301 ok", []lexer.Kind{lexer.Word, lexer.Whitespace, lexer.Word}},
+		{"leading combining mark", "\u0301a", []lexer.Kind{lexer.Other, lexer.Word}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := lexer.Tokenize(tt.input)
+			var kinds []lexer.Kind
+			for _, tok := range tokens {
+				kinds = append(kinds, tok.Kind)
+			}
+			if len(kinds) != len(tt.expected) {
+				t.Fatalf("Tokenize(%q) kinds = %v; want %v", tt.input, kinds, tt.expected)
+			}
+			for i := range kinds {
+				if kinds[i] != tt.expected[i] {
+					t.Fatalf("Tokenize(%q) kinds = %v; want %v", tt.input, kinds, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestUnwrapModifier(t *testing.T) {
+	input := "x ( note, 2 )"
+	tokens := lexer.Tokenize(input)
+	if len(tokens) != 3 || tokens[2].Kind != lexer.Modifier {
+		t.Fatalf("Tokenize(%q) = %v; want a modifier last", input, tokens)
+	}
+	var kinds []lexer.Kind
+	var text strings.Builder
+	for _, tok := range lexer.Unwrap(tokens[2]) {
+		if input[tok.Offset:tok.End()] != tok.Text {
+			t.Errorf("token %q at %d doesn't match the input", tok.Text, tok.Offset)
+		}
+		kinds = append(kinds, tok.Kind)
+		text.WriteString(tok.Text)
+	}
+	expected := []lexer.Kind{lexer.Punctuation, lexer.Whitespace, lexer.Word, lexer.Punctuation, lexer.Whitespace, lexer.Number, lexer.Whitespace, lexer.Punctuation}
+	if text.String() != tokens[2].Text || !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Unwrap(%q) = %q %v; want %v", tokens[2].Text, text.String(), kinds, expected)
+	}
+}
+
+func TestTokenizeKeepsEveryCharacter(t *testing.T) {
+	input := "Price: $5 & 10% off (50%) #deal @shop — [ok] 🙂\n"
+	checkTokensCoverInput(t, input, lexer.Tokenize(input))
+}
+
+// ==================== FUZZ TESTS ====================
+
+// legacyTokenPattern is the regular expression tokenize used before the lexer,
+// extended with combining marks inside words (\p{M}) so decomposed accents
+// stay in their word, with ’ as a word separator like ', and with the
+// (up, q) quote count. Every token it matches must come out of the lexer unchanged.
+var legacyTokenPattern = regexp.MustCompile(`([\p{L}\p{N}_][\p{L}\p{M}\p{N}_]*(?:[-'’/→][\p{L}\p{N}_][\p{L}\p{M}\p{N}_]*)*|[.,!?:;'"]|\(\s*\w+\s*(?:,\s*(?:\d+|quote|q)\s*)?\)|\n)`)
+
+func FuzzTokenize(f *testing.F) {
+	seeds := []string{
+		"",
+		"it (cap) was a amazing day !",
+		"' hello ' \"world\" (up, 2)",
+		"don't well-known and/or A→B",
+		"Price: $5 & 10% off (50%)",
+		"tabs\tand\r\nCRLF",
+		"café café 🙂 #tag @user",
+		"\xff\xfe broken \xc3",
+		"((up) (low, ) (cap,3)",
+		"“curly” ‘single’ don’t « guillemets » „low“",
+		"' a b ' (up, q) (low, quote) (cap, qq)",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tokens := lexer.Tokenize(input)
+		checkTokensCoverInput(t, input, tokens)
+
+		// The legacy pattern only knew \n: it saw \r\n as a dropped \r
+		// followed by \n, and dropped a lone \r entirely. It also only
+		// knew the six ASCII marks and straight quotes, not brackets,
+		// dashes, the ellipsis or typographic quotes.
+		var kept []string
+		for _, tok := range tokens {
+			switch {
+			case tok.Kind == lexer.Whitespace || tok.Kind == lexer.Other:
+			case tok.Kind == lexer.Punctuation && !strings.Contains(".,!?:;", tok.Text):
+			case tok.Kind == lexer.Quote && tok.Text != "'" && tok.Text != "\"":
+			case tok.Kind == lexer.Newline && tok.Text == "\r":
+			case tok.Kind == lexer.Newline:
+				kept = append(kept, "\n")
+			default:
+				kept = append(kept, tok.Text)
+			}
+		}
+		legacy := legacyTokenPattern.FindAllString(input, -1)
+		if strings.Join(kept, "\x00") != strings.Join(legacy, "\x00") {
+			t.Fatalf("Tokenize(%q) kept %q; legacy regexp matched %q", input, kept, legacy)
+		}
+	})
+}
+
+// checkTokensCoverInput asserts that tokens account for every byte of input,
+// in order, without gaps, overlaps or empty tokens.
+func checkTokensCoverInput(t *testing.T, input string, tokens []lexer.Token) {
+	t.Helper()
+	var sb strings.Builder
+	offset := 0
+	for _, tok := range tokens {
+		if tok.Text == "" {
+			t.Fatalf("Tokenize(%q) produced an empty token at %d", input, tok.Offset)
+		}
+		if tok.Offset != offset {
+			t.Fatalf("Tokenize(%q) token %q at offset %d; want %d", input, tok.Text, tok.Offset, offset)
+		}
+		if input[tok.Offset:tok.End()] != tok.Text {
+			t.Fatalf("Tokenize(%q) token %q doesn't match input slice", input, tok.Text)
+		}
+		sb.WriteString(tok.Text)
+		offset = tok.End()
+	}
+	if sb.String() != input {
+		t.Fatalf("Tokenize(%q) lost bytes: rebuilt %q", input, sb.String())
+	}
+}
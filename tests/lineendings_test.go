@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== LINE ENDING TESTS ====================
+
+const bom = "\uFEFF"
+
+func TestLineEndings(t *testing.T) {
+	mixed := "it (cap) was fine .\r\nthe end (up)\nof a\rstory !\r\n"
+
+	tests := []struct {
+		name     string
+		opts     fsm.Options
+		input    string
+		expected string
+	}{
+		{"crlf round trip", fsm.Options{}, "one ,\r\ntwo\r\n\r\nthree\r\n", "one,\r\ntwo\r\n\r\nthree\r\n"},
+		{"lone cr round trip", fsm.Options{}, "one\rtwo (up)\r", "one\rTWO\r"},
+		{"mixed preserved per line", fsm.Options{}, mixed, "It was fine.\r\nthe END\nof a\rstory!\r\n"},
+		{"mixed to lf", fsm.Options{LineEndings: fsm.LineEndingLF}, mixed, "It was fine.\nthe END\nof a\nstory!\n"},
+		{"mixed to crlf", fsm.Options{LineEndings: fsm.LineEndingCRLF}, mixed, "It was fine.\r\nthe END\r\nof a\r\nstory!\r\n"},
+		{"mixed to cr", fsm.Options{LineEndings: fsm.LineEndingCR}, mixed, "It was fine.\rthe END\rof a\rstory!\r"},
+		{"mixed to dominant", fsm.Options{LineEndings: fsm.LineEndingAuto}, mixed, "It was fine.\r\nthe END\r\nof a\r\nstory!\r\n"},
+		{"bom with crlf", fsm.Options{}, bom + "line one\r\nline two\r\n", bom + "line one\r\nline two\r\n"},
+		{"bom with mixed to lf", fsm.Options{LineEndings: fsm.LineEndingLF}, bom + "a\r\nb\rc\n", bom + "a\nb\nc\n"},
+		{"no trailing space before crlf", fsm.Options{}, "word .  \r\nnext", "word.\r\nnext"},
+		{"collapse crlf blank lines", fsm.Options{CollapseBlankLines: true}, "one\r\n\r\n\r\n\r\ntwo", "one\r\n\r\ntwo"},
+		{"soft crlf break", fsm.Options{ParagraphBoundaries: true}, "a\r\napple", "an\r\napple"},
+		{"unwrap crlf", fsm.Options{UnwrapParagraphs: true}, "one\r\ntwo\r\n\r\nthree", "one two\r\n\r\nthree"},
+		{"wrap uses crlf", fsm.Options{WrapWidth: 7}, "one two three\r\nfour", "one two\r\nthree\r\nfour"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(tt.opts)
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestDetectLineEnding(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected fsm.LineEnding
+	}{
+		{"no line breaks", "hello", fsm.LineEndingLF},
+		{"lf", "a\nb\n", fsm.LineEndingLF},
+		{"crlf", "a\r\nb\r\n", fsm.LineEndingCRLF},
+		{"cr", "a\rb\r", fsm.LineEndingCR},
+		{"mostly crlf", "a\r\nb\r\nc\nd\r", fsm.LineEndingCRLF},
+		{"tie prefers lf", "a\r\nb\n", fsm.LineEndingLF},
+		{"bom", bom + "a\r\nb", fsm.LineEndingCRLF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fsm.DetectLineEnding(tt.input); got != tt.expected {
+				t.Errorf("DetectLineEnding(%q) = %v; want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseLineEnding(t *testing.T) {
+	for _, name := range []string{"preserve", "auto", "lf", "CRLF", "cr"} {
+		l, err := fsm.ParseLineEnding(name)
+		if err != nil {
+			t.Errorf("ParseLineEnding(%q) error: %v", name, err)
+			continue
+		}
+		if _, err := fsm.ParseLineEnding(l.String()); err != nil {
+			t.Errorf("ParseLineEnding(%q) doesn't round-trip: %v", l.String(), err)
+		}
+	}
+	if _, err := fsm.ParseLineEnding("unix"); err == nil {
+		t.Error("ParseLineEnding(\"unix\") should fail")
+	}
+}
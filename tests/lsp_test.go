@@ -0,0 +1,414 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-reloaded/fsm"
+	"go-reloaded/lsp"
+	"io"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ==================== LANGUAGE SERVER TESTS ====================
+
+const lspURI = "file:///notes.txt"
+
+// lspMessage is a message the server sent
+type lspMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// lspRequest frames a request, or a notification when id is 0
+func lspRequest(id int, method string, params any) string {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method}
+	if id != 0 {
+		msg["id"] = id
+	}
+	if params != nil {
+		msg["params"] = params
+	}
+	body, _ := json.Marshal(msg)
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// lspSession opens text, sends requests, shuts down, and returns what the
+// server sent
+func lspSession(t *testing.T, text string, requests ...string) []lspMessage {
+	t.Helper()
+	var out bytes.Buffer
+	if err := lsp.New(strings.NewReader(lspSessionInput(text, requests...)), &out, fsm.Options{}).Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	return readLSPMessages(t, &out)
+}
+
+// lspSessionInput frames a session that opens text, sends requests and
+// shuts down
+func lspSessionInput(text string, requests ...string) string {
+	return lspRequest(1, "initialize", map[string]any{"capabilities": map[string]any{}}) +
+		lspRequest(0, "initialized", map[string]any{}) +
+		lspRequest(0, "textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": lspURI, "languageId": "plaintext", "version": 1, "text": text},
+		}) +
+		strings.Join(requests, "") +
+		lspRequest(99, "shutdown", nil) +
+		lspRequest(0, "exit", nil)
+}
+
+func readLSPMessages(t *testing.T, out io.Reader) []lspMessage {
+	t.Helper()
+	var messages []lspMessage
+	r := bufio.NewReader(out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatalf("bad header: %v", err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatalf("short body: %v", err)
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("bad body %s: %v", body, err)
+		}
+		messages = append(messages, msg)
+	}
+}
+
+// lspResult decodes the answer to request id into v
+func lspResult(t *testing.T, messages []lspMessage, id int, v any) {
+	t.Helper()
+	for _, msg := range messages {
+		if msg.ID != nil && *msg.ID == id && msg.Method == "" {
+			if msg.Error != nil {
+				t.Fatalf("request %d failed with code %d", id, msg.Error.Code)
+			}
+			if err := json.Unmarshal(msg.Result, v); err != nil {
+				t.Fatalf("request %d result %s: %v", id, msg.Result, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no answer to request %d", id)
+}
+
+func lspPosition(line, character int) map[string]any {
+	return map[string]any{"line": line, "character": character}
+}
+
+func lspAt(id int, method string, line, character int) string {
+	return lspRequest(id, method, map[string]any{
+		"textDocument": map[string]any{"uri": lspURI},
+		"position":     lspPosition(line, character),
+	})
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	text := "(up) hi, ' open\nso (UP) x (cap, 1.5)"
+	messages := lspSession(t, text)
+
+	var published []lsp.PublishDiagnosticsParams
+	for _, msg := range messages {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params lsp.PublishDiagnosticsParams
+			json.Unmarshal(msg.Params, &params)
+			published = append(published, params)
+		}
+	}
+	if len(published) != 1 || published[0].URI != lspURI || published[0].Version != 1 {
+		t.Fatalf("published %+v; want one set for %s version 1", published, lspURI)
+	}
+
+	type expect struct {
+		code  string
+		start lsp.Position
+		end   lsp.Position
+	}
+	expected := []expect{
+		{"modifier-no-word", lsp.Position{Line: 0, Character: 0}, lsp.Position{Line: 0, Character: 4}},
+		{"unterminated-quote", lsp.Position{Line: 0, Character: 9}, lsp.Position{Line: 0, Character: 10}},
+		{"unknown-modifier", lsp.Position{Line: 1, Character: 3}, lsp.Position{Line: 1, Character: 7}},
+		{"malformed-modifier", lsp.Position{Line: 1, Character: 10}, lsp.Position{Line: 1, Character: 20}},
+	}
+	var got []expect
+	for _, d := range published[0].Diagnostics {
+		got = append(got, expect{d.Code, d.Range.Start, d.Range.End})
+		if d.Source != "go-reloaded" || d.Severity != lsp.SeverityWarning || d.Message == "" {
+			t.Errorf("diagnostic %+v lacks source, severity or message", d)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\nInput:    %q\nExpected: %+v\nGot:      %+v", text, expected, got)
+	}
+}
+
+func TestLSPStdoutHoldsOnlyMessages(t *testing.T) {
+	// The server writes to stdout, as "go-reloaded lsp" does: anything else
+	// printed there would break the framing
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	read := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		read <- data
+	}()
+
+	text := "a apple (up, 99999999999999999999)\nb (up, 0x1)"
+	input := lspSessionInput(text, lspAt(2, "textDocument/hover", 0, 10))
+	runErr := lsp.New(strings.NewReader(input), os.Stdout, fsm.Options{}).Run()
+	os.Stdout = stdout
+	w.Close()
+	data := <-read
+	if runErr != nil {
+		t.Fatalf("Run() = %v", runErr)
+	}
+
+	for rest := data; len(rest) > 0; {
+		header, body, ok := bytes.Cut(rest, []byte("\r\n\r\n"))
+		length, err := strconv.Atoi(strings.TrimPrefix(string(header), "Content-Length: "))
+		if !ok || !bytes.HasPrefix(header, []byte("Content-Length: ")) || err != nil || length > len(body) || !json.Valid(body[:length]) {
+			t.Fatalf("stdout holds more than framed messages: %q", rest)
+		}
+		rest = body[length:]
+	}
+	var codes []string
+	for _, msg := range readLSPMessages(t, bytes.NewReader(data)) {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params lsp.PublishDiagnosticsParams
+			json.Unmarshal(msg.Params, &params)
+			for _, d := range params.Diagnostics {
+				codes = append(codes, d.Code)
+			}
+		}
+	}
+	if expected := []string{"malformed-modifier", "malformed-modifier"}; !reflect.DeepEqual(codes, expected) {
+		t.Errorf("\nInput: %q\nExpected: %v\nGot: %v", text, expected, codes)
+	}
+}
+
+func TestLSPHover(t *testing.T) {
+	text := "hello big world (up, 2)\nso (UP) x\nword (cap) (up) it"
+	messages := lspSession(t, text,
+		lspAt(2, "textDocument/hover", 0, 18),
+		lspAt(3, "textDocument/hover", 0, 2),
+		lspAt(4, "textDocument/hover", 1, 5),
+		lspAt(5, "textDocument/hover", 2, 6),
+	)
+
+	var hover lsp.Hover
+	lspResult(t, messages, 2, &hover)
+	if !strings.Contains(hover.Contents.Value, "Uppercase") || !strings.Contains(hover.Contents.Value, "`big world` → `BIG WORLD`") {
+		t.Errorf("hover over (up, 2) = %q", hover.Contents.Value)
+	}
+	if hover.Range != (lsp.Range{Start: lsp.Position{Line: 0, Character: 16}, End: lsp.Position{Line: 0, Character: 23}}) {
+		t.Errorf("hover range = %+v", hover.Range)
+	}
+
+	var none *lsp.Hover
+	lspResult(t, messages, 3, &none)
+	if none != nil {
+		t.Errorf("hover over a word = %+v; want null", none)
+	}
+
+	var unknown lsp.Hover
+	lspResult(t, messages, 4, &unknown)
+	if !strings.Contains(unknown.Contents.Value, "not applied") || !strings.Contains(unknown.Contents.Value, "did you mean (up)?") {
+		t.Errorf("hover over (UP) = %q", unknown.Contents.Value)
+	}
+
+	// The preview is the processed word, (up) included
+	var chained lsp.Hover
+	lspResult(t, messages, 5, &chained)
+	if !strings.Contains(chained.Contents.Value, "`word` → `WORD`") {
+		t.Errorf("hover over a chained (cap) = %q", chained.Contents.Value)
+	}
+}
+
+func TestLSPCompletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		character int
+		labels    []string
+		newText   string
+		start     int
+	}{
+		{"after parenthesis", "word (", 6, []string{"up", "low", "cap", "hex", "bin"}, "up)", 6},
+		{"prefix", "word (c", 7, []string{"cap"}, "cap)", 6},
+		{"already closed", "word (l)", 7, []string{"low"}, "low", 6},
+		{"outside a modifier", "word lo", 7, nil, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := lspSession(t, tt.text, lspAt(2, "textDocument/completion", 0, tt.character))
+			var list lsp.CompletionList
+			lspResult(t, messages, 2, &list)
+			var labels []string
+			for _, item := range list.Items {
+				labels = append(labels, item.Label)
+			}
+			if !reflect.DeepEqual(labels, tt.labels) {
+				t.Fatalf("\nInput:    %q\nExpected: %v\nGot:      %v", tt.text, tt.labels, labels)
+			}
+			if len(list.Items) == 0 {
+				return
+			}
+			edit := list.Items[0].TextEdit
+			if edit.NewText != tt.newText || edit.Range.Start.Character != tt.start || edit.Range.End.Character != tt.character {
+				t.Errorf("first item edit = %+v; want %q from %d to %d", edit, tt.newText, tt.start, tt.character)
+			}
+			if list.Items[0].Documentation.Value == "" {
+				t.Error("completion item has no documentation")
+			}
+		})
+	}
+}
+
+func TestLSPCodeActions(t *testing.T) {
+	text := "it was a apple (up, 2) !\nso (UP) x"
+	codeAction := func(id, startLine, startChar, endLine, endChar int) string {
+		return lspRequest(id, "textDocument/codeAction", map[string]any{
+			"textDocument": map[string]any{"uri": lspURI},
+			"range":        map[string]any{"start": lspPosition(startLine, startChar), "end": lspPosition(endLine, endChar)},
+			"context":      map[string]any{"diagnostics": []any{}},
+		})
+	}
+	messages := lspSession(t, text, codeAction(2, 0, 17, 0, 17), codeAction(3, 1, 4, 1, 4))
+
+	edit := func(line, start, end int, newText string) lsp.TextEdit {
+		return lsp.TextEdit{Range: lsp.Range{Start: lsp.Position{Line: line, Character: start}, End: lsp.Position{Line: line, Character: end}}, NewText: newText}
+	}
+	var actions []lsp.CodeAction
+	lspResult(t, messages, 2, &actions)
+	if len(actions) != 2 {
+		t.Fatalf("got %d actions %+v; want the modifier and the whole document", len(actions), actions)
+	}
+	if actions[0].Title != "Apply (up, 2)" || actions[0].Kind != "refactor.rewrite" {
+		t.Errorf("first action = %q %q", actions[0].Title, actions[0].Kind)
+	}
+	expected := []lsp.TextEdit{edit(0, 7, 8, "A"), edit(0, 9, 14, "APPLE"), edit(0, 14, 22, "")}
+	if got := actions[0].Edit.Changes[lspURI]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("\nExpected: %+v\nGot:      %+v", expected, got)
+	}
+	whole := actions[1].Edit.Changes[lspURI]
+	if actions[1].Kind != "source.fixAll" || len(whole) != 1 || whole[0].NewText != "it was An APPLE!\nso (UP) x" ||
+		whole[0].Range.End != (lsp.Position{Line: 1, Character: 9}) {
+		t.Errorf("whole document action = %+v", actions[1])
+	}
+
+	lspResult(t, messages, 3, &actions)
+	if len(actions) != 2 || actions[0].Title != "Change to (up)" || actions[0].Kind != "quickfix" {
+		t.Fatalf("actions on (UP) = %+v", actions)
+	}
+	if got := actions[0].Edit.Changes[lspURI]; !reflect.DeepEqual(got, []lsp.TextEdit{edit(1, 3, 7, "(up)")}) {
+		t.Errorf("fix for (UP) = %+v", got)
+	}
+}
+
+func TestLSPCodeActionEditsOnlyTheTarget(t *testing.T) {
+	text := "First line stays.\nhe said \" ,(up)wow (cap) \""
+	messages := lspSession(t, text, lspRequest(2, "textDocument/codeAction", map[string]any{
+		"textDocument": map[string]any{"uri": lspURI},
+		"range":        map[string]any{"start": lspPosition(1, 20), "end": lspPosition(1, 20)},
+		"context":      map[string]any{"diagnostics": []any{}},
+	}))
+
+	var actions []lsp.CodeAction
+	lspResult(t, messages, 2, &actions)
+	if len(actions) == 0 || actions[0].Title != "Apply (cap)" {
+		t.Fatalf("actions = %+v; want Apply (cap) first", actions)
+	}
+	at := func(start, end int, newText string) lsp.TextEdit {
+		return lsp.TextEdit{Range: lsp.Range{Start: lsp.Position{Line: 1, Character: start}, End: lsp.Position{Line: 1, Character: end}}, NewText: newText}
+	}
+	expected := []lsp.TextEdit{at(15, 18, "Wow"), at(18, 24, "")}
+	if got := actions[0].Edit.Changes[lspURI]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("\nExpected: %+v\nGot:      %+v", expected, got)
+	}
+}
+
+func TestLSPPositionsCountUTF16(t *testing.T) {
+	// é is one UTF-16 unit and two bytes, 😀 two units and four bytes
+	text := "é😀 word (up)"
+	messages := lspSession(t, text, lspAt(2, "textDocument/hover", 0, 10))
+	var hover lsp.Hover
+	lspResult(t, messages, 2, &hover)
+	if !strings.Contains(hover.Contents.Value, "`word` → `WORD`") {
+		t.Errorf("hover = %q", hover.Contents.Value)
+	}
+	if expected := (lsp.Range{Start: lsp.Position{Line: 0, Character: 9}, End: lsp.Position{Line: 0, Character: 13}}); hover.Range != expected {
+		t.Errorf("hover range = %+v; want %+v", hover.Range, expected)
+	}
+}
+
+func TestLSPMessageTooLarge(t *testing.T) {
+	huge := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", 64<<20+1, strings.Repeat(" ", 64<<20+1))
+	messages := lspSession(t, "word (up)", huge, lspAt(2, "textDocument/hover", 0, 6))
+
+	rejected := false
+	for _, msg := range messages {
+		if msg.ID == nil && msg.Method == "" && msg.Error != nil && msg.Error.Code == -32600 {
+			rejected = true
+		}
+	}
+	if !rejected {
+		t.Error("a message over the size limit was not answered with an error")
+	}
+	var hover lsp.Hover
+	lspResult(t, messages, 2, &hover)
+	if !strings.Contains(hover.Contents.Value, "`word` → `WORD`") {
+		t.Errorf("hover after the rejected message = %q", hover.Contents.Value)
+	}
+}
+
+func TestLSPLifecycle(t *testing.T) {
+	input := lspRequest(1, "textDocument/hover", map[string]any{}) +
+		lspRequest(2, "initialize", map[string]any{}) +
+		lspRequest(3, "workspace/symbol", map[string]any{}) +
+		lspRequest(4, "textDocument/hover", "not params") +
+		lspRequest(5, "shutdown", nil) +
+		lspRequest(6, "textDocument/completion", map[string]any{}) +
+		lspRequest(0, "exit", nil)
+	var out bytes.Buffer
+	if err := lsp.New(strings.NewReader(input), &out, fsm.Options{}).Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	codes := map[int]int{}
+	for _, msg := range readLSPMessages(t, &out) {
+		if msg.Error != nil {
+			codes[*msg.ID] = msg.Error.Code
+		}
+	}
+	if expected := map[int]int{1: -32002, 3: -32601, 4: -32602, 6: -32600}; !reflect.DeepEqual(codes, expected) {
+		t.Errorf("error codes = %v; want %v", codes, expected)
+	}
+
+	err := lsp.New(strings.NewReader(lspRequest(1, "initialize", map[string]any{})+lspRequest(0, "exit", nil)), io.Discard, fsm.Options{}).Run()
+	if err == nil {
+		t.Error("exit without shutdown should fail")
+	}
+}
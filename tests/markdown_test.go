@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"go-reloaded/formats"
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== MARKDOWN FORMAT TESTS ====================
+
+func TestMarkdownFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"paragraph", "it is a apple , ok", "it is an apple, ok"},
+		{"code span kept", "run `go test ./... , now` , please", "run `go test ./... , now`, please"},
+		{"double backtick code span", "see `` a `b` , c `` here", "see `` a `b` , c `` here"},
+		{"modifier in code span ignored", "the `x (up)` value (up)", "the `x (up)` VALUE"},
+		{"fenced code kept", "a text , here\n\n```\nx , y (up)\n```\nafter , it", "a text, here\n\n```\nx , y (up)\n```\nafter, it"},
+		{"tilde fence kept", "~~~\na , b\n~~~", "~~~\na , b\n~~~"},
+		{"unclosed fence runs to the end", "```\na , b\n\nc , d", "```\na , b\n\nc , d"},
+		{"indented code kept", "para , one\n\n    code , kept\n    more (up)\n\npara , two", "para, one\n\n    code , kept\n    more (up)\n\npara, two"},
+		{"indented line inside paragraph", "line , one\n    line , two", "line, one\n    line, two"},
+		{"heading", "## hello world (up) ##", "## hello WORLD ##"},
+		{"heading without closing marks", "# a orange ,day", "# an orange, day"},
+		{"list items", "- a item (cap)\n- second ,one\n1. third", "- an Item\n- second, one\n1. third"},
+		{"task list", "- [ ] todo ,here\n- [x] done (up)", "- [ ] todo, here\n- [x] DONE"},
+		{"blockquote", "> a quote , here\n> and there !", "> a quote, here\n> and there!"},
+		{"nested blockquote and list", "> - item , one", "> - item, one"},
+		{"link target kept", "see [the docs , here](http://x.com/a,b) .", "see [the docs, here](http://x.com/a,b)."},
+		{"link title kept", `[a](http://x.com "a title , kept") !`, `[a](http://x.com "a title , kept")!`},
+		{"reference link", "see [docs][ref] .\n\n[ref]: http://x.com  \"t , t\"", "see [docs][ref].\n\n[ref]: http://x.com  \"t , t\""},
+		{"image", "look ![a cat](cat.png) !", "look ![a cat](cat.png)!"},
+		{"bare url", "go to https://go.dev/doc?q=a,b. now", "go to https://go.dev/doc?q=a,b. now"},
+		{"url in parentheses", "(see https://go.dev/x)", "(see https://go.dev/x)"},
+		{"autolink", "mail <me@x.com> , or <https://x.com> !", "mail <me@x.com>, or <https://x.com>!"},
+		{"inline html", "a <em>bold</em> move , <br/> ok", "a <em>bold</em> move, <br/> ok"},
+		{"html block kept", "<div>\nraw , text\n</div>\n\nafter , it", "<div>\nraw , text\n</div>\n\nafter, it"},
+		{"html comment kept", "<!-- a , b\nc , d -->\ntext , here", "<!-- a , b\nc , d -->\ntext, here"},
+		{"entity kept", "fish &amp;chips , &#169;2024", "fish &amp;chips, &#169;2024"},
+		{"escapes kept", `a \*literal\* star , ok`, `a \*literal\* star, ok`},
+		{"emphasis markers", "**bold** , *it* and _under_ !", "**bold**, *it* and _under_!"},
+		{"article in emphasis", "*a* apple", "*an* apple"},
+		{"article before emphasis", "a *apple* , ok", "an *apple*, ok"},
+		{"article before underscores", "a _honest_ man", "an _honest_ man"},
+		{"modifier through strong", "say **hello** (up)", "say **HELLO**"},
+		{"capitalize through emphasis", "***hello*** there (cap, 2)", "***Hello*** There"},
+		{"underscore inside a word", "snake_case , ok (up)", "snake_case, OK"},
+		{"spaced star is prose", "3 * 4 , ok", "3 * 4, ok"},
+		{"hard line break", "first line  \nsecond , line", "first line  \nsecond, line"},
+		{"backslash line break", "first line\\\nsecond , line", "first line\\\nsecond, line"},
+		{"thematic break", "a , b\n\n***\n\n- - -", "a, b\n\n***\n\n- - -"},
+		{"setext heading", "a title ,here\n===\n\nsub ,title\n---", "a title, here\n===\n\nsub, title\n---"},
+		{"table cells", "| a col , | b (up) |\n| --- | :-: |\n| it is a apple | x , y |", "| a col, | B |\n| --- | :-: |\n| it is an apple | x, y |"},
+		{"table escaped pipe", "| a \\| b , c |\n|---|\n| `x | y` , z |", "| a \\| b, c |\n|---|\n| `x | y`, z |"},
+		{"footnotes", "text[^1] .\n\n[^1]: the note , here", "text[^1].\n\n[^1]: the note, here"},
+		{"front matter kept", "---\ntitle: a , b\n---\ntext , here", "---\ntitle: a , b\n---\ntext, here"},
+		{"quotes in a list item", "- he said ' hello `x` ' ok", "- he said 'hello `x`' ok"},
+		{"crlf kept", "# a title\r\n\r\ntext , here\r\n", "# a title\r\n\r\ntext, here\r\n"},
+		{"placeholder range runes kept", "a \U000F0000 b , c", "a \U000F0000 b, c"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.Markdown, tt.input, formats.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestMarkdownDiagnosticPositions(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		line   int
+		column int
+	}{
+		{"after code span", "see `x` and ' open", 1, 13},
+		{"in list item", "intro\n\n- `a` b ' c", 3, 9},
+		{"in blockquote continuation", "> first\n> then `x` ' here", 2, 12},
+		{"in table cell", "| a | b |\n|---|---|\n| `c` | ' d |", 3, 9},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.Markdown, tt.input, formats.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Diagnostics) != 1 {
+				t.Fatalf("expected 1 diagnostic, got %v", result.Diagnostics)
+			}
+			d := result.Diagnostics[0]
+			if d.Line != tt.line || d.Column != tt.column {
+				t.Errorf("expected %d:%d, got %d:%d", tt.line, tt.column, d.Line, d.Column)
+			}
+		})
+	}
+}
+
+func TestMarkdownWrapKeepsTables(t *testing.T) {
+	input := "| a long cell with many words |\n|---|\n\na long paragraph with many words"
+	expected := "| a long cell with many words |\n|---|\n\na long\nparagraph\nwith many\nwords"
+
+	processor := fsm.NewProcessorWithOptions(fsm.Options{WrapWidth: 10})
+	result, err := formats.Process(processor, formats.Markdown, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Text != expected {
+		t.Errorf("\nExpected: %q\nGot:      %q", expected, result.Text)
+	}
+}
+
+func TestTextFormatMatchesProcessor(t *testing.T) {
+	input := "  it is a apple , ok `x` \n"
+	processor := fsm.NewProcessor()
+	result, err := formats.Process(processor, formats.Text, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := fsm.NewProcessor().Process(input); result.Text != expected {
+		t.Errorf("\nExpected: %q\nGot:      %q", expected, result.Text)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    formats.Format
+		wantErr bool
+	}{
+		{"text", formats.Text, false},
+		{"markdown", formats.Markdown, false},
+		{"MD", formats.Markdown, false},
+		{"rst", formats.Text, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formats.ParseFormat(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
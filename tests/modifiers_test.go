@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"go-reloaded/fsm"
+	"reflect"
+	"testing"
+)
+
+// ==================== MODIFIER REGISTRY AND DIAGNOSTICS TESTS ====================
+
+func TestModifierRegistry(t *testing.T) {
+	var names []string
+	for _, m := range fsm.Modifiers() {
+		names = append(names, m.Name)
+	}
+	if expected := []string{"up", "low", "cap", "hex", "bin"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Modifiers() = %v; want %v", names, expected)
+	}
+	up, ok := fsm.LookupModifier("up")
+	if !ok || !up.Counted || up.Apply("go") != "GO" {
+		t.Errorf("LookupModifier(\"up\") = %+v, %v", up, ok)
+	}
+	if hex, _ := fsm.LookupModifier("hex"); hex.Counted || hex.Apply("1E") != "30" {
+		t.Errorf("LookupModifier(\"hex\") = %+v", hex)
+	}
+	if _, ok := fsm.LookupModifier("UP"); ok {
+		t.Error("LookupModifier(\"UP\") found a modifier; names are lowercase")
+	}
+}
+
+func TestAppliedModifiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []fsm.AppliedModifier
+	}{
+		{"counted", "hello big world (up, 2) ok", []fsm.AppliedModifier{
+			{Span: fsm.Span{Offset: 16, End: 23}, Name: "up", Targets: []fsm.Span{{Offset: 6, End: 9}, {Offset: 10, End: 15}}, Results: []string{"BIG", "WORLD"}},
+		}},
+		{"hex and chain", "1E (hex) it (up) (cap)", []fsm.AppliedModifier{
+			{Span: fsm.Span{Offset: 3, End: 8}, Name: "hex", Targets: []fsm.Span{{Offset: 0, End: 2}}, Results: []string{"30"}},
+			{Span: fsm.Span{Offset: 12, End: 16}, Name: "up", Targets: []fsm.Span{{Offset: 9, End: 11}}, Results: []string{"IT"}},
+			{Span: fsm.Span{Offset: 17, End: 22}, Name: "cap", Targets: []fsm.Span{{Offset: 9, End: 11}}, Results: []string{"IT"}},
+		}},
+		{"whole quote", "' a b ' (cap, q)", []fsm.AppliedModifier{
+			{Span: fsm.Span{Offset: 8, End: 16}, Name: "cap", Targets: []fsm.Span{{Offset: 2, End: 3}, {Offset: 4, End: 5}}, Results: []string{"A", "B"}},
+		}},
+		{"glued word", "(it's) (up)", []fsm.AppliedModifier{
+			{Span: fsm.Span{Offset: 7, End: 11}, Name: "up", Targets: []fsm.Span{{Offset: 1, End: 5}}, Results: []string{"IT'S"}},
+		}},
+		{"article fixed after", "a (cap) apple", []fsm.AppliedModifier{
+			{Span: fsm.Span{Offset: 2, End: 7}, Name: "cap", Targets: []fsm.Span{{Offset: 0, End: 1}}, Results: []string{"An"}},
+		}},
+		{"glued after quoted punctuation", "one.\nhe said \" ,(up)wow (cap) \"", []fsm.AppliedModifier{
+			{Span: fsm.Span{Offset: 16, End: 20}, Name: "up"},
+			{Span: fsm.Span{Offset: 24, End: 29}, Name: "cap", Targets: []fsm.Span{{Offset: 20, End: 23}}, Results: []string{",wow"}},
+		}},
+		{"kept as text", "(up) first", nil},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor.Process(tt.input)
+			if got := processor.AppliedModifiers(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("\nInput:    %q\nExpected: %+v\nGot:      %+v", tt.input, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestModifierDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		codes    []string
+		columns  []int
+	}{
+		{"no word", "(up) hello", "(up) hello", []string{"modifier-no-word"}, []int{1}},
+		{"no word after a flush", "done. (cap) next", "done. (cap) next", []string{"modifier-no-word"}, []int{7}},
+		{"wrong case", "so (UP) it", "so (UP) it", []string{"unknown-modifier"}, []int{4}},
+		{"wrong case with count", "a b (Cap, 2)", "a b (Cap, 2)", []string{"unknown-modifier"}, []int{5}},
+		{"letter count", "a b (up, x2)", "a b (up, x2)", []string{"malformed-modifier"}, []int{5}},
+		{"empty count", "a b (low,)", "a b (low,)", []string{"malformed-modifier"}, []int{5}},
+		{"quote in capitals", "' a ' (up, Q)", "'a' (up, Q)", []string{"malformed-modifier"}, []int{7}},
+		{"prose in brackets", "prices (low, high) and (see) more (up, top ten)", "prices (low, high) and (see) more (up, top ten)", nil, nil},
+		{"applied", "go (up)", "GO", nil, nil},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+			diags := processor.Diagnostics()
+			if len(diags) != len(tt.codes) {
+				t.Fatalf("Process(%q) diagnostics = %v; want %v", tt.input, diags, tt.codes)
+			}
+			for i, d := range diags {
+				if d.Code != tt.codes[i] || d.Column != tt.columns[i] {
+					t.Errorf("diagnostic %d = %+v; want %s at column %d", i, d, tt.codes[i], tt.columns[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"go-reloaded/fsm"
+	"testing"
+	"unicode"
+)
+
+// ==================== OPAQUE TOKEN TESTS ====================
+
+func TestOpaqueTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"currency and percent", "Price: $5 & 10% off (50%)", "Price: $5 & 10% off (50%)"},
+		{"hashtags and mentions", "#hashtags and @mentions !", "#hashtags and @mentions!"},
+		{"emoji", "great job 🙂 , team 🎉", "great job 🙂, team 🎉"},
+		{"dash", "wait — what ?", "wait — what?"},
+		{"math", "3+4=7 , right ?", "3+4=7, right?"},
+		{"brackets", "see [1] and {2}", "see [1] and {2}"},
+		{"inside quotes", "he paid ' $5 ' today", "he paid '$5' today"},
+		{"modifier on glued word", "the #tag (up) trends", "the #TAG trends"},
+		{"opaque after modifier", "1F (hex)% done", "31% done"},
+		{"opaque before modifier target", "costs $ 10 (bin)", "costs $ 2"},
+		{"invalid utf8 kept", "bad \xff byte", "bad \xff byte"},
+		{"hex after a leading symbol", "$1F (hex)", "$31"},
+		{"capitalize after a leading symbol", "@bob (cap)", "@Bob"},
+		{"article before emphasis", "a *honest* man", "an *honest* man"},
+		{"article before a numbered item", "a #1 apple", "a #1 apple"},
+		{"article before a leading emoji", "a 🙂apple", "an 🙂apple"},
+		{"modifier over trailing symbols", "hi🙂 there (up, 2)", "HI🙂 THERE"},
+		{"symbol inside a word", "AT&T (low)", "at&t"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+// opaqueCorpus holds text without modifiers or articles to fix,
+// so every non-blank character of the input must reach the output.
+var opaqueCorpus = []string{
+	"Price: $5 & 10% off (50%)",
+	"Email me @ support@example.com or call +1 (555) 010-9999 .",
+	"Totals: €12,50 | £9.99 | ¥1000 | ₹300",
+	"if (x > 0 && y < 10) { return x * y / 2 ; }",
+	"Follow #golang and #100DaysOfCode — it's fun ! ! 🎉🎉",
+	"path/to/file.txt ~ ^ ` | \\ < >",
+	"[draft] {todo} <note> « quoted » ¡hola! ¿qué?",
+	"emoji 👩‍💻 and flags 🇬🇷 stay intact",
+	"tabs\tand  spaces , then\nnew lines ; done .",
+	"he said ' this quote \" never closes\nand 'this one too",
+}
+
+func TestOpaqueCorpusNoDataLoss(t *testing.T) {
+	processor := fsm.NewProcessor()
+	for _, input := range opaqueCorpus {
+		result := processor.Process(input)
+		want := countNonBlankRunes(input)
+		got := countNonBlankRunes(result)
+		for r, n := range want {
+			if got[r] != n {
+				t.Errorf("Process(%q) = %q\nrune %q: want %d occurrences, got %d", input, result, r, n, got[r])
+			}
+		}
+		for r, n := range got {
+			if _, ok := want[r]; !ok {
+				t.Errorf("Process(%q) = %q\nunexpected rune %q (%d occurrences)", input, result, r, n)
+			}
+		}
+	}
+}
+
+func TestGluedRunsScaleLinearly(t *testing.T) {
+	// Every token glued onto a word used to copy the whole word again
+	for _, unit := range []string{"$b", "$$b", "–y", "(b"} {
+		t.Run(unit, func(t *testing.T) {
+			checkLinear(t, unit, fsm.Options{})
+		})
+	}
+}
+
+func countNonBlankRunes(s string) map[rune]int {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			counts[r]++
+		}
+	}
+	return counts
+}
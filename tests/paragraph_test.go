@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== PARAGRAPH TESTS ====================
+
+func TestParagraphs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     fsm.Options
+		input    string
+		expected string
+	}{
+		// Default: every line break is a hard boundary
+		{"default line is boundary for modifiers", fsm.Options{}, "hello\nworld (up, 2)", "hello\nWORLD"},
+		{"default line is boundary for articles", fsm.Options{}, "a\napple", "a\napple"},
+		{"default keeps blank lines", fsm.Options{}, "one\n\n\n\ntwo", "one\n\n\n\ntwo"},
+
+		// Collapsing blank lines
+		{"collapse blank lines", fsm.Options{CollapseBlankLines: true}, "one\n\n\n\ntwo\n\nthree", "one\n\ntwo\n\nthree"},
+		{"collapse whitespace-only lines", fsm.Options{CollapseBlankLines: true}, "one\n  \n\t\n\ntwo", "one\n\ntwo"},
+
+		// Paragraph scope: soft line breaks, hard blank lines
+		{"modifier crosses line break", fsm.Options{ParagraphBoundaries: true}, "hello\nworld (up, 2)", "HELLO\nWORLD"},
+		{"article crosses line break", fsm.Options{ParagraphBoundaries: true}, "a\napple", "an\napple"},
+		{"modifier stops at blank line", fsm.Options{ParagraphBoundaries: true}, "hello\n\nworld (up, 2)", "hello\n\nWORLD"},
+		{"article stops at blank line", fsm.Options{ParagraphBoundaries: true}, "a\n\napple", "a\n\napple"},
+		{"quote spans wrapped line", fsm.Options{ParagraphBoundaries: true}, "' hello\nthere ' ok", "'hello\nthere' ok"},
+		{"punctuation opening a line", fsm.Options{ParagraphBoundaries: true}, "x\n, y", "x\n, y"},
+		{"modifier after line break", fsm.Options{ParagraphBoundaries: true}, "line\n(up) next", "LINE\nnext"},
+		{"indentation with preserve", fsm.Options{ParagraphBoundaries: true, PreserveWhitespace: true}, "a\n    apple", "an\n    apple"},
+
+		// Unwrapping and reflowing
+		{"unwrap paragraph", fsm.Options{UnwrapParagraphs: true}, "this is a\nhard wrapped\nparagraph .\n\nnext one", "this is a hard wrapped paragraph.\n\nnext one"},
+		{"unwrap punctuation", fsm.Options{UnwrapParagraphs: true}, "x\n, y", "x, y"},
+		{"reflow to width", fsm.Options{UnwrapParagraphs: true, WrapWidth: 12}, "this is a long\nhard wrapped\nparagraph .", "this is a\nlong hard\nwrapped\nparagraph."},
+		{"wrap never splits words", fsm.Options{WrapWidth: 4}, "extraordinary tale", "extraordinary\ntale"},
+		{"trailing newline kept", fsm.Options{UnwrapParagraphs: true}, "end\n", "end\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(tt.opts)
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"fmt"
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ==================== PARALLEL PROCESSING TESTS ====================
+//
+// A large input processed with Workers must give the same text,
+// diagnostics, explanations, statistics and modifier spans as the
+// sequential run, wherever the cuts fall.
+
+// parallelParagraphs each end up at a cut somewhere in the big inputs
+var parallelParagraphs = []string{
+	"it (cap) was a amazing day , the sun was shining !\n\n",
+	"she ate a\n\napple , then a\n\n  \t\n\nhonest meal .\n\n",
+	"he said ' hello there\nand left (up, 3)\n\n\n\n",
+	"  indented a\n  line (cap, 2)\n\n",
+	"FF (hex) and 101 (bin) , wow !!!! ok...\r\n\r\nnext (UP) a (up, x2)\r\n\r\n",
+	"a modifier (up\n\n) spanning lines\n\n",
+	"\ufeffnot a BOM here , a\n\n",
+	"¿ que pasa ? ! « bonjour » ; non\n\n",
+	"invalid \xff\xfe byte , a\n\n\n",
+	"é \u0301combining a\n\nunusual stuff\n\n",
+	"he said \" ,(up)wow (cap) \" and ' ;it (up) '\n\n",
+}
+
+// bigParallelInput repeats paragraphs in a shifting order until the input
+// is about size bytes
+func bigParallelInput(size int) string {
+	var sb strings.Builder
+	for i := 0; sb.Len() < size; i++ {
+		sb.WriteString(parallelParagraphs[(i*7+i/len(parallelParagraphs))%len(parallelParagraphs)])
+	}
+	return sb.String()
+}
+
+func parallelOptions() map[string]fsm.Options {
+	fr, _ := formatters.ParseProfile("fr")
+	es, _ := formatters.ParseProfile("es")
+	explain := fsm.Options{Explain: true, SmartQuotes: true}
+	explain.Punctuation.CollapseRepeats = true
+	explain.Punctuation.Ellipsis = "…"
+	return map[string]fsm.Options{
+		"default":          {},
+		"preserve":         {PreserveWhitespace: true, CollapseBlankLines: true},
+		"paragraphs":       {ParagraphBoundaries: true, QuoteRecovery: fsm.QuoteCloseParagraph},
+		"unwrap and wrap":  {UnwrapParagraphs: true, WrapWidth: 30},
+		"wrap preserve":    {WrapWidth: 25},
+		"auto line ending": {LineEndings: fsm.LineEndingAuto, QuoteRecovery: fsm.QuoteCloseLine},
+		"crlf":             {LineEndings: fsm.LineEndingCRLF, CollapseBlankLines: true},
+		"nfc":              {Normalization: fsm.NormalizeNFC},
+		"nfd strip bom":    {Normalization: fsm.NormalizeNFD, StripBOM: true},
+		"explain":          explain,
+		"french":           {Profile: fr, Explain: true},
+		"spanish":          {Profile: es},
+	}
+}
+
+func TestParallelMatchesSequential(t *testing.T) {
+	inputs := map[string]string{
+		"mixed":    bigParallelInput(600 << 10),
+		"with bom": "\ufeff" + bigParallelInput(300<<10),
+		"a at every cut": strings.Repeat("it was a\n\napple pie a\n\n", 20000) +
+			strings.Repeat("x a\n\n\"apple\" a\n\n(up) honest\n\n", 10000),
+	}
+	for name, opts := range parallelOptions() {
+		for inputName, input := range inputs {
+			t.Run(name+"/"+inputName, func(t *testing.T) {
+				checkParallel(t, input, opts)
+			})
+		}
+	}
+}
+
+// checkParallel fails t unless a run of input with four workers gives
+// the same result as the sequential run
+func checkParallel(t *testing.T, input string, opts fsm.Options) {
+	t.Helper()
+	expected := fsm.Process(input, opts)
+	parallel := opts
+	parallel.Workers = 4
+	got := fsm.Process(input, parallel)
+	if got.Text != expected.Text {
+		i := 0
+		for i < len(got.Text) && i < len(expected.Text) && got.Text[i] == expected.Text[i] {
+			i++
+		}
+		lo := max(0, i-40)
+		t.Fatalf("output differs at byte %d\nExpected: %q\nGot:      %q", i,
+			expected.Text[lo:min(len(expected.Text), i+40)], got.Text[lo:min(len(got.Text), i+40)])
+	}
+	if !reflect.DeepEqual(got.Diagnostics, expected.Diagnostics) {
+		t.Errorf("diagnostics differ: %d sequential, %d parallel", len(expected.Diagnostics), len(got.Diagnostics))
+	}
+	if !reflect.DeepEqual(got.Explanations, expected.Explanations) {
+		t.Errorf("explanations differ: %d sequential, %d parallel", len(expected.Explanations), len(got.Explanations))
+	}
+	if !reflect.DeepEqual(got.Stats, expected.Stats) {
+		t.Errorf("stats differ:\nExpected: %+v\nGot:      %+v", expected.Stats, got.Stats)
+	}
+	if !reflect.DeepEqual(got.Modifiers, expected.Modifiers) {
+		t.Errorf("applied modifiers differ: %d sequential, %d parallel", len(expected.Modifiers), len(got.Modifiers))
+	}
+}
+
+// parallelPieces are what randomParagraphs builds paragraphs from
+var parallelPieces = []string{
+	"word", "a", "apple", "honest", "it", "1E", "101", "(up)", "(low)", "(cap)", "(hex)", "(bin)",
+	"(up, 2)", "(cap, q)", "(up", ")", ",", ";", "!!", "?", "...", "—", "-", "'", "\"", "«", "»",
+	"(", "[", "]", "$5", "AT&T", "don't", "\u00a0", "\t", "  ", "\n", "\r\n", "\ufeff",
+}
+
+// randomParagraphs joins random paragraphs of pieces until the input is
+// about size bytes, with blank lines of random kinds between them
+func randomParagraphs(rng *rand.Rand, size int) string {
+	var sb strings.Builder
+	for sb.Len() < size {
+		for n := 1 + rng.Intn(30); n > 0; n-- {
+			sb.WriteString(parallelPieces[rng.Intn(len(parallelPieces))])
+			if rng.Intn(3) > 0 {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString([]string{"\n\n", "\n\n\n", "\r\n\r\n", "\n \t\n"}[rng.Intn(4)])
+	}
+	return sb.String()
+}
+
+func TestParallelMatchesSequentialRandom(t *testing.T) {
+	const seed = 20261019
+	rng := rand.New(rand.NewSource(seed))
+	options := parallelOptions()
+	for _, name := range []string{"default", "preserve", "paragraphs", "unwrap and wrap", "explain", "french"} {
+		for i := 0; i < 3; i++ {
+			input := randomParagraphs(rng, 200<<10)
+			t.Run(fmt.Sprintf("%s/%d", name, i), func(t *testing.T) {
+				checkParallel(t, input, options[name])
+			})
+		}
+	}
+}
+
+func TestParallelArticleAtChunkEdges(t *testing.T) {
+	// Every blank line is a possible cut: a/an must see the same next
+	// word, or the same line break, as it does sequentially
+	tests := []struct {
+		name     string
+		opts     fsm.Options
+		unit     string
+		expected string
+	}{
+		{"blank line stops a/an", fsm.Options{}, "a\n\napple a\n\n", "a\n\napple a\n\n"},
+		{"soft line reaches", fsm.Options{ParagraphBoundaries: true}, "a\napple a\n\n", "an\napple a\n\n"},
+		{"quote stops at the blank line", fsm.Options{}, "' a\n\napple ' a\n\n", "' a\n\napple ' a\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.Repeat(tt.unit, (300<<10)/len(tt.unit))
+			opts := tt.opts
+			opts.Workers = 8
+			processor := fsm.NewProcessorWithOptions(opts)
+			result := processor.Process(input)
+			if expected := strings.Repeat(tt.expected, (300<<10)/len(tt.unit)); result != expected {
+				t.Errorf("\nInput:    %q...\nExpected: %q...\nGot:      %q...", input[:40], expected[:40], result[:40])
+			}
+			if sequential := fsm.NewProcessorWithOptions(tt.opts).Process(input); result != sequential {
+				t.Error("parallel output differs from sequential output")
+			}
+		})
+	}
+}
+
+func TestParallelSmallInputStaysSequential(t *testing.T) {
+	input := "it was a\n\napple (up) , ' open"
+	processor := fsm.NewProcessorWithOptions(fsm.Options{Workers: 8})
+	if result, expected := processor.Process(input), fsm.NewProcessor().Process(input); result != expected {
+		t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", input, expected, result)
+	}
+	if len(processor.Diagnostics()) != 1 || processor.Diagnostics()[0].Line != 3 || processor.Diagnostics()[0].Column != 14 {
+		t.Errorf("diagnostics = %v", processor.Diagnostics())
+	}
+}
+
+func TestParallelProcessorReuse(t *testing.T) {
+	// A parallel run followed by sequential ones on the same Processor
+	processor := fsm.NewProcessorWithOptions(fsm.Options{Workers: 3})
+	big := bigParallelInput(400 << 10)
+	expected := fsm.Process(big, fsm.Options{})
+	for i := 0; i < 3; i++ {
+		if processor.Process(big) != expected.Text {
+			t.Fatalf("run %d: parallel output differs", i)
+		}
+		if got := processor.Process("a apple (up) ."); got != "an APPLE." {
+			t.Fatalf("run %d: small input gave %q", i, got)
+		}
+	}
+}
+
+func BenchmarkProcessWorkers(b *testing.B) {
+	input := buildBenchInput(10 << 20)
+	input = strings.ReplaceAll(input, "\n", "\n\n")
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%dworkers", workers), func(b *testing.B) {
+			processor := fsm.NewProcessorWithOptions(fsm.Options{Workers: workers})
+			b.SetBytes(int64(len(input)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				processor.Process(input)
+			}
+		})
+	}
+}
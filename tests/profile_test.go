@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== LANGUAGE PROFILE TESTS ====================
+
+const (
+	nbsp  = "\u00A0"
+	nnbsp = "\u202F"
+)
+
+func TestGolden_Profiles(t *testing.T) {
+	tests := []struct {
+		lang     string
+		input    string
+		expected string
+	}{
+		{
+			"en",
+			`it (cap) said : ' a honest answer ? ' yes ! « quoted » ( an aside ) ; done .`,
+			`It said: 'an honest answer?' yes! «quoted» (an aside); done.`,
+		},
+		{
+			"fr",
+			`il (cap) a dit : « bonjour » ! vraiment ? oui ; ( bien sûr ) , merci .`,
+			`Il a dit` + nbsp + `: «` + nbsp + `bonjour` + nbsp + `»` + nnbsp + `! vraiment` + nnbsp + `? oui` + nnbsp + `; (bien sûr), merci.`,
+		},
+		{
+			"es",
+			`¿ qué (up) hora es ? ¡ hola ! el perro , dijo : ' ¿ sí ? ' .`,
+			`¿QUÉ hora es? ¡hola! el perro, dijo: '¿sí?'.`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			profile, err := formatters.ParseProfile(tt.lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			processor := fsm.NewProcessorWithOptions(fsm.Options{Profile: profile})
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+			if diags := processor.Diagnostics(); len(diags) != 0 {
+				t.Errorf("unexpected diagnostics: %v", diags)
+			}
+		})
+	}
+}
+
+func TestFrenchProfileSpacing(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"question", "vraiment ?", "vraiment" + nnbsp + "?"},
+		{"group", "quoi ?!", "quoi" + nnbsp + "?!"},
+		{"comma unchanged", "oui , non .", "oui, non."},
+		{"colon", "note : rien", "note" + nbsp + ": rien"},
+		{"inside quote", "' quoi ? '", "'quoi" + nnbsp + "?'"},
+		{"after quote", "' quoi ' ?", "'quoi'" + nnbsp + "?"},
+		{"guillemets", "«bonjour»", "«" + nbsp + "bonjour" + nbsp + "»"},
+		{"line start", "? non", "? non"},
+	}
+
+	profile, _ := formatters.ParseProfile("fr")
+	processor := fsm.NewProcessorWithOptions(fsm.Options{Profile: profile})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSpanishMissingInvertedMark(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns []int
+	}{
+		{"balanced", "¿ qué ? ¡ sí !", nil},
+		{"missing question opener", "qué hora es ?", []int{13}},
+		{"missing exclamation opener", "¿ qué ? hola !", []int{14}},
+		{"opener used up by sentence", "¿ qué ? bien ?", []int{14}},
+		{"new line starts over", "¿ qué\nbien ?", []int{6}},
+	}
+
+	profile, _ := formatters.ParseProfile("es")
+	processor := fsm.NewProcessorWithOptions(fsm.Options{Profile: profile})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor.Process(tt.input)
+			diags := processor.Diagnostics()
+			if len(diags) != len(tt.columns) {
+				t.Fatalf("Process(%q) diagnostics = %v; want columns %v", tt.input, diags, tt.columns)
+			}
+			for i, d := range diags {
+				if d.Code != "missing-inverted-mark" || d.Column != tt.columns[i] {
+					t.Errorf("diagnostic %d = %+v; want missing-inverted-mark at column %d", i, d, tt.columns[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	for _, name := range []string{"", "en", "fr", "es", "de", "FR"} {
+		if _, err := formatters.ParseProfile(name); err != nil {
+			t.Errorf("ParseProfile(%q) error: %v", name, err)
+		}
+	}
+	if _, err := formatters.ParseProfile("xx"); err == nil {
+		t.Error("ParseProfile(\"xx\") = nil error; want unknown language")
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== EXTENDED PUNCTUATION TESTS ====================
+
+func TestExtendedPunctuation(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		// Brackets hug the words they wrap
+		{"parentheses", "see ( two words ) here", "see (two words) here"},
+		{"square brackets", "see [ note ] here", "see [note] here"},
+		{"braces", "a { b c } d", "a {b c} d"},
+		{"empty brackets", "x ( ) y", "x () y"},
+		{"call", "call f() now", "call f() now"},
+		{"call with argument", "call f(x) now", "call f(x) now"},
+		{"bracket then comma", "one ( two three ) , four", "one (two three), four"},
+		{"percent in brackets", "off (50%) today", "off (50%) today"},
+		{"one word in parentheses", "a ( note ) b", "a (note) b"},
+		{"one word hugged by parentheses", "see (note) here", "see (note) here"},
+		{"word and count in parentheses", "a ( note, 3 ) b", "a (note, 3) b"},
+		{"modifier after one word in parentheses", "x ( note ) (up)", "x (NOTE)"},
+		{"article before one word in parentheses", "a ( apple ) here", "an (apple) here"},
+
+		// Brackets aren't boundaries: modifiers reach inside them
+		{"modifier after brackets", "(hello world) (up, 2)", "(HELLO WORLD)"},
+		{"modifier into brackets", "say [ hi there ] (cap, 2)", "say [Hi There]"},
+		{"article before bracket", "a [ apple ] here", "an [apple] here"},
+
+		// Dashes stand alone, unless they join two words
+		{"em dash half glued", "wait— what", "wait — what"},
+		{"en dash", "pages 1 – 5", "pages 1 – 5"},
+		{"dash is a boundary", "one two — three (up, 2)", "one two — THREE"},
+		{"em dash joining words", "wait—what", "wait—what"},
+		{"en dash range", "pages 10–20 , ok", "pages 10–20, ok"},
+		{"compound word", "a well—known fact", "a well—known fact"},
+		{"dash range in quote", "read ' pages 10–20 ' now", "read 'pages 10–20' now"},
+		{"modifier over joined words", "it is well—known (up)", "it is WELL—KNOWN"},
+
+		// Ellipsis character and interrobang attach left
+		{"ellipsis character", "so … fine", "so… fine"},
+		{"interrobang", "what ‽ ok", "what‽ ok"},
+		{"mixed group", "really ?… sure", "really?… sure"},
+
+		// Inverted marks hug the next word
+		{"inverted question", "¿ qué ?", "¿qué?"},
+		{"inverted exclamation", "¡ hola !", "¡hola!"},
+
+		// Inside quotes
+		{"brackets in quote", "he said ' hi [ there ] ' ok", "he said 'hi [there]' ok"},
+		{"quote in brackets", "(' quoted ' ) ok", "('quoted') ok"},
+		{"dangling opener", "end (", "end ("},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestPunctuationNormalizationExplained(t *testing.T) {
+	opts := fsm.Options{
+		Punctuation: formatters.PunctuationOptions{Ellipsis: "…", CollapseRepeats: true, Interrobang: "‽", DropComma: true},
+		Explain:     true,
+	}
+	input := "wait .... what !!!!\nreally ?! ' no ,. '"
+	expected := "wait… what!\nreally‽ 'no.'"
+
+	processor := fsm.NewProcessorWithOptions(opts)
+	result := processor.Process(input)
+	if result != expected {
+		t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", input, expected, result)
+	}
+
+	want := []string{
+		`1:6: ellipsis: "...." -> "…"`,
+		`1:16: collapse-repeats: "!!!!" -> "!"`,
+		`2:8: interrobang: "?!" -> "‽"`,
+		`2:16: drop-comma: ",." -> "."`,
+	}
+	explanations := processor.Explain()
+	if len(explanations) != len(want) {
+		t.Fatalf("Explain() = %v; want %v", explanations, want)
+	}
+	for i, e := range explanations {
+		if e.String() != want[i] {
+			t.Errorf("Explain()[%d] = %s; want %s", i, e, want[i])
+		}
+	}
+
+	// Without Explain nothing is recorded, but the text is the same
+	opts.Explain = false
+	processor = fsm.NewProcessorWithOptions(opts)
+	if result := processor.Process(input); result != expected {
+		t.Errorf("without Explain got %q; want %q", result, expected)
+	}
+	if explanations := processor.Explain(); len(explanations) != 0 {
+		t.Errorf("without Explain, Explain() = %v; want none", explanations)
+	}
+}
@@ -0,0 +1,375 @@
+package tests
+
+import (
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ==================== NESTED QUOTE TESTS ====================
+
+func TestNestedQuotes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"single inside double", `" he said ' hi ' to me "`, `"he said 'hi' to me"`},
+		{"double inside single", `' she wrote " yes " twice '`, `'she wrote "yes" twice'`},
+		{"three levels", `" a ' b " c " d ' e "`, `"a 'b "c" d' e"`},
+		{"nested at the edges", `" ' hi ' "`, `"'hi'"`},
+		{"modifier inside inner quote", `" he said ' hi there (up) ' ok "`, `"he said 'hi THERE' ok"`},
+		{"modifier stays in its level", `" he said ' hi ' (up, 3) to me "`, `"HE SAID 'HI' to me"`},
+		{"modifier after nested quotes", `" he said ' hi ' " (cap, 3)`, `"He Said 'Hi'"`},
+		{"article inside inner quote", `" he saw ' a owl ' "`, `"he saw 'an owl'"`},
+		{"article into inner quote", `" a ' apple ' "`, `"an 'apple'"`},
+		{"punctuation after inner quote", `" he said ' run ' . " ok`, `"he said 'run'." ok`},
+		{"plain quotes unchanged", `' single ' and " double "`, `'single' and "double"`},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestApostrophes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"leading elision", "'tis the season", "'tis the season"},
+		{"twas", "'Twas the night", "'Twas the night"},
+		{"rock n roll", "rock 'n' roll", "rock 'n' roll"},
+		{"plural possessive", "the students' books", "the students' books"},
+		{"decade", "music of the '90s was great", "music of the '90s was great"},
+		{"dropped g", "he was goin' home", "he was goin' home"},
+		{"possessive inside quote", "' the students' books are here '", "'the students' books are here'"},
+		{"dropped g closing a quote", "' i was goin' '", "'i was goin''"},
+		{"quote ending in s", "' i like cats '", "'i like cats'"},
+		{"glued quote ending in s", "he said 'i like cats' today", "he said 'i like cats' today"},
+		{"glued quote", "'hello world'", "'hello world'"},
+		{"elision inside quote", "' 'tis true '", "''tis true'"},
+		{"modifier over possessive", "the kids' toys (up, 2)", "the KIDS' TOYS"},
+		{"contraction", "don't ' stop ' now", "don't 'stop' now"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+			if diags := processor.Diagnostics(); len(diags) != 0 {
+				t.Errorf("Process(%q) diagnostics = %v; want none", tt.input, diags)
+			}
+		})
+	}
+}
+
+func TestUnmatchedQuoteDiagnostic(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns []int
+	}{
+		{"single", "he said ' hello", []int{9}},
+		{"double", "a\nb \" c", []int{3}},
+		{"nested", `" a ' b`, []int{1, 5}},
+		{"closed", "' a ' \" b \"", nil},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor.Process(tt.input)
+			diags := processor.Diagnostics()
+			if len(diags) != len(tt.columns) {
+				t.Fatalf("Process(%q) diagnostics = %v; want columns %v", tt.input, diags, tt.columns)
+			}
+			for i, d := range diags {
+				if d.Code != "unterminated-quote" || d.Column != tt.columns[i] {
+					t.Errorf("diagnostic %d = %+v; want unterminated-quote at column %d", i, d, tt.columns[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnterminatedQuoteRecovery(t *testing.T) {
+	tests := []struct {
+		name       string
+		recovery   fsm.QuoteRecovery
+		paragraphs bool
+		input      string
+		expected   string
+		columns    []int
+	}{
+		{"literal", fsm.QuoteLiteral, false, "he said ' hello there", "he said ' hello there", []int{9}},
+		{"literal tight", fsm.QuoteLiteral, false, "he said 'hello there (up)", "he said 'hello THERE", []int{9}},
+		{"literal per line", fsm.QuoteLiteral, false, "one ' two\nthree ' four", "one ' two\nthree ' four", []int{5, 7}},
+		{"literal nested", fsm.QuoteLiteral, false, `" a ' b c`, `" a ' b c`, []int{1, 5}},
+		{"literal keeps brackets", fsm.QuoteLiteral, false, "(' open", "(' open", []int{2}},
+		{"literal spans paragraph", fsm.QuoteLiteral, true, "x ' a\nb ' c", "x 'a\nb' c", nil},
+		{"close line", fsm.QuoteCloseLine, false, "he said ' hello there", "he said 'hello there'", []int{9}},
+		{"close line in paragraph", fsm.QuoteCloseLine, true, "x ' a\nb ' c", "x 'a'\nb 'c'", []int{3, 3}},
+		{"close line nested", fsm.QuoteCloseLine, false, `" a ' b c`, `"a 'b c'"`, []int{1, 5}},
+		{"close line with nothing quoted", fsm.QuoteCloseLine, false, "he said '\nnext", "he said '\nnext", []int{9}},
+		{"close line nested with nothing quoted", fsm.QuoteCloseLine, false, `" a '`, `"a '"`, []int{1, 5}},
+		{"close paragraph with nothing quoted", fsm.QuoteCloseParagraph, true, "x \"\n\ny", "x \"\n\ny", []int{3}},
+		{"close paragraph", fsm.QuoteCloseParagraph, true, "x ' a\nb c\n\nd", "x 'a\nb c'\n\nd", []int{3}},
+		{"close paragraph matched", fsm.QuoteCloseParagraph, true, "x ' a\nb ' c", "x 'a\nb' c", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(fsm.Options{QuoteRecovery: tt.recovery, ParagraphBoundaries: tt.paragraphs})
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+			diags := processor.Diagnostics()
+			if len(diags) != len(tt.columns) {
+				t.Fatalf("Process(%q) diagnostics = %v; want columns %v", tt.input, diags, tt.columns)
+			}
+			for i, d := range diags {
+				if d.Code != "unterminated-quote" || d.Column != tt.columns[i] {
+					t.Errorf("diagnostic %d = %+v; want unterminated-quote at column %d", i, d, tt.columns[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnterminatedQuoteRecoveryMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		recovery   fsm.QuoteRecovery
+		paragraphs bool
+		input      string
+		expected   []string
+	}{
+		{"close paragraph without paragraphs", fsm.QuoteCloseParagraph, false, "x ' a\nb", []string{"1:3: quote ' is never closed, closed at the end of the line"}},
+		{"close paragraph at a blank line", fsm.QuoteCloseParagraph, true, "x ' a\nb\n\nc", []string{"1:3: quote ' is never closed, closed at the end of the paragraph"}},
+		{"close line in paragraph", fsm.QuoteCloseLine, true, "x ' a\nb", []string{"1:3: quote ' is never closed, closed at the end of the line"}},
+		{"close at the end of the input", fsm.QuoteCloseParagraph, true, "x ' a\nb", []string{"1:3: quote ' is never closed, closed at the end of the input"}},
+		{"literal", fsm.QuoteLiteral, false, "x ' a", []string{"1:3: quote ' is never closed, kept as is"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(fsm.Options{QuoteRecovery: tt.recovery, ParagraphBoundaries: tt.paragraphs})
+			processor.Process(tt.input)
+			var got []string
+			for _, d := range processor.Diagnostics() {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMisnestedQuotes(t *testing.T) {
+	tests := []struct {
+		name     string
+		recovery fsm.QuoteRecovery
+		input    string
+		expected string
+		messages []string
+	}{
+		{"outer closes first", fsm.QuoteLiteral, `" it's a ' nested " one '`, `"it's a ' nested" one '`, []string{
+			`1:10: quote ' is still open when the outer quote " closes, kept as is`,
+			`1:25: quote ' is never closed, kept as is`,
+		}},
+		{"outer closes at the end", fsm.QuoteLiteral, `" a ' b " c`, `"a ' b" c`, []string{
+			`1:5: quote ' is still open when the outer quote " closes, kept as is`,
+		}},
+		{"two inner quotes", fsm.QuoteLiteral, "\" a ' b “ c \" d", "\"a ' b “ c\" d", []string{
+			`1:5: quote ' is still open when the outer quote " closes, kept as is`,
+		}},
+		{"further nesting", fsm.QuoteLiteral, `" a ' b " c " d ' e "`, `"a 'b "c" d' e"`, nil},
+		{"typographic", fsm.QuoteLiteral, "“ x ‘ y ” z", "“x ‘ y” z", []string{
+			"1:5: quote ‘ is still open when the outer quote “ closes, kept as is",
+		}},
+		{"close line", fsm.QuoteCloseLine, `" a ' b " c`, `"a 'b'" c`, []string{
+			`1:5: quote ' is still open when the outer quote " closes, closed before it`,
+		}},
+		{"properly nested", fsm.QuoteLiteral, `' he said " hi " '`, `'he said "hi"'`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(fsm.Options{QuoteRecovery: tt.recovery})
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+			var got []string
+			for _, d := range processor.Diagnostics() {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.messages) {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.messages, got)
+			}
+		})
+	}
+}
+
+func TestParseQuoteRecovery(t *testing.T) {
+	for _, r := range []fsm.QuoteRecovery{fsm.QuoteLiteral, fsm.QuoteCloseLine, fsm.QuoteCloseParagraph} {
+		parsed, err := fsm.ParseQuoteRecovery(r.String())
+		if err != nil || parsed != r {
+			t.Errorf("ParseQuoteRecovery(%q) = %v, %v; want %v", r.String(), parsed, err, r)
+		}
+	}
+	if _, err := fsm.ParseQuoteRecovery("drop"); err == nil {
+		t.Error("ParseQuoteRecovery(\"drop\") = nil error; want unknown quote recovery")
+	}
+}
+
+func TestTypographicQuotes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"curly double", "he said “ hello ” today", "he said “hello” today"},
+		{"curly single", "‘ one two ’ (up, 2)", "‘ONE TWO’"},
+		{"guillemets", "« bonjour » toi", "«bonjour» toi"},
+		{"reversed guillemets", "» hej « du", "»hej« du"},
+		{"german", "„ hallo “ du", "„hallo“ du"},
+		{"nested curly", "“ he said ‘ hi ’ ”", "“he said ‘hi’”"},
+		{"curly apostrophe in word", "don’t ‘ stop ’", "don’t ‘stop’"},
+		{"curly possessive", "the students’ books", "the students’ books"},
+		{"article into curly quote", "a “ apple ”", "an “apple”"},
+		{"unclosed curly", "he said “ hello", "he said “ hello"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSmartQuotes(t *testing.T) {
+	tests := []struct {
+		lang     string
+		input    string
+		expected string
+	}{
+		{"en", `he said " don't ' go ' now " ok`, "he said “don’t ‘go’ now” ok"},
+		{"en", "rock 'n' roll in the '90s", "rock ’n’ roll in the ’90s"},
+		{"en", "“ already ” curly", "“already” curly"},
+		{"fr", `il dit " oui ' non ' "`, "il dit «" + nbsp + "oui “non”" + nbsp + "»"},
+		{"es", `dijo " hola "`, "dijo «hola»"},
+		{"de", `er sagte " ja ' nein ' "`, "er sagte „ja ‚nein‘“"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang+" "+tt.input, func(t *testing.T) {
+			profile, err := formatters.ParseProfile(tt.lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			processor := fsm.NewProcessorWithOptions(fsm.Options{SmartQuotes: true, Profile: profile})
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestQuoteModifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"up q", "he said ' some nice words ' (up, q) ok", "he said 'SOME NICE WORDS' ok"},
+		{"cap quote", `" a ' b c ' d " (cap, quote)`, `"A 'B C' D"`},
+		{"count still counts words", `" a ' b c ' d " (up)`, `"a 'b c' D"`},
+		{"word after quote", "' x ' y (up, q)", "'X' y"},
+		{"inner quote", `" outer ' inner words ' (up, q) "`, `"outer 'INNER WORDS'"`},
+		{"hex over quote", "' 1F 10 ' (hex, q)", "'31 16'"},
+		{"spaced count", "' a b ' (low,  quote )", "'a b'"},
+		{"curly quote", "“ hello there ” (up, q)", "“HELLO THERE”"},
+		{"marker names are plain words", "' QUOTE_START ' and ' QUOTE_END ' (low, q)", "'QUOTE_START' and 'quote_end'"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+			if diags := processor.Diagnostics(); len(diags) != 0 {
+				t.Errorf("Process(%q) diagnostics = %v; want none", tt.input, diags)
+			}
+		})
+	}
+}
+
+func TestQuoteModifierWithoutQuote(t *testing.T) {
+	processor := fsm.NewProcessor()
+	result := processor.Process("no quote here (up, q)")
+	if result != "no quote here" {
+		t.Errorf("Process() = %q; want %q", result, "no quote here")
+	}
+	diags := processor.Diagnostics()
+	if len(diags) != 1 || diags[0].Code != "modifier-no-quote" || diags[0].Column != 15 {
+		t.Errorf("Diagnostics() = %+v; want one modifier-no-quote at column 15", diags)
+	}
+}
+
+func TestQuoteMarksScaleLinearly(t *testing.T) {
+	// Every misnested or ambiguous mark used to read to the end of its
+	// line, and quotes could nest without end
+	tests := []struct {
+		name string
+		unit string
+		opts fsm.Options
+	}{
+		{"misnested on one line", "\" a ' b ", fsm.Options{}},
+		{"misnested closed at line end", "\" a ' b ", fsm.Options{QuoteRecovery: fsm.QuoteCloseLine}},
+		{"nesting without end", "“ a ‘ b ", fsm.Options{}},
+		{"ambiguous apostrophes", "' the students' books ", fsm.Options{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkLinear(t, tt.unit, tt.opts)
+		})
+	}
+}
+
+func TestQuoteDepthLimit(t *testing.T) {
+	input := strings.Repeat("“ ", 40) + "deep"
+	processor := fsm.NewProcessor()
+	processor.Process(input)
+	var deep int
+	for _, d := range processor.Diagnostics() {
+		if d.Code == "quote-too-deep" {
+			deep++
+		}
+	}
+	if deep != 8 {
+		t.Errorf("got %d quote-too-deep diagnostics; want 8 for the marks past the 32nd", deep)
+	}
+}
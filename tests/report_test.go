@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/json"
+	"go-reloaded/formats"
+	"go-reloaded/formatters"
+	"go-reloaded/fsm"
+	"go-reloaded/report"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ==================== STATISTICS AND REPORT TESTS ====================
+
+func TestProcessorStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     fsm.Options
+		expected fsm.Stats
+	}{
+		{"nothing to do", "hello, world.", fsm.Options{}, fsm.Stats{}},
+		{"modifiers by type", "a (up) b (up, 2) 1E (hex) 10 (bin) c (cap) D (low)", fsm.Options{}, fsm.Stats{Modifiers: map[string]int{"up": 2, "hex": 1, "bin": 1, "cap": 1, "low": 1}}},
+		{"modifier on a quote", "'a b' (up, q)", fsm.Options{}, fsm.Stats{Modifiers: map[string]int{"up": 1}}},
+		{"modifier with nothing to apply to", "(up) hello", fsm.Options{}, fsm.Stats{}},
+		{"quote modifier without a quote", "hello (up, q)", fsm.Options{}, fsm.Stats{}},
+		{"modifiers that change nothing", "zz (hex) 12 (bin) HI (up) 'OK' (up, q) x (cap)", fsm.Options{}, fsm.Stats{Modifiers: map[string]int{"cap": 1}}},
+		{"articles", "a apple, a car, an owl, A hour", fsm.Options{}, fsm.Stats{Articles: 2}},
+		{"articles in a quote", "'a apple'", fsm.Options{}, fsm.Stats{Articles: 1}},
+		{"space before punctuation", "hello , world !", fsm.Options{}, fsm.Stats{Punctuation: 2}},
+		{"missing space after punctuation", "hello,world", fsm.Options{}, fsm.Stats{Punctuation: 1}},
+		{"punctuation at the start of a line", "hello\n, world", fsm.Options{}, fsm.Stats{}},
+		{"rewritten marks", "wow!!!! ok", fsm.Options{Punctuation: formatters.PunctuationOptions{CollapseRepeats: true}}, fsm.Stats{Punctuation: 1}},
+		{"quote spacing", "' hi ' and 'ok' and \" a \"", fsm.Options{}, fsm.Stats{Quotes: 2}},
+		{"smart quotes", "'hi' and “ok”", fsm.Options{SmartQuotes: true}, fsm.Stats{Quotes: 1}},
+		{"nested quotes", "\"she said 'hi'\"", fsm.Options{}, fsm.Stats{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(tt.opts)
+			processor.Process(tt.input)
+			if got := processor.Stats(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("\nInput:    %q\nExpected: %+v\nGot:      %+v", tt.input, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStatsResetBetweenCalls(t *testing.T) {
+	processor := fsm.NewProcessor()
+	processor.Process("hello (up) , it is a apple")
+	processor.Process("nothing here.")
+	if got := processor.Stats(); !reflect.DeepEqual(got, fsm.Stats{}) {
+		t.Errorf("expected empty stats, got %+v", got)
+	}
+}
+
+func TestFormatStatsAddUp(t *testing.T) {
+	input := "# title (up)\n\nit is a apple , ok\n\n| a , b | c (up) |\n|---|---|\n"
+	result, err := formats.Process(fsm.NewProcessor(), formats.Markdown, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := fsm.Stats{Modifiers: map[string]int{"up": 2}, Articles: 1, Punctuation: 2}
+	if !reflect.DeepEqual(result.Stats, expected) {
+		t.Errorf("\nExpected: %+v\nGot:      %+v", expected, result.Stats)
+	}
+}
+
+func TestReport(t *testing.T) {
+	processor := fsm.NewProcessor()
+	var r report.Report
+	for _, input := range []string{"it is a apple (up) .", "' hi ' 'open"} {
+		output := processor.Process(input)
+		r.Add(report.NewFile("in.txt", "out.txt", "text", input, output, processor.Stats(), processor.Diagnostics(), 1500*time.Microsecond))
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := r.Write(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got report.Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+
+	if len(got.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(got.Files))
+	}
+	first := got.Files[0]
+	if first.DurationMS != 1.5 || first.Counts.InputBytes != 20 || first.Counts.OutputBytes != 15 {
+		t.Errorf("unexpected sizes or duration: %+v", first)
+	}
+	if first.Counts.Modifiers["up"] != 1 || first.Counts.Modifiers["hex"] != 0 || len(first.Counts.Modifiers) != 5 {
+		t.Errorf("expected every modifier type, got %v", first.Counts.Modifiers)
+	}
+	second := got.Files[1]
+	if len(second.Diagnostics) != 1 || second.Diagnostics[0].Code != "unterminated-quote" || second.Diagnostics[0].Column != 8 {
+		t.Errorf("unexpected diagnostics: %+v", second.Diagnostics)
+	}
+
+	expected := report.Counts{
+		Files:       2,
+		InputBytes:  32,
+		OutputBytes: 25,
+		Modifiers:   map[string]int{"up": 1, "low": 0, "cap": 0, "hex": 0, "bin": 0},
+		Articles:    1,
+		Punctuation: 1,
+		Quotes:      1,
+		Diagnostics: 1,
+	}
+	if !reflect.DeepEqual(got.Totals, expected) {
+		t.Errorf("\nExpected: %+v\nGot:      %+v", expected, got.Totals)
+	}
+}
+
+func TestEmptyReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := (report.Report{}).Write(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if files, ok := got["files"].([]any); !ok || len(files) != 0 {
+		t.Errorf("expected an empty files list, got %v", got["files"])
+	}
+}
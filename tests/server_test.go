@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-reloaded/server"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ==================== HTTP SERVER TESTS ====================
+
+func postProcess(t *testing.T, handler http.Handler, contentType, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) server.Response {
+	t.Helper()
+	var resp server.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response is not valid JSON: %v\n%s", err, rec.Body.String())
+	}
+	return resp
+}
+
+func TestServerHealth(t *testing.T) {
+	rec := httptest.NewRecorder()
+	server.New(server.Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ok"`) {
+		t.Errorf("expected 200 ok, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServerProcess(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		target      string
+		body        string
+		expected    string
+	}{
+		{"plain text", "text/plain; charset=utf-8", "/v1/process", "hello , world (up)", "hello, WORLD"},
+		{"no content type is plain text", "", "/v1/process", "it is a apple", "it is an apple"},
+		{"plain text with a format", "text/plain", "/v1/process?format=html", "<p>a , b</p>", "<p>a, b</p>"},
+		{"json", "application/json", "/v1/process", `{"text": "hello , world (up)"}`, "hello, WORLD"},
+		{"json with options", "application/json", "/v1/process", `{"text": "wow !!!! ok", "options": {"collapse_repeats": true}}`, "wow! ok"},
+		{"json with a language", "application/json", "/v1/process", `{"text": "oui ; non", "options": {"lang": "fr"}}`, "oui ; non"},
+		{"json with a format and paths", "application/json", "/v1/process", `{"text": "{\"a\": \"x , y\", \"b\": \"x , y\"}", "format": "json", "paths": ["$.b"]}`, `{"a": "x , y", "b": "x, y"}`},
+		{"json with columns", "application/json", "/v1/process", `{"text": "a,b\nx (up),y (up)\n", "format": "csv", "columns": ["b"]}`, "a,b\nx (up),Y\n"},
+	}
+
+	handler := server.New(server.Config{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postProcess(t, handler, tt.contentType, tt.target, tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected a JSON response, got %q", ct)
+			}
+			if resp := decodeResponse(t, rec); resp.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.body, tt.expected, resp.Text)
+			}
+		})
+	}
+}
+
+func TestServerReportsDiagnosticsAndExplanations(t *testing.T) {
+	body := `{"text": "wait.... ' open", "options": {"ellipsis": "dots"}}`
+	rec := postProcess(t, server.New(server.Config{}), "application/json", "/v1/process", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	resp := decodeResponse(t, rec)
+	if resp.Text != "wait... ' open" {
+		t.Errorf("unexpected text %q", resp.Text)
+	}
+	if len(resp.Diagnostics) != 1 || resp.Diagnostics[0].Code != "unterminated-quote" || resp.Diagnostics[0].Column != 10 {
+		t.Errorf("unexpected diagnostics %+v", resp.Diagnostics)
+	}
+	if len(resp.Explanations) != 1 || resp.Explanations[0].Rule != "ellipsis" || resp.Explanations[0].After != "..." {
+		t.Errorf("unexpected explanations %+v", resp.Explanations)
+	}
+	if resp.Stats.Punctuation != 1 || resp.Stats.InputBytes != 15 || resp.Stats.Diagnostics != 1 {
+		t.Errorf("unexpected stats %+v", resp.Stats)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"invalid json", http.MethodPost, "application/json", `{"text": `, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "application/json", `{"txt": "a"}`, http.StatusBadRequest},
+		{"trailing value", http.MethodPost, "application/json", `{"text": "a"} {}`, http.StatusBadRequest},
+		{"unknown option value", http.MethodPost, "application/json", `{"text": "a", "options": {"lang": "xx"}}`, http.StatusBadRequest},
+		{"negative wrap", http.MethodPost, "application/json", `{"text": "a", "options": {"wrap": -1}}`, http.StatusBadRequest},
+		{"unknown format", http.MethodPost, "application/json", `{"text": "a", "format": "docx"}`, http.StatusBadRequest},
+		{"document the format rejects", http.MethodPost, "application/json", `{"text": "{", "format": "json"}`, http.StatusUnprocessableEntity},
+		{"unsupported content type", http.MethodPost, "application/xml", `<a/>`, http.StatusUnsupportedMediaType},
+		{"body too large", http.MethodPost, "text/plain", strings.Repeat("a ", 64), http.StatusRequestEntityTooLarge},
+		{"json body too large", http.MethodPost, "application/json", `{"text": "` + strings.Repeat("a", 128) + `"}`, http.StatusRequestEntityTooLarge},
+		{"wrong method", http.MethodGet, "", "", http.StatusMethodNotAllowed},
+	}
+
+	handler := server.New(server.Config{MaxBodyBytes: 100})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/process", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerManyOptionSets(t *testing.T) {
+	// Past the pools the server keeps, requests are still processed
+	handler := server.New(server.Config{})
+	input := "it is a apple (up)"
+	for wrap := 1; wrap <= 200; wrap++ {
+		body := fmt.Sprintf(`{"text": %q, "options": {"wrap": %d}}`, input, wrap+40)
+		rec := postProcess(t, handler, "application/json", "/v1/process", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("wrap %d: expected 200, got %d: %s", wrap, rec.Code, rec.Body.String())
+		}
+		if got := decodeResponse(t, rec).Text; got != "it is an APPLE" {
+			t.Fatalf("\nInput: %q\nExpected: %q\nGot: %q", input, "it is an APPLE", got)
+		}
+	}
+}
+
+func TestServerConcurrentRequests(t *testing.T) {
+	srv := httptest.NewServer(server.New(server.Config{}))
+	defer srv.Close()
+
+	inputs := map[string]string{
+		"hello , world (up)":       "hello, WORLD",
+		"it is a apple":            "it is an apple",
+		"' hi ' there (cap, 2)":    "'Hi' There",
+		"1E (hex) and 101 (bin) .": "30 and 5.",
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for input, expected := range inputs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := http.Post(srv.URL+"/v1/process", "text/plain", strings.NewReader(input))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer resp.Body.Close()
+				var got server.Response
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Error(err)
+					return
+				}
+				if got.Text != expected {
+					t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", input, expected, got.Text)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, ln, server.Config{})
+	}()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("server not answering: %v", err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/healthz"); err == nil {
+		t.Error("server still answering after shutdown")
+	}
+}
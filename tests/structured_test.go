@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"go-reloaded/formats"
+	"go-reloaded/fsm"
+	"reflect"
+	"testing"
+)
+
+// ==================== JSON AND YAML FORMAT TESTS ====================
+
+func TestJSONFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		paths    []string
+		expected string
+	}{
+		{"every string by default", `{"a": "x , y", "b": ["it is a apple"]}`, nil, `{"a": "x, y", "b": ["it is an apple"]}`},
+		{"keys untouched", `{"a , b": "c , d"}`, nil, `{"a , b": "c, d"}`},
+		{"numbers and literals untouched", `[1.5e3, -2, true, false, null, "a ,b"]`, nil, `[1.5e3, -2, true, false, null, "a, b"]`},
+		{"layout kept", "{\n  \"a\" :\t\"x , y\" ,\n  \"b\":[ ]\n}\n", nil, "{\n  \"a\" :\t\"x, y\" ,\n  \"b\":[ ]\n}\n"},
+		{"wildcard children", `{"messages": {"a": "x , y", "b": "z , w"}, "c": "u , v"}`, []string{"$.messages.*"}, `{"messages": {"a": "x, y", "b": "z, w"}, "c": "u , v"}`},
+		{"selected object covers its strings", `{"m": {"a": {"b": "x , y"}}, "n": "x , y"}`, []string{"$.m"}, `{"m": {"a": {"b": "x, y"}}, "n": "x , y"}`},
+		{"array index", `{"list": ["a , b", "c , d"]}`, []string{"$.list[1]"}, `{"list": ["a , b", "c, d"]}`},
+		{"recursive descent", `{"a": {"title": "x , y"}, "b": [{"title": "z , w"}], "c": "u , v"}`, []string{"$..title"}, `{"a": {"title": "x, y"}, "b": [{"title": "z, w"}], "c": "u , v"}`},
+		{"bracket names", `{"a b": "x , y", "c": "z , w"}`, []string{"$['a b']"}, `{"a b": "x, y", "c": "z , w"}`},
+		{"several paths", `{"a": "x , y", "b": "z , w", "c": "u , v"}`, []string{"$.a", "$.c"}, `{"a": "x, y", "b": "z , w", "c": "u, v"}`},
+		{"escapes decoded", `{"q": "he said \" hi \" (up)"}`, nil, `{"q": "he said \"HI\""}`},
+		{"line breaks decoded", `{"t": "one ,\ntwo (up)"}`, nil, `{"t": "one,\nTWO"}`},
+		{"unchanged string keeps escapes", `{"t": "caf\u00e9 \/ ok"}`, nil, `{"t": "caf\u00e9 \/ ok"}`},
+		{"surrogate pairs", `{"t": "\ud83d\ude00 a , b"}`, nil, `{"t": "😀 a, b"}`},
+		{"top level string", `"a , b"`, nil, `"a, b"`},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.JSON, tt.input, formats.Options{Paths: tt.paths})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestJSONEdits(t *testing.T) {
+	input := `{"messages": {"hi": "hello , there", "same": "ok.", "two words": "a apple"}, "list": ["x , y"]}`
+	expected := []formats.Edit{
+		{Path: "$.messages.hi", Before: "hello , there", After: "hello, there"},
+		{Path: "$.messages['two words']", Before: "a apple", After: "an apple"},
+		{Path: "$.list[0]", Before: "x , y", After: "x, y"},
+	}
+
+	result, err := formats.Process(fsm.NewProcessor(), formats.JSON, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Edits, expected) {
+		t.Errorf("\nExpected: %v\nGot:      %v", expected, result.Edits)
+	}
+}
+
+func TestJSONDiagnosticPositions(t *testing.T) {
+	input := "{\n  \"a\": \"x \\\" y \\n ' z\"\n}"
+	result, err := formats.Process(fsm.NewProcessor(), formats.JSON, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", result.Diagnostics)
+	}
+	// The escaped quote is reported at its backslash, and the escaped
+	// line break shifts nothing after it
+	for i, column := range []int{11, 19} {
+		if d := result.Diagnostics[i]; d.Line != 2 || d.Column != column {
+			t.Errorf("expected 2:%d, got %d:%d", column, d.Line, d.Column)
+		}
+	}
+}
+
+func TestStructuredFormatErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format formats.Format
+		input  string
+		paths  []string
+	}{
+		{"invalid json", formats.JSON, `{"a": }`, nil},
+		{"truncated json", formats.JSON, `{"a": "b"`, nil},
+		{"path without $", formats.JSON, `{}`, []string{"messages"}},
+		{"unclosed subscript", formats.JSON, `{}`, []string{"$.a[0"}},
+		{"bad subscript", formats.YAML, "a: b", []string{"$[x]"}},
+		{"empty name", formats.YAML, "a: b", []string{"$.a."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := formats.Process(fsm.NewProcessor(), tt.format, tt.input, formats.Options{Paths: tt.paths})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestYAMLFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		paths    []string
+		expected string
+	}{
+		{"plain scalar", "a: x , y\n", nil, "a: x, y\n"},
+		{"keys untouched", "a , b: c , d", nil, "a , b: c, d"},
+		{"comments kept", "# a , b\na: x , y  # c , d\n", nil, "# a , b\na: x, y  # c , d\n"},
+		{"non strings untouched", "n: 1.5\nb: true\nz: null\nd: 2024-01-02", nil, "n: 1.5\nb: true\nz: null\nd: 2024-01-02"},
+		{"double quoted", `a: "he said \" hi \" , ok"`, nil, `a: "he said \"hi\", ok"`},
+		{"single quoted", "a: 'it''s a owl , ok'", nil, "a: 'it''s an owl, ok'"},
+		{"quoted key", "\"a b\": x , y\n'c': z , w", nil, "\"a b\": x, y\n'c': z, w"},
+		{"nested mapping", "m:\n  a:\n    b: x , y\n  c: z , w\nd: u , v", []string{"$.m.a"}, "m:\n  a:\n    b: x, y\n  c: z , w\nd: u , v"},
+		{"wildcard children", "messages:\n  a: x , y\n  b: z , w\nother: u , v", []string{"$.messages.*"}, "messages:\n  a: x, y\n  b: z, w\nother: u , v"},
+		{"sequence items", "l:\n  - a , b\n  - c , d", []string{"$.l[1]"}, "l:\n  - a , b\n  - c, d"},
+		{"sequence at key column", "l:\n- a , b\n- c , d\nm: e , f", []string{"$.l[1]"}, "l:\n- a , b\n- c, d\nm: e , f"},
+		{"mappings in sequence", "l:\n  - k: a , b\n    v: c , d\n  - k: e , f", []string{"$.l[*].k"}, "l:\n  - k: a, b\n    v: c , d\n  - k: e, f"},
+		{"literal block", "a: |\n  one , two\n    indented (up)\n  three .\nb: x , y", []string{"$.a"}, "a: |\n  one, two\n    INDENTED\n  three.\nb: x , y"},
+		{"folded block", "a: >-\n  one ,\n  two .\n", nil, "a: >-\n  one, two.\n"},
+		{"folded lines across a fold", "a: >\n  a\n  apple (up)\n", nil, "a: >\n  an APPLE\n"},
+		{"folded breaks kept", "a: >\n  one ,\n\n  two\n    kept (up)\n  three .\n", nil, "a: >\n  one,\n\n  two\n    KEPT\n  three.\n"},
+		{"flow collections kept", "a: [x , y]\nb: {c: d , e}", nil, "a: [x , y]\nb: {c: d , e}"},
+		{"anchors and tags", "a: &ref x , y\nb: *ref\nc: !!str z , w", nil, "a: &ref x, y\nb: *ref\nc: !!str z, w"},
+		{"plain quoted when needed", "a: x : y", nil, "a: \"x: y\""},
+		{"documents", "a: x , y\n---\na: z , w\n...\n", nil, "a: x, y\n---\na: z, w\n...\n"},
+		{"crlf kept", "a: x , y\r\nb: z\r\n", nil, "a: x, y\r\nb: z\r\n"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.YAML, tt.input, formats.Options{Paths: tt.paths})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestYAMLUnsupportedConstructs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		paths    []string
+		expected string   // Output text
+		reported []string // Messages of the unsupported-yaml diagnostics
+	}{
+		{"flow mapping", "flow: {a: b (up)}\nx: y , z\n", nil, "flow: {a: b (up)}\nx: y, z\n",
+			[]string{"1:7: $.flow: a flow collection is written back unprocessed"}},
+		{"flow sequence over lines", "flow: [a, 'it''s ]',\n  b (up)]\nx: y , z\n", nil, "flow: [a, 'it''s ]',\n  b (up)]\nx: y, z\n",
+			[]string{"1:7: $.flow: a flow collection is written back unprocessed"}},
+		{"flow in sequence", "- [a , b]\n- c , d\n", nil, "- [a , b]\n- c, d\n",
+			[]string{"1:3: $[0]: a flow collection is written back unprocessed"}},
+		{"quoted over lines", "q: \"one ,\n  two: three\"\nx: y , z\n", nil, "q: \"one ,\n  two: three\"\nx: y, z\n",
+			[]string{"1:4: $.q: a quoted scalar over several lines is written back unprocessed"}},
+		{"plain continuation", "p: one ,\n  two (up)\n\n  three\nx: y , z\n", nil, "p: one ,\n  two (up)\n\n  three\nx: y, z\n",
+			[]string{"1:4: $.p: a plain scalar over several lines is written back unprocessed"}},
+		{"alias", "a: &r x , y\nb: *r\n", nil, "a: &r x, y\nb: *r\n",
+			[]string{"2:4: $.b: alias *r is written back unprocessed"}},
+		{"merge key", "m:\n  <<: *base\n  a: x , y\n", nil, "m:\n  <<: *base\n  a: x, y\n", nil},
+		{"path selecting inside a flow", "a: x , y\nflow: {a: b}\n", []string{"$.flow.a"}, "a: x , y\nflow: {a: b}\n",
+			[]string{"2:7: $.flow: a flow collection is written back unprocessed"}},
+		{"path selecting elsewhere", "a: x , y\nflow: {a: b}\n", []string{"$.a"}, "a: x, y\nflow: {a: b}\n", nil},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, formats.YAML, tt.input, formats.Options{Paths: tt.paths})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+			var reported []string
+			for _, d := range result.Diagnostics {
+				if d.Code == "unsupported-yaml" {
+					reported = append(reported, d.String())
+				}
+			}
+			if !reflect.DeepEqual(reported, tt.reported) {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.reported, reported)
+			}
+		})
+	}
+}
+
+func TestYAMLEdits(t *testing.T) {
+	input := "messages:\n  hi: hello , there\n  same: ok.\n  list:\n    - a apple\n" +
+		"  literal: |\n    x (up)\n      nested , line\n  folded: >\n    a\n    apple (up)\n\n    c , d\n"
+	expected := []formats.Edit{
+		{Path: "$.messages.hi", Before: "hello , there", After: "hello, there"},
+		{Path: "$.messages.list[0]", Before: "a apple", After: "an apple"},
+		{Path: "$.messages.literal", Before: "x (up)\n  nested , line", After: "X\n  nested, line"},
+		{Path: "$.messages.folded", Before: "a apple (up)\nc , d", After: "an APPLE\nc, d"},
+	}
+
+	result, err := formats.Process(fsm.NewProcessor(), formats.YAML, input, formats.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Edits, expected) {
+		t.Errorf("\nExpected: %v\nGot:      %v", expected, result.Edits)
+	}
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"go-reloaded/formats"
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== SUBTITLE FORMAT TESTS ====================
+
+func TestSubtitleFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   formats.Format
+		input    string
+		expected string
+	}{
+		{"srt cue text", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\nhello , it is a apple .\n", "1\n00:00:01,000 --> 00:00:02,000\nhello, it is an apple.\n"},
+		{"srt numbers and timings untouched", formats.SRT, "12\n01:02:03,456 --> 01:02:04,000 X1:10 X2:20\na , b\n", "12\n01:02:03,456 --> 01:02:04,000 X1:10 X2:20\na, b\n"},
+		{"srt lines of a cue kept", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\nit is a\napple (up)\n", "1\n00:00:01,000 --> 00:00:02,000\nit is an\nAPPLE\n"},
+		{"srt cues stay apart", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\nhello\n\n2\n00:00:02,000 --> 00:00:03,000\n(up) world\n", "1\n00:00:01,000 --> 00:00:02,000\nhello\n\n2\n00:00:02,000 --> 00:00:03,000\n(up) world\n"},
+		{"srt styling tags", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\n<i>hello</i> (up) , <font color=\"#ff0\">a</font> owl\n", "1\n00:00:01,000 --> 00:00:02,000\n<i>HELLO</i>, <font color=\"#ff0\">an</font> owl\n"},
+		{"srt overrides", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\n{\\an8}Hi.{\\i1} there , ok\n", "1\n00:00:01,000 --> 00:00:02,000\n{\\an8}Hi.{\\i1} there, ok\n"},
+		{"srt ampersand is text", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\nR&D , 3 < 4\n", "1\n00:00:01,000 --> 00:00:02,000\nR&D, 3 < 4\n"},
+		{"srt missing blank line", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\na , b\n2\n00:00:02,000 --> 00:00:03,000\nc , d\n", "1\n00:00:01,000 --> 00:00:02,000\na, b\n2\n00:00:02,000 --> 00:00:03,000\nc, d\n"},
+		{"srt crlf", formats.SRT, "1\r\n00:00:01,000 --> 00:00:02,000\r\na , b\r\nc (up)\r\n\r\n", "1\r\n00:00:01,000 --> 00:00:02,000\r\na, b\r\nC\r\n\r\n"},
+		{"srt empty cue", formats.SRT, "1\n00:00:01,000 --> 00:00:02,000\n\n2\n00:00:02,000 --> 00:00:03,000\na , b", "1\n00:00:01,000 --> 00:00:02,000\n\n2\n00:00:02,000 --> 00:00:03,000\na, b"},
+		{"vtt header and blocks", formats.VTT, "WEBVTT - a , b\nKind: captions\n\nNOTE a , b (up)\n\nSTYLE\n::cue { color: red , blue }\n\n00:01.000 --> 00:02.000\nc , d\n", "WEBVTT - a , b\nKind: captions\n\nNOTE a , b (up)\n\nSTYLE\n::cue { color: red , blue }\n\n00:01.000 --> 00:02.000\nc, d\n"},
+		{"vtt identifier and settings", formats.VTT, "WEBVTT\n\nintro , one\n00:01.000 --> 00:02.000 align:start line:0\na , b\n", "WEBVTT\n\nintro , one\n00:01.000 --> 00:02.000 align:start line:0\na, b\n"},
+		{"vtt tags", formats.VTT, "WEBVTT\n\n00:01.000 --> 00:02.000\n<v Roger>it is a <c.yellow>owl</c> , <00:01.500>ok\n", "WEBVTT\n\n00:01.000 --> 00:02.000\n<v Roger>it is an <c.yellow>owl</c>, <00:01.500>ok\n"},
+		{"vtt entities", formats.VTT, "WEBVTT\n\n00:01.000 --> 00:02.000\nTom &amp; Jerry &lt;3 , ok\n", "WEBVTT\n\n00:01.000 --> 00:02.000\nTom &amp; Jerry &lt;3, ok\n"},
+		{"vtt quotes across lines", formats.VTT, "WEBVTT\n\n00:01.000 --> 00:02.000\nsay \" hi\nthere \" ok\n", "WEBVTT\n\n00:01.000 --> 00:02.000\nsay \"hi\nthere\" ok\n"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formats.Process(processor, tt.format, tt.input, formats.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestSpanCues(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"modifier reaches the previous cue", "1\n00:00:01,000 --> 00:00:02,000\nhello\n\n2\n00:00:02,000 --> 00:00:03,000\n(up) world\n", "1\n00:00:01,000 --> 00:00:02,000\nHELLO\n\n2\n00:00:02,000 --> 00:00:03,000\nworld\n"},
+		{"count spans several cues", "1\n00:00:01,000 --> 00:00:02,000\none\n\n2\n00:00:02,500 --> 00:00:03,000\ntwo\n\n3\n00:00:03,000 --> 00:00:04,000\nthree (cap, 3)\n", "1\n00:00:01,000 --> 00:00:02,000\nOne\n\n2\n00:00:02,500 --> 00:00:03,000\nTwo\n\n3\n00:00:03,000 --> 00:00:04,000\nThree\n"},
+		{"article sees the next cue", "1\n00:00:01,000 --> 00:00:02,000\nit is a\n\n2\n00:00:02,000 --> 00:00:03,000\napple\n", "1\n00:00:01,000 --> 00:00:02,000\nit is an\n\n2\n00:00:02,000 --> 00:00:03,000\napple\n"},
+		{"punctuation stays in its cue", "1\n00:00:01,000 --> 00:00:02,000\nhello .\n\n2\n00:00:02,000 --> 00:00:03,000\nthere , you\n", "1\n00:00:01,000 --> 00:00:02,000\nhello.\n\n2\n00:00:02,000 --> 00:00:03,000\nthere, you\n"},
+		{"pause ends the block", "1\n00:00:01,000 --> 00:00:02,000\nhello\n\n2\n00:00:05,000 --> 00:00:06,000\n(up) world\n", "1\n00:00:01,000 --> 00:00:02,000\nhello\n\n2\n00:00:05,000 --> 00:00:06,000\n(up) world\n"},
+		{"note ends the block", "WEBVTT\n\n00:01.000 --> 00:02.000\nhello\n\nNOTE break\n\n00:02.000 --> 00:03.000\n(up) world\n", "WEBVTT\n\n00:01.000 --> 00:02.000\nhello\n\nNOTE break\n\n00:02.000 --> 00:03.000\n(up) world\n"},
+	}
+
+	processor := fsm.NewProcessor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := formats.SRT
+			if len(tt.input) > 6 && tt.input[:6] == "WEBVTT" {
+				format = formats.VTT
+			}
+			result, err := formats.Process(processor, format, tt.input, formats.Options{SpanCues: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Text != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestSubtitleDiagnosticPositions(t *testing.T) {
+	input := "1\n00:00:01,000 --> 00:00:02,000\nhello\n\n2\n00:00:02,000 --> 00:00:03,000\n<i>it's ' open</i>\n"
+	for _, span := range []bool{false, true} {
+		result, err := formats.Process(fsm.NewProcessor(), formats.SRT, input, formats.Options{SpanCues: span})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(result.Diagnostics) != 1 {
+			t.Fatalf("expected 1 diagnostic, got %v", result.Diagnostics)
+		}
+		if d := result.Diagnostics[0]; d.Line != 7 || d.Column != 9 {
+			t.Errorf("span %v: expected 7:9, got %d:%d", span, d.Line, d.Column)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== NORMALIZATION AND BOM TESTS ====================
+
+func TestUnicodePreparation(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     fsm.Options
+		input    string
+		expected string
+	}{
+		{"decomposed accent stays one word", fsm.Options{}, "cafe\u0301 (up) ok", "CAFE\u0301 ok"},
+		{"nfc composes", fsm.Options{Normalization: fsm.NormalizeNFC}, "cafe\u0301 (up) ok", "CAF\u00c9 ok"},
+		{"nfd decomposes", fsm.Options{Normalization: fsm.NormalizeNFD}, "caf\u00e9 (up) ok", "CAFE\u0301 ok"},
+		{"nfc capitalize", fsm.Options{Normalization: fsm.NormalizeNFC}, "e\u0301cole (cap)", "\u00c9cole"},
+		{"nfd capitalize", fsm.Options{Normalization: fsm.NormalizeNFD}, "\u00e9cole (cap)", "E\u0301cole"},
+		{"bom kept by default", fsm.Options{}, "\uFEFFit (cap) was", "\uFEFFIt was"},
+		{"bom stripped", fsm.Options{StripBOM: true}, "\uFEFFit (cap) was", "It was"},
+		{"bom before quote", fsm.Options{}, "\uFEFF' hi '", "\uFEFF'hi'"},
+		{"bom before article", fsm.Options{}, "\uFEFFa apple", "\uFEFFan apple"},
+		{"bom with normalization", fsm.Options{Normalization: fsm.NormalizeNFC}, "\uFEFFcafe\u0301", "\uFEFFcaf\u00e9"},
+		{"only a bom", fsm.Options{}, "\uFEFF", "\uFEFF"},
+		{"invalid utf8 kept", fsm.Options{Normalization: fsm.NormalizeNFC}, "bad \xff\xfe bytes", "bad \xff\xfe bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := fsm.NewProcessorWithOptions(tt.opts)
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestInvalidUTF8Diagnostics(t *testing.T) {
+	input := "\uFEFFfine line\nbad \xff\xfe here \u00e9 and \xc3\n"
+
+	processor := fsm.NewProcessor()
+	processor.Process(input)
+	diagnostics := processor.Diagnostics()
+
+	expected := []fsm.Diagnostic{
+		{Offset: 17, Line: 2, Column: 5, Code: "invalid-utf8"},
+		{Offset: 32, Line: 2, Column: 19, Code: "invalid-utf8"},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("got %d diagnostics %v; want %d", len(diagnostics), diagnostics, len(expected))
+	}
+	for i, want := range expected {
+		got := diagnostics[i]
+		if got.Offset != want.Offset || got.Line != want.Line || got.Column != want.Column || got.Code != want.Code {
+			t.Errorf("diagnostic %d = %+v; want offset %d at %d:%d with code %q",
+				i, got, want.Offset, want.Line, want.Column, want.Code)
+		}
+	}
+	if s := diagnostics[0].String(); s != "2:5: invalid UTF-8: ff fe kept as-is" {
+		t.Errorf("diagnostic String() = %q", s)
+	}
+}
+
+func TestValidInputHasNoDiagnostics(t *testing.T) {
+	processor := fsm.NewProcessor()
+	processor.Process("bad \xff")
+	processor.Process("all good here")
+	if d := processor.Diagnostics(); len(d) != 0 {
+		t.Errorf("expected no diagnostics, got %v", d)
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	for _, name := range []string{"none", "NFC", "nfd"} {
+		if _, err := fsm.ParseNormalization(name); err != nil {
+			t.Errorf("ParseNormalization(%q) error: %v", name, err)
+		}
+	}
+	if _, err := fsm.ParseNormalization("nfkc"); err == nil {
+		t.Error("ParseNormalization(\"nfkc\") should fail")
+	}
+}
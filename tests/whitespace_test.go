@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"go-reloaded/fsm"
+	"testing"
+)
+
+// ==================== WHITESPACE FIDELITY TESTS ====================
+
+func TestPreserveWhitespace(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"tab separated columns", "name\tage\tcity\nbob\t42\tathens", "name\tage\tcity\nbob\t42\tathens"},
+		{"indentation", "    indented line\n        nested line", "    indented line\n        nested line"},
+		{"double spacing", "one  two   three", "one  two   three"},
+		{"punctuation normalized", "Hello  ,  world !  Next   sentence .", "Hello, world! Next   sentence."},
+		{"quotes normalized", "He said  '  hello   there  '  to me", "He said 'hello   there' to me"},
+		{"indented quote", "  ' quoted ' at start", "  'quoted' at start"},
+		{"modifier keeps following gap", "    more  (up)\there", "    MORE\there"},
+		{"article keeps gap", "a\tapple", "an\tapple"},
+		{"trailing blanks dropped", "trailing   \nline  ", "trailing\nline"},
+	}
+
+	processor := fsm.NewProcessorWithOptions(fsm.Options{PreserveWhitespace: true})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestDefaultCollapsesWhitespace(t *testing.T) {
+	input := "    one\t\ttwo   three\n  four"
+	expected := "one two three\nfour"
+
+	processor := fsm.NewProcessor()
+	result := processor.Process(input)
+
+	if result != expected {
+		t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", input, expected, result)
+	}
+}
+
+func TestDefaultKeepsTypographicSpaces(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"no-break space", "ten\u00a0dollars (up)", "ten\u00a0DOLLARS"},
+		{"article", "a\u202fapple", "an\u202fapple"},
+		{"mixed with plain spaces", "one \u00a0two", "one two"},
+		{"touching punctuation", "wait\u00a0, what", "wait, what"},
+		{"no wrap at a no-break space", "aaaa bbbb\u00a0cccc dd", "aaaa bbbb\u00a0cccc\ndd"},
+	}
+
+	processor := fsm.NewProcessorWithOptions(fsm.Options{WrapWidth: 10})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := processor.Process(tt.input)
+			if result != tt.expected {
+				t.Errorf("\nInput:    %q\nExpected: %q\nGot:      %q", tt.input, tt.expected, result)
+			}
+		})
+	}
+}